@@ -26,6 +26,9 @@ func main() {
 
 	search.DefineElasticClient()
 	search.InitAuditLogger()
+	search.InitPMCClient()
+	search.InitLiteratureProviders()
+	search.StartSavedSearchAlerts()
 
 	router := gin.Default()
 
@@ -56,9 +59,27 @@ func main() {
 	router.POST("/mappings/tools", search.DefineToolMappings)
 	router.POST("/mappings/data_providers", search.DefineDataProviderMappings)
 	router.POST("/mappings/data_custodian_networks", search.DefineDataCustodianNetworkMappings)
+	router.POST("/mappings/epmc_cache", search.DefineEPMCCacheMappings)
+	router.POST("/mappings/saved_searches", search.DefineSavedSearchMappings)
+	router.POST("/mappings/alerts/:entity", search.DefineAlertMappings)
+
+	router.POST("/suggest", search.Suggest)
 
 	router.POST("/filters", search.ListFilters)
-	router.POST("/similar/datasets", search.SearchSimilarDatasets)
+	router.GET("/filters/:entity", search.EntityFilters)
+	router.POST("/filters/:entity/values", search.SearchFacetValues)
+	router.POST("/similar/:entity", search.SearchSimilar)
+	router.POST("/export/:entity", search.ExportSearch)
+	router.GET("/related/:entity/:id", search.RelatedItems)
+
+	router.POST("/saved_searches", search.CreateSavedSearch)
+	router.GET("/saved_searches", search.ListSavedSearches)
+	router.DELETE("/saved_searches/:id", search.DeleteSavedSearch)
+	router.POST("/saved_searches/:id/run", search.RunSavedSearch)
+
+	router.POST("/alerts", search.RegisterAlert)
+	router.DELETE("/alerts/:entity/:id", search.DeleteAlert)
+	router.POST("/alerts/:entity/percolate", search.PercolateDocument)
 
 	router.POST("/search/federated_papers/doi", search.DOISearch)
 	router.POST("/search/federated_papers/field_search", search.FieldSearch)
@@ -0,0 +1,461 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// alertQueryField is the percolator field of the alert indices, holding the
+// compiled elastic query of each alert.
+const alertQueryField = "alertQuery"
+
+// alertPercolateSize is the number of alerts matching a document read at a
+// time.
+const alertPercolateSize = 1000
+
+// entityPipelines maps entities to the default ingest pipeline of their
+// index, run on documents before they are percolated so that alerts can
+// filter on the fields it populates.
+var entityPipelines = map[string]string{
+	"datasets":       datasetFacetPathsPipeline,
+	"dur":            indexedAtPipeline,
+	"data_providers": geographicCoveragePipeline,
+}
+
+// alertIndex returns the name of the percolator index holding the alerts
+// registered for entity.
+func alertIndex(entity searchEntity) string {
+	return entity.Index + "_alerts"
+}
+
+// Alert notifies a user when a document matching Query is indexed.
+type Alert struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Name      string    `json:"name"`
+	Entity    string    `json:"entity"`
+	Query     Query     `json:"query"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// PercolateRequest is a document being indexed, checked against the alerts
+// registered for its entity.
+type PercolateRequest struct {
+	ID       string                 `json:"id"`
+	Document map[string]interface{} `json:"document"`
+}
+
+// PercolateResponse holds the alerts matched by a document.
+type PercolateResponse struct {
+	ID       string   `json:"id"`
+	AlertIDs []string `json:"alertIds"`
+}
+
+// DefineAlertMappings initialises the percolator index holding the alerts of
+// an entity. The percolator index needs the mappings and analyzers of the
+// entity's index to compile the alert queries, so these are copied from it,
+// and it must be recreated whenever the entity's index is.
+func DefineAlertMappings(c *gin.Context) {
+	entityName := c.Param("entity")
+	entity, ok := searchEntities[entityName]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("entity %s not recognised", entityName)})
+		return
+	}
+
+	properties, analysis, err := indexDefinition(c.Request.Context(), entity.Index)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	properties[alertQueryField] = gin.H{"type": "percolator"}
+	properties["alert"] = gin.H{
+		"properties": gin.H{
+			"id":        gin.H{"type": "keyword"},
+			"userId":    gin.H{"type": "keyword"},
+			"name":      gin.H{"type": "keyword"},
+			"entity":    gin.H{"type": "keyword"},
+			"query":     gin.H{"type": "object", "enabled": false},
+			"createdAt": gin.H{"type": "date"},
+		},
+	}
+
+	var buf bytes.Buffer
+	elasticMappings := gin.H{"mappings": gin.H{"properties": properties}}
+	if analysis != nil {
+		elasticMappings["settings"] = gin.H{"index": gin.H{"analysis": analysis}}
+	}
+	if err := json.NewEncoder(&buf).Encode(elasticMappings); err != nil {
+		slog.Debug(fmt.Sprintf(
+			"Failed to encode elastic query %s with %s",
+			elasticMappings,
+			err.Error()),
+		)
+	}
+
+	request := esapi.IndicesCreateRequest{
+		Index: alertIndex(entity),
+		Body:  &buf,
+	}
+	response, err := request.Do(c.Request.Context(), ElasticClient)
+	if err != nil {
+		pubSubAudit(
+			"update mappings",
+			"alerts",
+			fmt.Sprintf("%s alert mappings failed to update with error: %s", entityName, err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		slog.Debug(fmt.Sprintf(
+			"Failed to read elastic response with %s",
+			err.Error()),
+		)
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(body, &resp)
+
+	pubSubAudit("update mappings", "alerts", fmt.Sprintf("%s alert mappings sucessfully updated", entityName))
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// indexDefinition reads the field mappings and analysis settings of index.
+func indexDefinition(ctx context.Context, index string) (map[string]interface{}, map[string]interface{}, error) {
+	mappingBody, err := readElasticResponse(ElasticClient.Indices.GetMapping(
+		ElasticClient.Indices.GetMapping.WithContext(ctx),
+		ElasticClient.Indices.GetMapping.WithIndex(index),
+	))
+	if err != nil {
+		return nil, nil, err
+	}
+	var mappings map[string]struct {
+		Mappings struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal(mappingBody, &mappings); err != nil {
+		return nil, nil, err
+	}
+
+	settingsBody, err := readElasticResponse(ElasticClient.Indices.GetSettings(
+		ElasticClient.Indices.GetSettings.WithContext(ctx),
+		ElasticClient.Indices.GetSettings.WithIndex(index),
+	))
+	if err != nil {
+		return nil, nil, err
+	}
+	var settings map[string]struct {
+		Settings struct {
+			Index struct {
+				Analysis map[string]interface{} `json:"analysis"`
+			} `json:"index"`
+		} `json:"settings"`
+	}
+	if err := json.Unmarshal(settingsBody, &settings); err != nil {
+		return nil, nil, err
+	}
+
+	properties := map[string]interface{}{}
+	for _, mapping := range mappings {
+		for field, property := range mapping.Mappings.Properties {
+			properties[field] = property
+		}
+	}
+	var analysis map[string]interface{}
+	for _, setting := range settings {
+		analysis = setting.Settings.Index.Analysis
+	}
+	return properties, analysis, nil
+}
+
+// readElasticResponse reads the body of a successful elastic response.
+func readElasticResponse(response *esapi.Response, err error) ([]byte, error) {
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to execute elastic request with %s", err.Error()))
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Failed to read elastic response with %s", err.Error()))
+		return nil, err
+	}
+	if response.IsError() {
+		slog.Warn(fmt.Sprintf("Elastic request returned status %d: %s", response.StatusCode, body))
+		return nil, fmt.Errorf("elastic responded with status %d", response.StatusCode)
+	}
+	return body, nil
+}
+
+/*
+RegisterAlert registers an alert notifying a user when a document matching
+a search is indexed, from a request of the form
+
+	{
+		"userId": "123",
+		"name": "New asthma datasets",
+		"entity": "datasets",
+		"query": {"query": "asthma", "filters": {"dataset": {...}}}
+	}
+
+The search is compiled by the entity's query builder and stored in the
+entity's percolator index.
+*/
+func RegisterAlert(c *gin.Context) {
+	var alert Alert
+	if err := c.BindJSON(&alert); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to interpret alert with %s", err.Error()))
+		return
+	}
+	if err := validateAlert(alert); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	alert.ID = uuid.New().String()
+	alert.CreatedAt = time.Now().UTC()
+
+	var buf bytes.Buffer
+	document := gin.H{
+		alertQueryField: compiledQuery(entityElasticConfigs[alert.Entity](alert.Query), alert.Query),
+		"alert":         alert,
+	}
+	if err := json.NewEncoder(&buf).Encode(document); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to encode alert with %s", err.Error()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := readElasticResponse(ElasticClient.Index(
+		alertIndex(searchEntities[alert.Entity]),
+		&buf,
+		ElasticClient.Index.WithContext(c.Request.Context()),
+		ElasticClient.Index.WithDocumentID(alert.ID),
+	))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, alert)
+}
+
+// validateAlert checks an alert has a user, a name and a valid query of an
+// entity alerts can be registered for. Searches by id are not allowed, as the
+// documents they match are already indexed.
+func validateAlert(alert Alert) error {
+	if alert.UserID == "" || alert.Name == "" {
+		return fmt.Errorf("userId and name are required")
+	}
+	if _, ok := entityElasticConfigs[alert.Entity]; !ok {
+		return fmt.Errorf("alerts cannot be registered for entity %s", alert.Entity)
+	}
+	if len(alert.Query.IDs) > 0 {
+		return fmt.Errorf("alerts cannot search by ids")
+	}
+	return validateQuery(alert.Query, alert.Entity)
+}
+
+// DeleteAlert deletes the alert with the given id from the alerts of entity.
+func DeleteAlert(c *gin.Context) {
+	entityName := c.Param("entity")
+	entity, ok := searchEntities[entityName]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("entity %s not recognised", entityName)})
+		return
+	}
+
+	id := c.Param("id")
+	response, err := ElasticClient.Delete(
+		alertIndex(entity),
+		url.PathEscape(id),
+		ElasticClient.Delete.WithContext(c.Request.Context()),
+	)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to delete alert %s with %s", id, err.Error()))
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("alert %s not found", id)})
+	case response.IsError():
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("elastic responded with status %d", response.StatusCode)})
+	default:
+		c.JSON(http.StatusOK, gin.H{"id": id, "deleted": true})
+	}
+}
+
+/*
+PercolateDocument checks a new or updated document against the alerts of an
+entity, called by the ingestion process with a request of the form
+
+	{
+		"id": "123",
+		"document": {"title": "Asthma dataset", ...}
+	}
+
+It returns the ids of the alerts matched and publishes a notification event
+for each to the PUBSUB_ALERT_TOPIC_NAME topic in the background. Nothing is
+published if the alerts cannot be searched.
+*/
+func PercolateDocument(c *gin.Context) {
+	entityName := c.Param("entity")
+	entity, ok := searchEntities[entityName]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("entity %s not recognised", entityName)})
+		return
+	}
+
+	var percolate PercolateRequest
+	if err := c.BindJSON(&percolate); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to interpret percolate request with %s", err.Error()))
+		return
+	}
+	if percolate.ID == "" || percolate.Document == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id and document are required"})
+		return
+	}
+
+	document := percolate.Document
+	if pipeline, ok := entityPipelines[entityName]; ok {
+		simulated, err := simulatePipeline(c.Request.Context(), pipeline, document)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+		document = simulated
+	}
+
+	alerts, err := percolateAlerts(c.Request.Context(), alertIndex(entity), document)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	alertIDs := []string{}
+	for _, alert := range alerts {
+		alertIDs = append(alertIDs, alert.ID)
+		go publishAlert(alert, percolate.ID)
+	}
+	c.JSON(http.StatusOK, PercolateResponse{ID: percolate.ID, AlertIDs: alertIDs})
+}
+
+// percolateAlerts returns every alert in index whose query matches document,
+// reading them alertPercolateSize at a time in order of id.
+func percolateAlerts(ctx context.Context, index string, document map[string]interface{}) ([]Alert, error) {
+	alerts := []Alert{}
+	var after []interface{}
+	for {
+		elasticQuery := gin.H{
+			"size":    alertPercolateSize,
+			"_source": []string{"alert"},
+			"query": gin.H{
+				"percolate": gin.H{
+					"field":    alertQueryField,
+					"document": document,
+				},
+			},
+			"sort": []gin.H{{"alert.id": "asc"}},
+		}
+		if after != nil {
+			elasticQuery["search_after"] = after
+		}
+
+		var response struct {
+			Hits struct {
+				Hits []struct {
+					Hit
+					Sort []interface{} `json:"sort"`
+				} `json:"hits"`
+			} `json:"hits"`
+		}
+		if err := searchIndexInto(ctx, index, elasticQuery, &response); err != nil {
+			return nil, err
+		}
+
+		hits := response.Hits.Hits
+		for _, hit := range hits {
+			body, err := json.Marshal(hit.Source["alert"])
+			if err != nil {
+				continue
+			}
+			var alert Alert
+			if err := json.Unmarshal(body, &alert); err != nil {
+				slog.Debug(fmt.Sprintf("Failed to unmarshal alert %s with %s", hit.Id, err.Error()))
+				continue
+			}
+			alerts = append(alerts, alert)
+		}
+		if len(hits) < alertPercolateSize || len(hits[len(hits)-1].Sort) == 0 {
+			return alerts, nil
+		}
+		after = hits[len(hits)-1].Sort
+	}
+}
+
+// simulatePipeline runs the ingest pipeline on document without indexing it,
+// returning the document as it would be indexed.
+func simulatePipeline(ctx context.Context, pipeline string, document map[string]interface{}) (map[string]interface{}, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(gin.H{"docs": []gin.H{{"_source": document}}}); err != nil {
+		return nil, err
+	}
+
+	body, err := readElasticResponse(ElasticClient.Ingest.Simulate(
+		&buf,
+		ElasticClient.Ingest.Simulate.WithContext(ctx),
+		ElasticClient.Ingest.Simulate.WithPipelineID(pipeline),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var simulateResp struct {
+		Docs []struct {
+			Doc struct {
+				Source map[string]interface{} `json:"_source"`
+			} `json:"doc"`
+		} `json:"docs"`
+	}
+	if err := json.Unmarshal(body, &simulateResp); err != nil {
+		return nil, err
+	}
+	if len(simulateResp.Docs) == 0 || simulateResp.Docs[0].Doc.Source == nil {
+		return nil, fmt.Errorf("pipeline %s returned no document", pipeline)
+	}
+	return simulateResp.Docs[0].Doc.Source, nil
+}
+
+// publishAlert publishes a notification event for an alert matched by the
+// document with the given id, for the gateway to notify the user.
+func publishAlert(alert Alert, documentID string) {
+	pubSubPublish(os.Getenv("PUBSUB_ALERT_TOPIC_NAME"), gin.H{
+		"action_type":    "matching document",
+		"action_name":    alert.Entity,
+		"action_service": os.Getenv("PUBSUB_SERVICE_NAME"),
+		"description":    fmt.Sprintf("%s %s matches alert %s", alert.Entity, documentID, alert.Name),
+		"created_at":     time.Now().UnixMicro(),
+		"alert_id":       alert.ID,
+		"user_id":        alert.UserID,
+		"result_ids":     []string{documentID},
+	})
+}
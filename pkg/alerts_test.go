@@ -0,0 +1,159 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"hdruk/search-service/utils/mocks"
+)
+
+func TestCompiledQuery(t *testing.T) {
+	query := Query{
+		Filters: map[string]map[string]interface{}{
+			"dataset": {"publisherName": []interface{}{"Publisher A"}},
+		},
+	}
+	elasticQuery := datasetElasticConfig(query)
+
+	compiled := compiledQuery(elasticQuery, query)
+
+	boolQuery := compiled["bool"].(gin.H)
+	assert.EqualValues(t, []interface{}{gin.H{"match_all": gin.H{}}}, boolQuery["must"])
+	assert.EqualValues(t, []interface{}{elasticQuery["post_filter"]}, boolQuery["filter"])
+
+	query.IDs = []string{"1", "2"}
+	compiled = compiledQuery(datasetElasticConfig(query), query)
+	assert.EqualValues(t, []interface{}{gin.H{"terms": gin.H{"_id": query.IDs}}}, compiled["bool"].(gin.H)["must"])
+
+	query.IDs = nil
+	query.QueryString = "asthma"
+	elasticQuery = datasetElasticConfig(query)
+	compiled = compiledQuery(elasticQuery, query)
+	assert.EqualValues(t, []interface{}{elasticQuery["query"]}, compiled["bool"].(gin.H)["must"])
+}
+
+func TestValidateAlert(t *testing.T) {
+	alert := Alert{UserID: "1", Name: "Asthma", Entity: "tools", Query: Query{QueryString: "asthma"}}
+	assert.Nil(t, validateAlert(alert))
+
+	alert.Entity = "papers"
+	assert.NotNil(t, validateAlert(alert))
+
+	alert.Entity = "datasets"
+	alert.Query.IDs = []string{"1"}
+	assert.NotNil(t, validateAlert(alert))
+}
+
+func TestDefineAlertMappings(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	var createBody string
+	mockElasticResponses(func(req *http.Request) string {
+		switch {
+		case req.URL.Path == "/tool/_mapping":
+			return `{"tool": {"mappings": {"properties": {"name": {"type": "text"}}}}}`
+		case req.URL.Path == "/tool/_settings":
+			return `{"tool": {"settings": {"index": {"analysis": {"analyzer": {"custom": {"tokenizer": "standard"}}}}}}}`
+		default:
+			body, _ := io.ReadAll(req.Body)
+			createBody = string(body)
+			return `{"acknowledged": true}`
+		}
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Params = gin.Params{{Key: "entity", Value: "tools"}}
+
+	DefineAlertMappings(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	var created map[string]map[string]interface{}
+	json.Unmarshal([]byte(createBody), &created)
+	properties := created["mappings"]["properties"].(map[string]interface{})
+	assert.Contains(t, properties, "name")
+	assert.EqualValues(t, map[string]interface{}{"type": "percolator"}, properties[alertQueryField])
+	assert.Contains(t, createBody, `"analysis":{"analyzer":{"custom"`)
+}
+
+func TestPercolateDocument(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	var percolateBody string
+	mockElasticResponses(func(req *http.Request) string {
+		body, _ := io.ReadAll(req.Body)
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/_ingest/pipeline/"+datasetFacetPathsPipeline+"/_simulate"):
+			return `{"docs": [{"doc": {"_source": {"title": "Asthma", "dataTypePath": ["Health"]}}}]}`
+		default:
+			percolateBody = string(body)
+			return `{"hits": {"hits": [{"_id": "a1", "_score": 1, "_source": {"alert": {"id": "a1", "userId": "1", "name": "Asthma"}}}]}}`
+		}
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Params = gin.Params{{Key: "entity", Value: "datasets"}}
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Body = io.NopCloser(strings.NewReader(`{"id": "7", "document": {"title": "Asthma"}}`))
+
+	PercolateDocument(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	var testResp PercolateResponse
+	json.Unmarshal(w.Body.Bytes(), &testResp)
+	assert.EqualValues(t, PercolateResponse{ID: "7", AlertIDs: []string{"a1"}}, testResp)
+	assert.Contains(t, percolateBody, `"dataTypePath":["Health"]`)
+	assert.Contains(t, percolateBody, `"field":"`+alertQueryField+`"`)
+}
+
+func TestPercolateDocumentPages(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	bodies := []string{}
+	mockElasticResponses(func(req *http.Request) string {
+		body, _ := io.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) > 1 {
+			return `{"hits": {"hits": [{"_id": "last", "_source": {"alert": {"id": "last"}}, "sort": ["last"]}]}}`
+		}
+		hits := []string{}
+		for i := 0; i < alertPercolateSize; i++ {
+			hits = append(hits, fmt.Sprintf(`{"_id": "a%04d", "_source": {"alert": {"id": "a%04d"}}, "sort": ["a%04d"]}`, i, i, i))
+		}
+		return `{"hits": {"hits": [` + strings.Join(hits, ",") + `]}}`
+	})
+
+	alerts, err := percolateAlerts(context.Background(), "tool_alerts", map[string]interface{}{"name": "A"})
+
+	assert.Nil(t, err)
+	assert.Len(t, alerts, alertPercolateSize+1)
+	assert.EqualValues(t, "last", alerts[alertPercolateSize].ID)
+	assert.Len(t, bodies, 2)
+	assert.Contains(t, bodies[1], fmt.Sprintf(`"search_after":["a%04d"]`, alertPercolateSize-1))
+}
+
+func TestPercolateDocumentFailed(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	mockElasticResponses(func(req *http.Request) string {
+		return `{"error": {"root_cause": [{"type": "index_not_found_exception", "reason": "no such index [tool_alerts]"}]}, "status": 404}`
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Params = gin.Params{{Key: "entity", Value: "tools"}}
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Body = io.NopCloser(strings.NewReader(`{"id": "7", "document": {"name": "A"}}`))
+
+	PercolateDocument(c)
+
+	assert.EqualValues(t, http.StatusBadGateway, w.Code)
+}
@@ -16,10 +16,11 @@ import (
 // Creating a new client per call would open a new gRPC connection each time.
 var pubsubClient *pubsub.Client
 
-// InitAuditLogger creates the PubSub client singleton. Must be called after
-// godotenv.Load() in main so that env vars are available.
+// InitAuditLogger creates the PubSub client singleton, used by audit logging
+// and search alerts. Must be called after godotenv.Load() in main so that env
+// vars are available.
 func InitAuditLogger() {
-	if os.Getenv("AUDIT_LOG_ENABLED") != "true" {
+	if os.Getenv("AUDIT_LOG_ENABLED") != "true" && os.Getenv("PUBSUB_ALERT_TOPIC_NAME") == "" {
 		return
 	}
 	ctx := context.Background()
@@ -31,22 +32,28 @@ func InitAuditLogger() {
 }
 
 func pubSubAudit(actionType string, actionName string, description string) {
-	if os.Getenv("AUDIT_LOG_ENABLED") != "true" || pubsubClient == nil {
+	if os.Getenv("AUDIT_LOG_ENABLED") != "true" {
 		return
 	}
 
-	ctx := context.Background()
-	topicName := os.Getenv("PUBSUB_TOPIC_NAME")
-	serviceName := os.Getenv("PUBSUB_SERVICE_NAME")
-
-	messageJson := gin.H{
+	pubSubPublish(os.Getenv("PUBSUB_TOPIC_NAME"), gin.H{
 		"action_type":    actionType,
 		"action_name":    actionName,
-		"action_service": serviceName,
+		"action_service": os.Getenv("PUBSUB_SERVICE_NAME"),
 		"description":    description,
 		"created_at":     time.Now().UnixMicro(),
+	})
+}
+
+// pubSubPublish publishes message as JSON to the topic, if the PubSub client
+// was created at startup and a topic is given.
+func pubSubPublish(topicName string, message gin.H) {
+	if pubsubClient == nil || topicName == "" {
+		return
 	}
-	messageByte, err := json.Marshal(messageJson)
+
+	ctx := context.Background()
+	messageByte, err := json.Marshal(message)
 	if err != nil {
 		log.Print(err.Error())
 		return
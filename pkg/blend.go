@@ -0,0 +1,203 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Modes of SearchGeneric. Grouped returns the results of each entity
+// separately, while blended returns a single list ranked across entities.
+const (
+	modeGrouped = "grouped"
+	modeBlended = "blended"
+)
+
+// Normalisations of the scores of each entity before blending.
+const (
+	// normaliseMinMax scales scores to between 0 and 1 from the lowest to the
+	// highest score of the entity's hits.
+	normaliseMinMax = "minmax"
+	// normaliseRank scores hits by the reciprocal of their rank, 1 for the
+	// top hit of each entity, 1/2 for the second and so on.
+	normaliseRank = "rank"
+)
+
+// maxBlendSize is the most hits in a blended list.
+const maxBlendSize = 1000
+
+// BlendOptions represents the options of a search in blended mode. All fields
+// are optional:
+//   - Normalisation is minmax (the default) or rank.
+//   - Weights multiply the normalised scores of each entity, keyed as in the
+//     grouped results, e.g. {"dataset": 2, "tool": 0.5}, defaulting to 1. An
+//     entity with weight 0 is left out of the list, but still counted.
+//   - Size is the number of hits in the list, defaulting to SEARCH_NO_RECORDS.
+type BlendOptions struct {
+	Normalisation string             `json:"normalisation"`
+	Weights       map[string]float64 `json:"weights"`
+	Size          int                `json:"size"`
+}
+
+// BlendedHit is a hit in a blended list, tagged with the key of its entity in
+// the grouped results and its blended score.
+type BlendedHit struct {
+	Hit
+	Entity       string  `json:"entity"`
+	BlendedScore float64 `json:"blended_score"`
+}
+
+// BlendedResponse is the result of a search in blended mode, with the number
+// of hits of each entity for tab counts and the errors of any entities whose
+// search failed.
+type BlendedResponse struct {
+	Hits   []BlendedHit      `json:"hits"`
+	Totals map[string]int    `json:"totals"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// validateBlend checks the mode of a Query and its blend options.
+func validateBlend(query Query) error {
+	if query.Mode != "" && query.Mode != modeGrouped && query.Mode != modeBlended {
+		return fmt.Errorf("mode must be one of %s or %s", modeGrouped, modeBlended)
+	}
+	options := query.Blend
+	if options.Normalisation != "" && options.Normalisation != normaliseMinMax && options.Normalisation != normaliseRank {
+		return fmt.Errorf("normalisation must be one of %s or %s", normaliseMinMax, normaliseRank)
+	}
+	if options.Size < 0 || options.Size > maxBlendSize {
+		return fmt.Errorf("blend size must be from 0 to %d", maxBlendSize)
+	}
+	for key, weight := range options.Weights {
+		found := false
+		for _, search := range genericSearches {
+			if search.ResultKey == key {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("weight given for unknown entity %s", key)
+		}
+		if weight < 0 {
+			return fmt.Errorf("weight of %s must not be negative", key)
+		}
+	}
+	return nil
+}
+
+// blendSize returns the number of hits in a blended list.
+func blendSize(options BlendOptions) int {
+	if options.Size > 0 {
+		return options.Size
+	}
+	return searchNoRecords
+}
+
+// blendedSearch runs the search of every entity in a single multi search
+// request, and blends their hits into one list.
+func blendedSearch(ctx context.Context, query Query) (BlendedResponse, error) {
+	size := blendSize(query.Blend)
+	requests := []multiSearchRequest{}
+	for _, search := range genericSearches {
+		entity := searchEntities[search.Entity]
+		body := gin.H{}
+		for key, value := range entityElasticConfigs[search.Entity](query) {
+			body[key] = value
+		}
+		delete(body, "aggs")
+		body["size"] = size
+		body["explain"] = false
+		body["track_total_hits"] = true
+		if source := sourceFilter(entity.Index, query); source != nil {
+			body["_source"] = source
+		}
+		requests = append(requests, multiSearchRequest{Index: entity.Index, Body: body})
+	}
+
+	responses, err := multiSearch(ctx, requests)
+	if err != nil {
+		return BlendedResponse{}, err
+	}
+
+	results := map[string]SearchResponse{}
+	errors := map[string]string{}
+	for i, search := range genericSearches {
+		results[search.ResultKey] = responses[i].Response
+		if responses[i].Err != nil {
+			errors[search.ResultKey] = responses[i].Err.Error()
+		}
+	}
+	blended := blendResults(results, query.Blend)
+	if len(errors) > 0 {
+		blended.Errors = errors
+	}
+	return blended, nil
+}
+
+// blendResults ranks the hits of each entity in a single list by their
+// normalised score multiplied by the weight of the entity, keeping the best
+// blendSize hits. Hits with equal scores keep the order of genericSearches.
+func blendResults(results map[string]SearchResponse, options BlendOptions) BlendedResponse {
+	blended := BlendedResponse{Hits: []BlendedHit{}, Totals: map[string]int{}}
+	for _, search := range genericSearches {
+		result := results[search.ResultKey]
+		total, _ := result.Hits.Total["value"].(float64)
+		blended.Totals[search.ResultKey] = int(total)
+
+		weight, ok := options.Weights[search.ResultKey]
+		if !ok {
+			weight = 1
+		}
+		if weight == 0 {
+			continue
+		}
+
+		hits := result.Hits.Hits
+		for i, score := range normaliseScores(hits, options.Normalisation) {
+			blended.Hits = append(blended.Hits, BlendedHit{
+				Hit:          hits[i],
+				Entity:       search.ResultKey,
+				BlendedScore: score * weight,
+			})
+		}
+	}
+
+	sort.SliceStable(blended.Hits, func(i, j int) bool {
+		return blended.Hits[i].BlendedScore > blended.Hits[j].BlendedScore
+	})
+	if size := blendSize(options); len(blended.Hits) > size {
+		blended.Hits = blended.Hits[:size]
+	}
+	return blended
+}
+
+// normaliseScores returns the scores of hits, in order of rank, normalised to
+// between 0 and 1. Hits all with the same score each score 1 under min-max.
+func normaliseScores(hits []Hit, normalisation string) []float64 {
+	scores := make([]float64, len(hits))
+	if normalisation == normaliseRank {
+		for i := range hits {
+			scores[i] = 1 / float64(i+1)
+		}
+		return scores
+	}
+
+	if len(hits) == 0 {
+		return scores
+	}
+	low, high := hits[0].Score, hits[0].Score
+	for _, hit := range hits {
+		low = min(low, hit.Score)
+		high = max(high, hit.Score)
+	}
+	for i, hit := range hits {
+		if high == low {
+			scores[i] = 1
+		} else {
+			scores[i] = (hit.Score - low) / (high - low)
+		}
+	}
+	return scores
+}
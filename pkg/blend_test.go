@@ -0,0 +1,105 @@
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"hdruk/search-service/utils/mocks"
+)
+
+func TestNormaliseScores(t *testing.T) {
+	hits := []Hit{{Score: 10}, {Score: 6}, {Score: 2}}
+	assert.EqualValues(t, []float64{1, 0.5, 0}, normaliseScores(hits, normaliseMinMax))
+	assert.EqualValues(t, []float64{1, 0.5, 1.0 / 3}, normaliseScores(hits, normaliseRank))
+	assert.EqualValues(t, []float64{1, 1}, normaliseScores([]Hit{{Score: 3}, {Score: 3}}, normaliseMinMax))
+	assert.EqualValues(t, []float64{}, normaliseScores([]Hit{}, normaliseMinMax))
+}
+
+func TestBlendResults(t *testing.T) {
+	results := map[string]SearchResponse{
+		"dataset": {Hits: HitsField{
+			Total: map[string]interface{}{"value": 42.0},
+			Hits:  []Hit{{Id: "d1", Score: 20}, {Id: "d2", Score: 10}},
+		}},
+		"tool": {Hits: HitsField{
+			Total: map[string]interface{}{"value": 3.0},
+			Hits:  []Hit{{Id: "t1", Score: 2}, {Id: "t2", Score: 1.5}, {Id: "t3", Score: 1}},
+		}},
+		"publication": {Hits: HitsField{
+			Total: map[string]interface{}{"value": 1.0},
+			Hits:  []Hit{{Id: "p1", Score: 5}},
+		}},
+	}
+
+	blended := blendResults(results, BlendOptions{
+		Weights: map[string]float64{"tool": 0.8, "publication": 0},
+		Size:    4,
+	})
+
+	ids := []string{}
+	for _, hit := range blended.Hits {
+		ids = append(ids, hit.Id)
+	}
+	assert.EqualValues(t, []string{"d1", "t1", "t2", "d2"}, ids)
+	assert.EqualValues(t, "tool", blended.Hits[1].Entity)
+	assert.InDelta(t, 0.8, blended.Hits[1].BlendedScore, 1e-9)
+	assert.EqualValues(t, 42, blended.Totals["dataset"])
+	assert.EqualValues(t, 1, blended.Totals["publication"])
+	assert.EqualValues(t, 0, blended.Totals["collection"])
+}
+
+func TestValidateBlend(t *testing.T) {
+	assert.Nil(t, validateBlend(Query{Mode: modeBlended, Blend: BlendOptions{Weights: map[string]float64{"dataset": 2}}}))
+	assert.NotNil(t, validateBlend(Query{Mode: "merged"}))
+	assert.NotNil(t, validateBlend(Query{Mode: modeBlended, Blend: BlendOptions{Normalisation: "zscore"}}))
+	assert.NotNil(t, validateBlend(Query{Mode: modeBlended, Blend: BlendOptions{Weights: map[string]float64{"datasets": 2}}}))
+	assert.NotNil(t, validateBlend(Query{Mode: modeBlended, Blend: BlendOptions{Weights: map[string]float64{"tool": -1}}}))
+}
+
+func TestSearchGenericBlended(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	requests := 0
+	var msearchBody string
+	mockElasticResponses(func(req *http.Request) string {
+		requests++
+		body, _ := io.ReadAll(req.Body)
+		msearchBody = string(body)
+		responses := []string{}
+		for i := range genericSearches {
+			if i == 1 {
+				responses = append(responses, `{"error": {"type": "index_not_found_exception"}, "status": 404}`)
+				continue
+			}
+			responses = append(responses, `{"hits": {"total": {"value": 1}, "hits": [{"_id": "1", "_score": 1}]}}`)
+		}
+		return `{"responses": [` + strings.Join(responses, ",") + `]}`
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	MockPost(c)
+	body, _ := json.Marshal(Query{QueryString: "asthma", Mode: modeBlended, Blend: BlendOptions{Size: 10}})
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	SearchGeneric(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, 1, requests)
+	assert.Len(t, strings.Split(strings.TrimSpace(msearchBody), "\n"), 2*len(genericSearches))
+	assert.Contains(t, msearchBody, `{"index":"datacustodiannetwork"}`)
+
+	var testResp BlendedResponse
+	json.Unmarshal(w.Body.Bytes(), &testResp)
+	assert.Len(t, testResp.Hits, len(genericSearches)-1)
+	assert.EqualValues(t, "dataset", testResp.Hits[0].Entity)
+	assert.EqualValues(t, 0, testResp.Totals["tool"])
+	assert.EqualValues(t, "search of index tool failed: index_not_found_exception", testResp.Errors["tool"])
+	assert.EqualValues(t, 1, testResp.Totals["publication"])
+}
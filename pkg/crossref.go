@@ -0,0 +1,223 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// crossrefWork represents the metadata returned by the Crossref REST API for
+// each work.
+type crossrefWork struct {
+	DOI    string   `json:"DOI"`
+	Title  []string `json:"title"`
+	Author []struct {
+		Given  string `json:"given"`
+		Family string `json:"family"`
+		Name   string `json:"name"`
+	} `json:"author"`
+	ContainerTitle []string `json:"container-title"`
+	Published      struct {
+		DateParts [][]int `json:"date-parts"`
+	} `json:"published"`
+	Abstract string `json:"abstract"`
+	Type     string `json:"type"`
+	URL      string `json:"URL"`
+}
+
+// crossrefWorkResponse is returned by the Crossref works/{doi} route.
+type crossrefWorkResponse struct {
+	Message crossrefWork `json:"message"`
+}
+
+// crossrefSearchResponse is returned by the Crossref works route.
+type crossrefSearchResponse struct {
+	Message struct {
+		TotalResults int            `json:"total-results"`
+		NextCursor   string         `json:"next-cursor"`
+		Items        []crossrefWork `json:"items"`
+	} `json:"message"`
+}
+
+// CrossrefProvider searches DOI metadata registered with Crossref. Crossref
+// holds no publication type matching "Review articles", so a search filtered
+// only by types Crossref lacks returns no results.
+type CrossrefProvider struct {
+	Client  HTTPClient
+	BaseURL string
+	Mailto  string
+}
+
+// NewCrossrefProvider returns a provider querying the Crossref REST API at
+// baseURL. mailto is sent with each request to use Crossref's polite pool.
+func NewCrossrefProvider(client HTTPClient, baseURL string, mailto string) *CrossrefProvider {
+	return &CrossrefProvider{Client: client, BaseURL: strings.TrimSuffix(baseURL, "/"), Mailto: mailto}
+}
+
+var jatsTags = regexp.MustCompile(`<[^>]+>`)
+
+func (p *CrossrefProvider) Name() string {
+	return "crossref"
+}
+
+func (p *CrossrefProvider) SearchDOI(ctx context.Context, query Query) (LiteratureResult, error) {
+	result := LiteratureResult{Papers: []Paper{}}
+	doi := normaliseDOI(query.QueryString)
+	if doi == "" {
+		return result, nil
+	}
+
+	urlPath := fmt.Sprintf("%s/works/%s", p.BaseURL, url.PathEscape(doi))
+	if p.Mailto != "" {
+		urlPath = fmt.Sprintf("%s?%s", urlPath, url.Values{"mailto": {p.Mailto}}.Encode())
+	}
+	body, found, err := getLiterature(ctx, p.Client, "Crossref", urlPath)
+	if err != nil || !found {
+		return result, err
+	}
+
+	var work crossrefWorkResponse
+	if err := json.Unmarshal(body, &work); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to unmarshal Crossref response with: %s", err.Error()))
+		return result, err
+	}
+	result.HitCount = 1
+	result.Papers = append(result.Papers, paperFromCrossref(work.Message))
+	return result, nil
+}
+
+func (p *CrossrefProvider) SearchFields(ctx context.Context, query FieldQuery) (LiteratureResult, error) {
+	result := LiteratureResult{Papers: []Paper{}}
+	if !crossrefFiltersTypes(query.Filters) {
+		return result, nil
+	}
+
+	body, found, err := getLiterature(ctx, p.Client, "Crossref", p.searchURL(query))
+	if err != nil || !found {
+		return result, err
+	}
+
+	var search crossrefSearchResponse
+	if err := json.Unmarshal(body, &search); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to unmarshal Crossref response with: %s", err.Error()))
+		return result, err
+	}
+	result.HitCount = search.Message.TotalResults
+	result.NextCursorMark = search.Message.NextCursor
+	for _, work := range search.Message.Items {
+		result.Papers = append(result.Papers, paperFromCrossref(work))
+	}
+	return result, nil
+}
+
+// searchURL builds the url of a Crossref works search. Title only searches
+// use the bibliographic query, which covers titles and citation details,
+// while any other combination of fields searches all of the metadata.
+func (p *CrossrefProvider) searchURL(query FieldQuery) string {
+	cursorMark := query.CursorMark
+	if cursorMark == "" {
+		cursorMark = initialCursorMark
+	}
+
+	params := url.Values{}
+	if len(query.Field) == 1 && query.Field[0] == "TITLE" {
+		params.Set("query.bibliographic", query.QueryString)
+	} else {
+		params.Set("query", query.QueryString)
+	}
+	params.Set("rows", strconv.Itoa(pmcPageSize(query.PageSize)))
+	params.Set("cursor", cursorMark)
+	if filter := crossrefFilter(query.Filters); filter != "" {
+		params.Set("filter", filter)
+	}
+	if p.Mailto != "" {
+		params.Set("mailto", p.Mailto)
+	}
+	return fmt.Sprintf("%s/works?%s", p.BaseURL, params.Encode())
+}
+
+// crossrefFilter converts the "paper" filters of a query into a Crossref
+// filter parameter. Repeated type filters are combined with OR by Crossref.
+func crossrefFilter(filters map[string]map[string]interface{}) string {
+	clauses := []string{}
+	if from, to, ok := paperYearRange(filters); ok {
+		if from != "" {
+			clauses = append(clauses, fmt.Sprintf("from-pub-date:%s", from))
+		}
+		if to != "" {
+			clauses = append(clauses, fmt.Sprintf("until-pub-date:%s", to))
+		}
+	}
+	for _, pubType := range paperTypeFilters(filters) {
+		for _, crossrefType := range crossrefTypes[pubType] {
+			clauses = append(clauses, fmt.Sprintf("type:%s", crossrefType))
+		}
+	}
+	return strings.Join(clauses, ",")
+}
+
+// crossrefFiltersTypes reports whether Crossref can filter by any of the
+// publication types given in the "paper" filters, or true if no types are
+// given. Some gateway publication types, such as review articles, have no
+// Crossref type, and searching without the type filter would return papers
+// of every type.
+func crossrefFiltersTypes(filters map[string]map[string]interface{}) bool {
+	pubTypes := paperTypeFilters(filters)
+	for _, pubType := range pubTypes {
+		if len(crossrefTypes[pubType]) > 0 {
+			return true
+		}
+	}
+	return len(pubTypes) == 0
+}
+
+// crossrefTypes maps the gateway publication types to Crossref work types.
+var crossrefTypes = map[string][]string{
+	"Research articles":   {"journal-article"},
+	"Preprints":           {"posted-content"},
+	"Books and documents": {"book", "book-chapter", "monograph", "report"},
+}
+
+// paperFromCrossref converts a Crossref work into the normalised Paper format.
+func paperFromCrossref(work crossrefWork) Paper {
+	paper := Paper{
+		Provider:         "crossref",
+		ID:               work.DOI,
+		DOI:              work.DOI,
+		Authors:          []string{},
+		Abstract:         strings.TrimSpace(jatsTags.ReplaceAllString(work.Abstract, "")),
+		PublicationTypes: []string{},
+		URL:              work.URL,
+	}
+	if len(work.Title) > 0 {
+		paper.Title = work.Title[0]
+	}
+	if len(work.ContainerTitle) > 0 {
+		paper.Journal = work.ContainerTitle[0]
+	}
+	if len(work.Published.DateParts) > 0 && len(work.Published.DateParts[0]) > 0 {
+		paper.PubYear = strconv.Itoa(work.Published.DateParts[0][0])
+	}
+	for _, author := range work.Author {
+		name := strings.TrimSpace(fmt.Sprintf("%s %s", author.Given, author.Family))
+		if name == "" {
+			name = author.Name
+		}
+		if name != "" {
+			paper.Authors = append(paper.Authors, name)
+		}
+	}
+	for pubType, crossrefTypes := range crossrefTypes {
+		for _, crossrefType := range crossrefTypes {
+			if work.Type == crossrefType {
+				paper.PublicationTypes = append(paper.PublicationTypes, pubType)
+			}
+		}
+	}
+	return paper
+}
@@ -0,0 +1,160 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"hdruk/search-service/utils/mocks"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var crossrefWorkJson = `{
+	"status": "ok",
+	"message-type": "work",
+	"message-version": "1.0.0",
+	"message": {
+		"DOI": "10.123/abc",
+		"type": "journal-article",
+		"title": ["A publication"],
+		"author": [
+			{"given": "Alice", "family": "Monday", "sequence": "first"},
+			{"name": "Health Data Consortium", "sequence": "additional"}
+		],
+		"container-title": ["Journal of Health"],
+		"published": {"date-parts": [[2020, 5, 1]]},
+		"abstract": "<jats:p>A longer description of the paper</jats:p>",
+		"URL": "https://doi.org/10.123/abc"
+	}
+}`
+
+var crossrefSearchJson = `{
+	"status": "ok",
+	"message-type": "work-list",
+	"message": {
+		"total-results": 2,
+		"next-cursor": "DnF1ZXJ5VGhlbkZldGNo",
+		"items": [
+			{
+				"DOI": "10.123/abc",
+				"type": "journal-article",
+				"title": ["A publication"],
+				"published": {"date-parts": [[2020]]}
+			},
+			{
+				"DOI": "10.123/def",
+				"type": "posted-content",
+				"title": ["A preprint"],
+				"published": {"date-parts": [[2021, 1]]}
+			}
+		]
+	}
+}`
+
+func mockLiteratureResponse(status int, body string, requested *string) {
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		if requested != nil {
+			*requested = req.URL.String()
+		}
+		r := io.NopCloser(bytes.NewReader([]byte(body)))
+		return &http.Response{StatusCode: status, Body: r}, nil
+	}
+}
+
+func TestCrossrefSearchDOI(t *testing.T) {
+	var requested string
+	mockLiteratureResponse(200, crossrefWorkJson, &requested)
+	provider := NewCrossrefProvider(&mocks.MockClient{}, "https://crossref.test/", "team@example.org")
+
+	result, err := provider.SearchDOI(context.Background(), Query{QueryString: "https://doi.org/10.123/ABC"})
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, "https://crossref.test/works/10.123%2Fabc?mailto=team%40example.org", requested)
+	assert.EqualValues(t, 1, result.HitCount)
+	paper := result.Papers[0]
+	assert.EqualValues(t, "crossref", paper.Provider)
+	assert.EqualValues(t, "10.123/abc", paper.DOI)
+	assert.EqualValues(t, "A publication", paper.Title)
+	assert.EqualValues(t, []string{"Alice Monday", "Health Data Consortium"}, paper.Authors)
+	assert.EqualValues(t, "Journal of Health", paper.Journal)
+	assert.EqualValues(t, "2020", paper.PubYear)
+	assert.EqualValues(t, "A longer description of the paper", paper.Abstract)
+	assert.EqualValues(t, []string{"Research articles"}, paper.PublicationTypes)
+}
+
+func TestCrossrefSearchDOINotFound(t *testing.T) {
+	mockLiteratureResponse(404, "Resource not found.", nil)
+	provider := NewCrossrefProvider(&mocks.MockClient{}, "https://crossref.test", "")
+
+	result, err := provider.SearchDOI(context.Background(), Query{QueryString: "10.123/missing"})
+
+	assert.Nil(t, err)
+	assert.Empty(t, result.Papers)
+}
+
+func TestCrossrefSearchFields(t *testing.T) {
+	var requested string
+	mockLiteratureResponse(200, crossrefSearchJson, &requested)
+	provider := NewCrossrefProvider(&mocks.MockClient{}, "https://crossref.test", "")
+
+	result, err := provider.SearchFields(context.Background(), FieldQuery{
+		QueryString: "asthma",
+		Field:       []string{"TITLE"},
+		PageSize:    2,
+		Filters: map[string]map[string]interface{}{
+			"paper": {
+				"publicationDate": []interface{}{"2020", "2021"},
+				"publicationType": []interface{}{"Preprints"},
+			},
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Contains(t, requested, "query.bibliographic=asthma")
+	assert.Contains(t, requested, "rows=2")
+	assert.Contains(t, requested, "cursor=%2A")
+	assert.Contains(t, requested, "filter=from-pub-date%3A2020%2Cuntil-pub-date%3A2021%2Ctype%3Aposted-content")
+	assert.EqualValues(t, 2, result.HitCount)
+	assert.EqualValues(t, "DnF1ZXJ5VGhlbkZldGNo", result.NextCursorMark)
+	assert.Len(t, result.Papers, 2)
+	assert.EqualValues(t, []string{"Preprints"}, result.Papers[1].PublicationTypes)
+}
+
+func TestCrossrefSearchFieldsError(t *testing.T) {
+	mockLiteratureResponse(500, "", nil)
+	provider := NewCrossrefProvider(&mocks.MockClient{}, "https://crossref.test", "")
+
+	_, err := provider.SearchFields(context.Background(), FieldQuery{QueryString: "asthma"})
+
+	assert.NotNil(t, err)
+}
+
+func TestCrossrefSearchFieldsNotFound(t *testing.T) {
+	mockLiteratureResponse(404, "Resource not found.", nil)
+	provider := NewCrossrefProvider(&mocks.MockClient{}, "https://crossref.test", "")
+
+	result, err := provider.SearchFields(context.Background(), FieldQuery{QueryString: "asthma"})
+
+	assert.Nil(t, err)
+	assert.Empty(t, result.Papers)
+}
+
+func TestCrossrefSearchFieldsUnmappedType(t *testing.T) {
+	var requested string
+	mockLiteratureResponse(200, crossrefSearchJson, &requested)
+	provider := NewCrossrefProvider(&mocks.MockClient{}, "https://crossref.test", "")
+
+	result, err := provider.SearchFields(context.Background(), FieldQuery{
+		QueryString: "asthma",
+		Filters: map[string]map[string]interface{}{
+			"paper": {"publicationType": []interface{}{"Review articles"}},
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Empty(t, requested)
+	assert.EqualValues(t, 0, result.HitCount)
+	assert.Empty(t, result.Papers)
+}
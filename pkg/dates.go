@@ -0,0 +1,124 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dateFacet describes a date filter over documents covering the period from
+// StartField to EndField, which are the same field for documents with a
+// single date. A document matches if its period overlaps the range filtered.
+type dateFacet struct {
+	StartField string
+	EndField   string
+}
+
+// dateFacets maps the keys of date filters to their definitions.
+var dateFacets = map[string]dateFacet{
+	"dateRange":       {StartField: "startDate", EndField: "endDate"},
+	"publicationDate": {StartField: "publicationDate", EndField: "publicationDate"},
+}
+
+// dateIntervals maps the intervals of date histograms to the format of their
+// bucket keys.
+var dateIntervals = map[string]string{
+	"year":  "yyyy",
+	"month": "yyyy-MM",
+}
+
+// relativeDatePattern matches the periods of "last" date filters, a number of
+// years, months, weeks or days, e.g. 5y.
+var relativeDatePattern = regexp.MustCompile(`^[1-9][0-9]*[yMwd]$`)
+
+// dateBounds reads the bounds of a date filter, given either as a list of
+// [from, to], where either may be left out or empty, or as an object of the
+// form
+//
+//	{"from": "2020-01-01", "to": "2021", "since": "2020", "last": "5y"}
+//
+// where since is another name for from, and last gives a from relative to
+// today. An empty bound leaves that end of the range open.
+func dateBounds(terms interface{}) (from string, to string, err error) {
+	switch bounds := terms.(type) {
+	case []interface{}:
+		if len(bounds) > 2 {
+			return "", "", errors.New("date filters take at most two dates")
+		}
+		if len(bounds) > 0 && bounds[0] != nil {
+			from = fmt.Sprint(bounds[0])
+		}
+		if len(bounds) > 1 && bounds[1] != nil {
+			to = fmt.Sprint(bounds[1])
+		}
+	case map[string]interface{}:
+		for _, key := range []string{"from", "since", "last"} {
+			value, ok := bounds[key]
+			if !ok || value == nil {
+				continue
+			}
+			if from != "" {
+				return "", "", errors.New("date filters take only one of from, since or last")
+			}
+			from = fmt.Sprint(value)
+			if key == "last" {
+				if !relativeDatePattern.MatchString(from) {
+					return "", "", fmt.Errorf("last %s must be a number of years, months, weeks or days, e.g. 5y", from)
+				}
+				from = fmt.Sprintf("now-%s/d", from)
+			}
+		}
+		if value, ok := bounds["to"]; ok && value != nil {
+			to = fmt.Sprint(value)
+		}
+	default:
+		return "", "", errors.New("date filters must be a list of dates or an object")
+	}
+	return from, to, nil
+}
+
+// dateFilter builds the filter clause matching documents whose period
+// overlaps the range from to to. An empty bound leaves that end open.
+func dateFilter(facet dateFacet, from string, to string) gin.H {
+	clauses := []gin.H{}
+	if to != "" {
+		clauses = append(clauses, gin.H{"range": gin.H{facet.StartField: gin.H{"lte": to}}})
+	}
+	if from != "" {
+		clauses = append(clauses, gin.H{"range": gin.H{facet.EndField: gin.H{"gte": from}}})
+	}
+	return gin.H{"bool": gin.H{"must": clauses}}
+}
+
+// dateAggregations defines the aggregations of a date facet: the earliest
+// start and latest end of the documents as startDate and endDate, and a
+// histogram of their start dates named after the key if an interval is given.
+func dateAggregations(key string, facet dateFacet, interval string) gin.H {
+	aggs := gin.H{
+		"startDate": gin.H{"min": gin.H{"field": facet.StartField}},
+		"endDate":   gin.H{"max": gin.H{"field": facet.EndField}},
+	}
+	if format, ok := dateIntervals[interval]; ok {
+		aggs[key] = gin.H{"date_histogram": gin.H{
+			"field":             facet.StartField,
+			"calendar_interval": interval,
+			"format":            format,
+			"min_doc_count":     0,
+		}}
+	}
+	return aggs
+}
+
+// validateDateAggregations checks the interval of each date aggregation in a
+// Query.
+func validateDateAggregations(aggregations []map[string]interface{}) error {
+	for _, agg := range aggregations {
+		interval, _ := agg["interval"].(string)
+		if _, ok := dateIntervals[interval]; interval != "" && !ok {
+			return fmt.Errorf("interval %s must be year or month", interval)
+		}
+	}
+	return nil
+}
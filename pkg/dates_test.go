@@ -0,0 +1,73 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDateBounds(t *testing.T) {
+	cases := []struct {
+		terms interface{}
+		from  string
+		to    string
+	}{
+		{[]interface{}{"2020", "2021"}, "2020", "2021"},
+		{[]interface{}{"2020"}, "2020", ""},
+		{[]interface{}{"", "2021"}, "", "2021"},
+		{[]interface{}{nil, "2021"}, "", "2021"},
+		{map[string]interface{}{"since": "2020"}, "2020", ""},
+		{map[string]interface{}{"last": "5y"}, "now-5y/d", ""},
+		{map[string]interface{}{"from": "2020-01-01", "to": "2020-06-30"}, "2020-01-01", "2020-06-30"},
+	}
+	for _, c := range cases {
+		from, to, err := dateBounds(c.terms)
+		assert.Nil(t, err)
+		assert.EqualValues(t, c.from, from)
+		assert.EqualValues(t, c.to, to)
+	}
+
+	_, _, err := dateBounds(map[string]interface{}{"last": "five years"})
+	assert.NotNil(t, err)
+	_, _, err = dateBounds(map[string]interface{}{"last": "5y", "since": "2020"})
+	assert.NotNil(t, err)
+	_, _, err = dateBounds([]interface{}{"2020", "2021", "2022"})
+	assert.NotNil(t, err)
+}
+
+func TestDateFilterOpenEnded(t *testing.T) {
+	assert.EqualValues(t, gin.H{"bool": gin.H{"must": []gin.H{
+		{"range": gin.H{"endDate": gin.H{"gte": "2020"}}},
+	}}}, dateFilter(dateFacets["dateRange"], "2020", ""))
+
+	filters, _ := buildFilters(map[string]interface{}{"publicationDate": []interface{}{"2020"}})
+	assert.EqualValues(t, []gin.H{{"bool": gin.H{"must": []gin.H{
+		{"range": gin.H{"publicationDate": gin.H{"gte": "2020"}}},
+	}}}}, filters)
+}
+
+func TestDateAggregationsHistogram(t *testing.T) {
+	aggs := dateAggregations("publicationDate", dateFacets["publicationDate"], "month")
+
+	assert.EqualValues(t, gin.H{"min": gin.H{"field": "publicationDate"}}, aggs["startDate"])
+	assert.EqualValues(t, gin.H{"date_histogram": gin.H{
+		"field":             "publicationDate",
+		"calendar_interval": "month",
+		"format":            "yyyy-MM",
+		"min_doc_count":     0,
+	}}, aggs["publicationDate"])
+
+	assert.NotContains(t, dateAggregations("dateRange", dateFacets["dateRange"], ""), "dateRange")
+}
+
+func TestValidateQueryDates(t *testing.T) {
+	query := Query{
+		Filters:      map[string]map[string]interface{}{"dataset": {"dateRange": map[string]interface{}{"last": "10y"}}},
+		Aggregations: []map[string]interface{}{{"type": "dataset", "keys": "dateRange", "interval": "year"}},
+	}
+	assert.Nil(t, validateQuery(query, "datasets"))
+
+	query.Aggregations[0]["interval"] = "decade"
+	assert.NotNil(t, validateQuery(query, "datasets"))
+}
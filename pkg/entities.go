@@ -0,0 +1,129 @@
+package search
+
+import "github.com/gin-gonic/gin"
+
+// searchEntity describes an entity type held in its own elastic index.
+type searchEntity struct {
+	// Index is the name of the elastic index holding the entity.
+	Index string
+	// FilterType is the key of the entity in the filters of a Query.
+	FilterType string
+	// SimilarFields are the fields compared by more_like_this queries when
+	// none are given in the request.
+	SimilarFields []string
+	// SummaryFields are the fields returned for each hit of a search with
+	// view "summary", enough to render a result card on a listing page.
+	SummaryFields []string
+	// TextFields are the fields which may be highlighted.
+	TextFields []string
+	// HighlightFields are the fields highlighted when none are given in the
+	// request.
+	HighlightFields []string
+}
+
+// searchEntities maps the entity names used in routes, e.g. /similar/tools,
+// to their definitions.
+var searchEntities = map[string]searchEntity{
+	"datasets": {
+		Index:           "dataset",
+		FilterType:      "dataset",
+		SimilarFields:   []string{"title", "abstract", "description", "keywords", "named_entities"},
+		SummaryFields:   []string{"title", "shortTitle", "abstract", "publisherName", "dataType", "geographicLocation", "populationSize", "startDate", "endDate"},
+		TextFields:      []string{"title", "shortTitle", "abstract", "description", "keywords", "named_entities", "datasetAliases"},
+		HighlightFields: []string{"description", "abstract"},
+	},
+	"tools": {
+		Index:           "tool",
+		FilterType:      "tool",
+		SimilarFields:   []string{"name", "description", "tags", "resultsInsights"},
+		SummaryFields:   []string{"name", "description", "dataProvider", "typeCategory", "license", "programmingLanguages"},
+		TextFields:      []string{"name", "description", "resultsInsights", "tags"},
+		HighlightFields: []string{"name", "description"},
+	},
+	"collections": {
+		Index:           "collection",
+		FilterType:      "collection",
+		SimilarFields:   []string{"name", "description", "keywords"},
+		SummaryFields:   []string{"name", "description", "publisherName", "dataProvider"},
+		TextFields:      []string{"name", "description", "keywords"},
+		HighlightFields: []string{"description", "name", "keywords"},
+	},
+	"dur": {
+		Index:           "datauseregister",
+		FilterType:      "dataUseRegister",
+		SimilarFields:   []string{"projectTitle", "laySummary", "publicBenefitStatement", "technicalSummary", "keywords"},
+		SummaryFields:   []string{"projectTitle", "organisationName", "publisherName", "sector", "datasetTitles"},
+		TextFields:      []string{"projectTitle", "laySummary", "publicBenefitStatement", "technicalSummary"},
+		HighlightFields: []string{"laySummary"},
+	},
+	"publications": {
+		Index:           "publication",
+		FilterType:      "paper",
+		SimilarFields:   []string{"title", "abstract", "keywords"},
+		SummaryFields:   []string{"title", "authors", "journalName", "publicationDate", "publicationType", "doi"},
+		TextFields:      []string{"title", "abstract", "journalName", "authors"},
+		HighlightFields: []string{"title", "abstract"},
+	},
+	"data_providers": {
+		Index:           "dataprovider",
+		FilterType:      "dataProvider",
+		SimilarFields:   []string{"name", "datasetTitles", "geographicLocation"},
+		SummaryFields:   []string{"name", "geographicLocation", "dataType"},
+		TextFields:      []string{"name", "teamAliases"},
+		HighlightFields: []string{"name"},
+	},
+	"data_custodian_networks": {
+		Index:           "datacustodiannetwork",
+		FilterType:      "datacustodiannetwork",
+		SimilarFields:   []string{"name", "summary"},
+		SummaryFields:   []string{"name", "summary"},
+		TextFields:      []string{"name", "summary"},
+		HighlightFields: []string{"name", "summary"},
+	},
+}
+
+// entityElasticConfigs maps the entity names to the builders of the body of
+// their searches.
+var entityElasticConfigs = map[string]func(Query) gin.H{
+	"datasets":                datasetElasticConfig,
+	"tools":                   toolsElasticConfig,
+	"collections":             collectionsElasticConfig,
+	"dur":                     dataUseElasticConfig,
+	"publications":            publicationElasticConfig,
+	"data_providers":          dataProviderElasticConfig,
+	"data_custodian_networks": dataCustodianNetworkElasticConfig,
+}
+
+// entityByIndex returns the definition of the entity held in index.
+func entityByIndex(index string) (searchEntity, bool) {
+	for _, entity := range searchEntities {
+		if entity.Index == index {
+			return entity, true
+		}
+	}
+	return searchEntity{}, false
+}
+
+// entityLink records that Field of the From entity holds the titles or names
+// of documents of the To entity, held in ToField of that entity's index.
+// Links are followed in both directions by the related items endpoint.
+type entityLink struct {
+	From    string
+	Field   string
+	To      string
+	ToField string
+}
+
+var entityLinks = []entityLink{
+	{From: "tools", Field: "datasetTitles", To: "datasets", ToField: "title.keyword"},
+	{From: "collections", Field: "datasetTitles", To: "datasets", ToField: "title.keyword"},
+	{From: "dur", Field: "datasetTitles", To: "datasets", ToField: "title.keyword"},
+	{From: "publications", Field: "datasetTitles", To: "datasets", ToField: "title.keyword"},
+	{From: "data_providers", Field: "datasetTitles", To: "datasets", ToField: "title.keyword"},
+	{From: "data_custodian_networks", Field: "datasetTitles", To: "datasets", ToField: "title.keyword"},
+	{From: "data_custodian_networks", Field: "publisherNames", To: "data_providers", ToField: "name.keyword"},
+	{From: "data_custodian_networks", Field: "durTitles", To: "dur", ToField: "projectTitle.keyword"},
+	{From: "data_custodian_networks", Field: "toolNames", To: "tools", ToField: "name.keyword"},
+	{From: "datasets", Field: "dataUseTitles", To: "dur", ToField: "projectTitle.keyword"},
+	{From: "datasets", Field: "collectionName", To: "collections", ToField: "name.keyword"},
+}
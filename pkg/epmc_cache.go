@@ -0,0 +1,287 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// epmcCacheIndex is the elastic index holding papers and query results
+// previously fetched from EuropePMC.
+const epmcCacheIndex = "epmc_cache"
+
+var (
+	pmcCacheEnabled bool
+	pmcCacheTTL     time.Duration
+)
+
+// GatewayPublication links a EuropePMC paper to the matching document in the
+// gateway's publication index.
+type GatewayPublication struct {
+	ID            string   `json:"id"`
+	DatasetTitles []string `json:"datasetTitles"`
+}
+
+// epmcCacheDocument is a document in the epmc_cache index. Kind is "paper"
+// for a single paper keyed by DOI or PMID, or "query" for a page of results
+// keyed by the EuropePMC request url.
+type epmcCacheDocument struct {
+	Kind     string           `json:"kind"`
+	CachedAt time.Time        `json:"cachedAt"`
+	DOI      string           `json:"doi,omitempty"`
+	PMID     string           `json:"pmid,omitempty"`
+	EPMCID   string           `json:"epmcId,omitempty"`
+	URL      string           `json:"url,omitempty"`
+	Paper    *PaperCore       `json:"paper,omitempty"`
+	Response *PMCCoreResponse `json:"response,omitempty"`
+}
+
+// fetchPMC returns the results of the given EuropePMC search, served from the
+// epmc_cache index when caching is enabled and a fresh copy exists. Results
+// fetched live are stored in the cache in the background. If EuropePMC cannot
+// be reached a stale cached copy is returned rather than an error.
+func fetchPMC(ctx context.Context, urlPath string) (PMCCoreResponse, error) {
+	if !pmcCacheEnabled {
+		return searchPMC(ctx, urlPath)
+	}
+
+	cached, found := getEPMCCacheDocument(ctx, queryCacheKey(urlPath))
+	if found && cached.Response != nil && time.Since(cached.CachedAt) < pmcCacheTTL {
+		return *cached.Response, nil
+	}
+
+	result, err := searchPMC(ctx, urlPath)
+	if err != nil {
+		if found && cached.Response != nil {
+			slog.Warn(fmt.Sprintf("Serving stale EPMC results from cache after error: %s", err.Error()))
+			return *cached.Response, nil
+		}
+		return result, err
+	}
+
+	storeEPMCCache(urlPath, result)
+	return result, nil
+}
+
+// cachedPaperByDOI returns the cached paper with the given DOI if caching is
+// enabled and the cached copy is fresh.
+func cachedPaperByDOI(ctx context.Context, doi string) (PMCCoreResponse, bool) {
+	if !pmcCacheEnabled || doi == "" {
+		return PMCCoreResponse{}, false
+	}
+	cached, found := getEPMCCacheDocument(ctx, paperCacheKey(PaperCore{DOI: doi}))
+	if !found || cached.Paper == nil || time.Since(cached.CachedAt) >= pmcCacheTTL {
+		return PMCCoreResponse{}, false
+	}
+	return PMCCoreResponse{
+		HitCount:   1,
+		ResultList: map[string][]PaperCore{"result": {*cached.Paper}},
+	}, true
+}
+
+func getEPMCCacheDocument(ctx context.Context, id string) (epmcCacheDocument, bool) {
+	var document epmcCacheDocument
+
+	// Document ids may contain the "/" of a DOI, which the client does not escape
+	response, err := ElasticClient.Get(epmcCacheIndex, url.PathEscape(id), ElasticClient.Get.WithContext(ctx))
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Failed to read EPMC cache with %s", err.Error()))
+		return document, false
+	}
+	defer response.Body.Close()
+	if response.IsError() {
+		return document, false
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Failed to read elastic response with %s", err.Error()))
+		return document, false
+	}
+
+	var getResp struct {
+		Found  bool              `json:"found"`
+		Source epmcCacheDocument `json:"_source"`
+	}
+	if err := json.Unmarshal(body, &getResp); err != nil || !getResp.Found {
+		return document, false
+	}
+	return getResp.Source, true
+}
+
+// storeEPMCCache indexes the page of results for urlPath, and each paper in
+// it, into the epmc_cache index. The request body is built before returning
+// so that callers are free to modify result afterwards.
+func storeEPMCCache(urlPath string, result PMCCoreResponse) {
+	body, err := epmcCacheBulkBody(urlPath, result, time.Now().UTC())
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to encode EPMC cache documents with %s", err.Error()))
+		return
+	}
+
+	go func() {
+		response, err := ElasticClient.Bulk(
+			bytes.NewReader(body),
+			ElasticClient.Bulk.WithIndex(epmcCacheIndex),
+		)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Failed to store EPMC results in cache with %s", err.Error()))
+			return
+		}
+		defer response.Body.Close()
+		if response.IsError() {
+			slog.Warn(fmt.Sprintf("Failed to store EPMC results in cache with status %d", response.StatusCode))
+		}
+	}()
+}
+
+func epmcCacheBulkBody(urlPath string, result PMCCoreResponse, cachedAt time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+
+	documents := map[string]epmcCacheDocument{
+		queryCacheKey(urlPath): {Kind: "query", CachedAt: cachedAt, URL: urlPath, Response: &result},
+	}
+	for _, paper := range result.ResultList["result"] {
+		paper := paper
+		documents[paperCacheKey(paper)] = epmcCacheDocument{
+			Kind:     "paper",
+			CachedAt: cachedAt,
+			DOI:      normaliseDOI(paper.DOI),
+			PMID:     paper.PMID,
+			EPMCID:   paper.ID,
+			Paper:    &paper,
+		}
+	}
+
+	for id, document := range documents {
+		if err := encoder.Encode(gin.H{"index": gin.H{"_id": id}}); err != nil {
+			return nil, err
+		}
+		if err := encoder.Encode(document); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// paperCacheKey returns the id of a paper in the epmc_cache index, using the
+// DOI where available and falling back to the PMID and EuropePMC id.
+func paperCacheKey(paper PaperCore) string {
+	if doi := normaliseDOI(paper.DOI); doi != "" {
+		return fmt.Sprintf("doi:%s", doi)
+	}
+	if paper.PMID != "" {
+		return fmt.Sprintf("pmid:%s", paper.PMID)
+	}
+	return fmt.Sprintf("epmc:%s", paper.ID)
+}
+
+func queryCacheKey(urlPath string) string {
+	hash := sha256.Sum256([]byte(urlPath))
+	return fmt.Sprintf("query:%s", hex.EncodeToString(hash[:]))
+}
+
+// normaliseDOI strips any resolver prefix from doi and lower cases it, as
+// DOIs are case insensitive.
+func normaliseDOI(doi string) string {
+	doi = strings.TrimSpace(doi)
+	if doi == "" {
+		return ""
+	}
+	return strings.ToLower(extractDOI(doi))
+}
+
+// annotatePublications looks up the DOIs of the given papers in the gateway's
+// publication index, setting GatewayPublication on each paper found there.
+func annotatePublications(ctx context.Context, papers []PaperCore) {
+	dois := []string{}
+	for _, paper := range papers {
+		dois = append(dois, paper.DOI)
+	}
+	publications := gatewayPublications(ctx, dois)
+	for i := range papers {
+		if publication, ok := publications[normaliseDOI(papers[i].DOI)]; ok {
+			papers[i].GatewayPublication = publication
+		}
+	}
+}
+
+// gatewayPublications returns the documents in the gateway's publication
+// index matching the given DOIs, keyed by normalised DOI.
+func gatewayPublications(ctx context.Context, candidates []string) map[string]*GatewayPublication {
+	publications := make(map[string]*GatewayPublication)
+	dois := []string{}
+	for _, candidate := range candidates {
+		if doi := normaliseDOI(candidate); doi != "" {
+			dois = append(dois, doi, fmt.Sprintf("https://doi.org/%s", doi))
+		}
+	}
+	if len(dois) == 0 {
+		return publications
+	}
+
+	// Matched ignoring case, as publications indexed before the doi keyword
+	// was normalised keep the case they were given in
+	matches := []gin.H{}
+	for _, doi := range dois {
+		matches = append(matches, gin.H{"term": gin.H{"doi": gin.H{"value": doi, "case_insensitive": true}}})
+	}
+
+	var buf bytes.Buffer
+	elasticQuery := gin.H{
+		"size":    len(dois),
+		"_source": []string{"doi", "datasetTitles"},
+		"query":   gin.H{"bool": gin.H{"should": matches, "minimum_should_match": 1}},
+	}
+	if err := json.NewEncoder(&buf).Encode(elasticQuery); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to encode elastic query with %s", err.Error()))
+	}
+
+	response, err := ElasticClient.Search(
+		ElasticClient.Search.WithContext(ctx),
+		ElasticClient.Search.WithIndex("publication"),
+		ElasticClient.Search.WithBody(&buf),
+	)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to look up papers in publication index with %s", err.Error()))
+		return publications
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Failed to read elastic response with %s", err.Error()))
+		return publications
+	}
+
+	var elasticResp SearchResponse
+	if err := json.Unmarshal(body, &elasticResp); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to unmarshal elastic response with %s", err.Error()))
+		return publications
+	}
+
+	for _, hit := range elasticResp.Hits.Hits {
+		doi, _ := hit.Source["doi"].(string)
+		publication := &GatewayPublication{ID: hit.Id, DatasetTitles: []string{}}
+		if titles, ok := hit.Source["datasetTitles"].([]interface{}); ok {
+			for _, title := range titles {
+				if s, ok := title.(string); ok {
+					publication.DatasetTitles = append(publication.DatasetTitles, s)
+				}
+			}
+		}
+		publications[normaliseDOI(doi)] = publication
+	}
+	return publications
+}
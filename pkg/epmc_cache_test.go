@@ -0,0 +1,142 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/stretchr/testify/assert"
+
+	"hdruk/search-service/utils/mocks"
+)
+
+// mockElasticResponses replaces the elastic client with one answering every
+// request with the body returned by respond.
+func mockElasticResponses(respond func(req *http.Request) string) {
+	mocktrans := mocks.MockTransport{}
+	mocktrans.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(respond(req))),
+			Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		}, nil
+	}
+	ElasticClient, _ = elasticsearch.NewClient(elasticsearch.Config{Transport: &mocktrans})
+}
+
+func TestPaperCacheKey(t *testing.T) {
+	assert.EqualValues(t, "doi:10.123/abc", paperCacheKey(PaperCore{DOI: "https://doi.org/10.123/ABC", PMID: "1"}))
+	assert.EqualValues(t, "pmid:1", paperCacheKey(PaperCore{PMID: "1", ID: "2"}))
+	assert.EqualValues(t, "epmc:2", paperCacheKey(PaperCore{ID: "2"}))
+	assert.True(t, strings.HasPrefix(queryCacheKey("/search?query=a"), "query:"))
+}
+
+func TestEPMCCacheBulkBody(t *testing.T) {
+	var result PMCCoreResponse
+	json.Unmarshal([]byte(epmcRespJson), &result)
+
+	body, err := epmcCacheBulkBody("/search?query=a", result, time.Now())
+	assert.Nil(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	assert.Len(t, lines, 4)
+	assert.Contains(t, string(body), `"_id":"doi:10.123/abc"`)
+	assert.Contains(t, string(body), `"pmid":"000000"`)
+	assert.Contains(t, string(body), `"kind":"query"`)
+}
+
+func TestAnnotatePublications(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	var searchBody string
+	mockElasticResponses(func(req *http.Request) string {
+		body, _ := io.ReadAll(req.Body)
+		searchBody = string(body)
+		return `{"hits": {"hits": [
+			{"_id": "42", "_source": {"doi": "https://doi.org/10.123/ABC", "datasetTitles": ["Dataset A"]}}
+		]}}`
+	})
+
+	papers := []PaperCore{{ID: "1", DOI: "10.123/abc"}, {ID: "2", DOI: "10.999/xyz"}, {ID: "3"}}
+	annotatePublications(context.Background(), papers)
+
+	assert.Contains(t, searchBody, `{"term":{"doi":{"case_insensitive":true,"value":"10.123/abc"}}}`)
+
+	assert.NotNil(t, papers[0].GatewayPublication)
+	assert.EqualValues(t, "42", papers[0].GatewayPublication.ID)
+	assert.EqualValues(t, []string{"Dataset A"}, papers[0].GatewayPublication.DatasetTitles)
+	assert.Nil(t, papers[1].GatewayPublication)
+	assert.Nil(t, papers[2].GatewayPublication)
+}
+
+func TestFetchPMCFromCache(t *testing.T) {
+	pmcCacheEnabled, pmcCacheTTL = true, time.Hour
+	defer func() {
+		pmcCacheEnabled, pmcCacheTTL = false, 0
+		ElasticClient = mocks.MockElasticClient()
+		PMCClient = &mocks.MockClient{}
+	}()
+
+	cachedAt := time.Now()
+	mockElasticResponses(func(req *http.Request) string {
+		if req.Method == "GET" {
+			return fmt.Sprintf(
+				`{"found": true, "_source": {"kind": "query", "cachedAt": %q, "response": {"hitCount": 7}}}`,
+				cachedAt.Format(time.RFC3339),
+			)
+		}
+		return `{"errors": false, "items": []}`
+	})
+
+	// Fresh cached results are served without calling EuropePMC
+	stub := &stubClient{statuses: []int{200}}
+	PMCClient = stub
+	result, err := fetchPMC(context.Background(), "/search?query=a")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 7, result.HitCount)
+	assert.EqualValues(t, 0, stub.calls)
+
+	// Stale cached results are refreshed from EuropePMC
+	cachedAt = time.Now().Add(-2 * time.Hour)
+	result, err = fetchPMC(context.Background(), "/search?query=a")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, result.HitCount)
+	assert.EqualValues(t, 1, stub.calls)
+
+	// Stale cached results are served if EuropePMC is failing
+	PMCClient = &stubClient{statuses: []int{500}}
+	result, err = fetchPMC(context.Background(), "/search?query=a")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 7, result.HitCount)
+}
+
+func TestCachedPaperByDOI(t *testing.T) {
+	pmcCacheEnabled, pmcCacheTTL = true, time.Hour
+	defer func() {
+		pmcCacheEnabled, pmcCacheTTL = false, 0
+		ElasticClient = mocks.MockElasticClient()
+	}()
+
+	var requestedPath string
+	mockElasticResponses(func(req *http.Request) string {
+		requestedPath = req.URL.EscapedPath()
+		return fmt.Sprintf(
+			`{"found": true, "_source": {"kind": "paper", "cachedAt": %q, "paper": {"id": "1", "doi": "10.123/abc"}}}`,
+			time.Now().Format(time.RFC3339),
+		)
+	})
+
+	result, found := cachedPaperByDOI(context.Background(), normaliseDOI("https://doi.org/10.123/ABC"))
+	assert.True(t, found)
+	assert.EqualValues(t, 1, result.HitCount)
+	assert.EqualValues(t, "1", result.ResultList["result"][0].ID)
+	assert.Contains(t, requestedPath, "/epmc_cache/_doc/doi:10.123%2Fabc")
+
+	_, found = cachedPaperByDOI(context.Background(), "")
+	assert.False(t, found)
+}
@@ -0,0 +1,647 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Formats of exported search results.
+const (
+	exportCSV    = "csv"
+	exportJSONL  = "jsonl"
+	exportRIS    = "ris"
+	exportBibTeX = "bibtex"
+)
+
+// exportContentTypes maps each export format to its content type and file
+// extension.
+var exportContentTypes = map[string][2]string{
+	exportCSV:    {"text/csv", "csv"},
+	exportJSONL:  {"application/x-ndjson", "jsonl"},
+	exportRIS:    {"application/x-research-info-systems", "ris"},
+	exportBibTeX: {"application/x-bibtex", "bib"},
+}
+
+// federatedPapersExport is the entity name used to export the results of a
+// EuropePMC field search.
+const federatedPapersExport = "federated_papers"
+
+// citationEntities are the entities whose results can be exported as
+// citations in RIS and BibTeX formats.
+var citationEntities = []string{"publications", federatedPapersExport}
+
+// Limits of exports. maxExportHits caps the hits in a single export, and
+// results are read exportPageSize at a time within a point in time kept open
+// for exportKeepAlive between pages.
+const (
+	maxExportHits   = 10000
+	exportPageSize  = 500
+	exportKeepAlive = "1m"
+)
+
+// defaultPaperColumns are the columns of CSV exports of papers when none are
+// requested.
+var defaultPaperColumns = []string{"doi", "title", "authorString", "journalInfo.journal.title", "pubYear", "firstPublicationDate"}
+
+/*
+ExportRequest represents a request to export all the results of a search
+
+	{
+		"format": "csv",
+		"columns": ["title", "publisherName"],
+		"limit": 5000,
+		"search": {"query": "asthma", "filters": {...}}
+	}
+
+where:
+  - format is one of csv, jsonl, ris or bibtex, with ris and bibtex only
+    available for publications and federated papers
+  - columns are the fields of csv and jsonl exports, defaulting to the
+    summary fields of the entity, and may be nested, e.g.
+    journalInfo.journal.title
+  - limit is the most results exported, capped at maxExportHits
+  - search is the body of the entity's search endpoint, a Query, or of
+    /search/federated_papers/field_search for federated papers
+*/
+type ExportRequest struct {
+	Format  string          `json:"format"`
+	Columns []string        `json:"columns"`
+	Limit   int             `json:"limit"`
+	Search  json.RawMessage `json:"search"`
+}
+
+// exportRecord is a single result being exported, with its citation for the
+// RIS and BibTeX formats.
+type exportRecord struct {
+	ID       string
+	Source   map[string]interface{}
+	Citation citation
+}
+
+// citation holds the bibliographic details of a publication.
+type citation struct {
+	Type     string
+	Title    string
+	Authors  []string
+	Journal  string
+	Year     string
+	Date     string
+	DOI      string
+	Abstract string
+}
+
+// Types of citation, as used in RIS.
+const (
+	citationArticle  = "JOUR"
+	citationPreprint = "UNPB"
+	citationBook     = "BOOK"
+)
+
+// exportWriter writes exported results in a single format.
+type exportWriter interface {
+	write(record exportRecord) error
+	flush() error
+}
+
+// ExportSearch streams every result of a search of an entity, up to a hard
+// cap, as CSV, JSON Lines, RIS or BibTeX. Results are read a page at a time
+// and flushed to the client after each page, so memory use does not grow
+// with the size of the export. Errors found after the response has started
+// can only end the stream early, and are logged, while those found before
+// return 502.
+func ExportSearch(c *gin.Context) {
+	entityName := c.Param("entity")
+	entity, isSearchEntity := searchEntities[entityName]
+	if !isSearchEntity && entityName != federatedPapersExport {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("entity %s not recognised", entityName)})
+		return
+	}
+
+	var request ExportRequest
+	if err := c.BindJSON(&request); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to interpret export request with %s", err.Error()))
+		return
+	}
+	if err := validateExport(request, entityName); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit := exportLimit(request.Limit)
+
+	var produce func(ctx context.Context, emit func([]exportRecord) error) error
+	columns := request.Columns
+	if entityName == federatedPapersExport {
+		var fieldQuery FieldQuery
+		if err := json.Unmarshal(request.Search, &fieldQuery); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateFieldQuery(fieldQuery); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(columns) == 0 {
+			columns = defaultPaperColumns
+		}
+		produce = func(ctx context.Context, emit func([]exportRecord) error) error {
+			return exportPapers(ctx, fieldQuery, limit, emit)
+		}
+	} else {
+		var query Query
+		if len(request.Search) > 0 {
+			if err := json.Unmarshal(request.Search, &query); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if err := validateQuery(query, entityName); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(columns) == 0 {
+			columns = entity.SummaryFields
+		}
+		elasticQuery := gin.H{
+			"query": compiledQuery(entityElasticConfigs[entityName](query), query),
+			"size":  exportPageSize,
+		}
+		// Citations are read from the whole document, so columns only
+		// restrict the fields of csv and jsonl exports
+		if request.Format == exportCSV || (request.Format == exportJSONL && len(request.Columns) > 0) {
+			elasticQuery["_source"] = gin.H{"includes": columns}
+		} else if source := sourceFilter(entity.Index, query); source != nil {
+			elasticQuery["_source"] = source
+		}
+		produce = func(ctx context.Context, emit func([]exportRecord) error) error {
+			return exportEntity(ctx, entity, elasticQuery, limit, emit)
+		}
+	}
+
+	contentType := exportContentTypes[request.Format]
+	c.Header("Content-Type", contentType[0])
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, entityName, contentType[1]))
+	c.Status(http.StatusOK)
+
+	writer := newExportWriter(request.Format, c.Writer, columns)
+	err := produce(c.Request.Context(), func(records []exportRecord) error {
+		for _, record := range records {
+			if err := writer.write(record); err != nil {
+				return err
+			}
+		}
+		if err := writer.flush(); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err == nil {
+		err = writer.flush()
+	}
+	if err != nil && !c.Writer.Written() {
+		c.Writer.Header().Del("Content-Disposition")
+		c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Export of %s ended early with %s", entityName, err.Error()))
+	}
+}
+
+// validateExport checks the format, columns and limit of an export.
+func validateExport(request ExportRequest, entityName string) error {
+	if _, ok := exportContentTypes[request.Format]; !ok {
+		return fmt.Errorf("format must be one of %s, %s, %s or %s", exportCSV, exportJSONL, exportRIS, exportBibTeX)
+	}
+	if (request.Format == exportRIS || request.Format == exportBibTeX) && !slices.Contains(citationEntities, entityName) {
+		return fmt.Errorf("%s exports are only available for publications and federated papers", request.Format)
+	}
+	if request.Limit < 0 {
+		return fmt.Errorf("limit must not be negative")
+	}
+	for _, column := range request.Columns {
+		if column == "" {
+			return fmt.Errorf("columns must not be empty")
+		}
+	}
+	return nil
+}
+
+// exportLimit returns the most hits exported, defaulting to and capped at
+// maxExportHits.
+func exportLimit(limit int) int {
+	if limit <= 0 || limit > maxExportHits {
+		return maxExportHits
+	}
+	return limit
+}
+
+// exportPage is a page of hits read within a point in time.
+type exportPage struct {
+	PitID string `json:"pit_id"`
+	Hits  struct {
+		Hits []struct {
+			ID     string                 `json:"_id"`
+			Source map[string]interface{} `json:"_source"`
+			Sort   []interface{}          `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// exportEntity reads the hits of elasticQuery from the entity's index a page
+// at a time, passing each page to emit, until limit hits have been read. The
+// pages are read within a point in time, so that documents indexed during the
+// export do not shift the pages, and in order of score.
+func exportEntity(ctx context.Context, entity searchEntity, elasticQuery gin.H, limit int, emit func([]exportRecord) error) error {
+	body, err := readElasticResponse(ElasticClient.OpenPointInTime(
+		[]string{entity.Index},
+		exportKeepAlive,
+		ElasticClient.OpenPointInTime.WithContext(ctx),
+	))
+	if err != nil {
+		return err
+	}
+	var pit struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &pit); err != nil {
+		return err
+	}
+	defer func() { closePointInTime(pit.ID) }()
+
+	elasticQuery["sort"] = []gin.H{{"_score": "desc"}, {"_shard_doc": "asc"}}
+	exported := 0
+	for exported < limit {
+		elasticQuery["pit"] = gin.H{"id": pit.ID, "keep_alive": exportKeepAlive}
+		elasticQuery["size"] = min(exportPageSize, limit-exported)
+
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(elasticQuery); err != nil {
+			return err
+		}
+		body, err := readElasticResponse(ElasticClient.Search(
+			ElasticClient.Search.WithContext(ctx),
+			ElasticClient.Search.WithBody(&buf),
+		))
+		if err != nil {
+			return err
+		}
+		var page exportPage
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+
+		hits := page.Hits.Hits
+		if len(hits) == 0 {
+			return nil
+		}
+		records := make([]exportRecord, 0, len(hits))
+		for _, hit := range hits {
+			record := exportRecord{ID: hit.ID, Source: hit.Source}
+			if entity.Index == searchEntities["publications"].Index {
+				record.Citation = citationFromPublication(hit.Source)
+			}
+			records = append(records, record)
+		}
+		if err := emit(records); err != nil {
+			return err
+		}
+
+		exported += len(hits)
+		if page.PitID != "" {
+			pit.ID = page.PitID
+		}
+		elasticQuery["search_after"] = hits[len(hits)-1].Sort
+		if len(hits) < exportPageSize {
+			return nil
+		}
+	}
+	return nil
+}
+
+// closePointInTime releases a point in time once an export is finished.
+func closePointInTime(id string) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(gin.H{"id": id}); err != nil {
+		return
+	}
+	response, err := ElasticClient.ClosePointInTime(ElasticClient.ClosePointInTime.WithBody(&buf))
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Failed to close point in time with %s", err.Error()))
+		return
+	}
+	response.Body.Close()
+}
+
+// exportPapers reads the results of a EuropePMC field search a page at a
+// time, following the cursorMark of each page, passing each page to emit
+// until limit papers have been read.
+func exportPapers(ctx context.Context, query FieldQuery, limit int, emit func([]exportRecord) error) error {
+	epmcQuery := buildQueryString(query)
+	cursorMark := initialCursorMark
+	exported := 0
+	for exported < limit {
+		urlPath, err := epmcSearchURL(epmcQuery, min(maxPMCPageSize, limit-exported), cursorMark)
+		if err != nil {
+			return err
+		}
+		result, err := fetchPMC(ctx, urlPath)
+		if err != nil {
+			return err
+		}
+
+		papers := result.ResultList["result"]
+		if len(papers) == 0 {
+			return nil
+		}
+		records := make([]exportRecord, 0, len(papers))
+		for _, paper := range papers {
+			source := map[string]interface{}{}
+			if body, err := json.Marshal(paper); err == nil {
+				json.Unmarshal(body, &source)
+			}
+			records = append(records, exportRecord{ID: paper.ID, Source: source, Citation: citationFromPaper(paper)})
+		}
+		if err := emit(records); err != nil {
+			return err
+		}
+
+		exported += len(papers)
+		if result.NextCursorMark == "" || result.NextCursorMark == cursorMark {
+			return nil
+		}
+		cursorMark = result.NextCursorMark
+	}
+	return nil
+}
+
+// citationFromPublication reads the citation of a document in the
+// publication index.
+func citationFromPublication(source map[string]interface{}) citation {
+	date := exportValue(source, "publicationDate")
+	year := date
+	if len(year) > 4 {
+		year = year[:4]
+	}
+	return citation{
+		Type:     citationType(exportValue(source, "publicationType")),
+		Title:    exportValue(source, "title"),
+		Authors:  splitAuthors(source["authors"]),
+		Journal:  exportValue(source, "journalName"),
+		Year:     year,
+		Date:     date,
+		DOI:      exportValue(source, "doi"),
+		Abstract: exportValue(source, "abstract"),
+	}
+}
+
+// citationFromPaper reads the citation of a paper from EuropePMC.
+func citationFromPaper(paper PaperCore) citation {
+	citationKind := citationArticle
+	types := paperPublicationTypes(paper)
+	if slices.Contains(types, "Preprints") {
+		citationKind = citationPreprint
+	} else if slices.Contains(types, "Books and documents") {
+		citationKind = citationBook
+	}
+	return citation{
+		Type:     citationKind,
+		Title:    paper.Title,
+		Authors:  splitAuthors(paper.AuthorString),
+		Journal:  paperJournal(paper),
+		Year:     paper.PubYear,
+		Date:     paper.FirrstPublicationDate,
+		DOI:      paper.DOI,
+		Abstract: paper.AbstractText,
+	}
+}
+
+// citationType maps the type of a publication to the type of its citation.
+func citationType(publicationType string) string {
+	lower := strings.ToLower(publicationType)
+	switch {
+	case strings.Contains(lower, "preprint"):
+		return citationPreprint
+	case strings.Contains(lower, "book"):
+		return citationBook
+	default:
+		return citationArticle
+	}
+}
+
+// splitAuthors reads a list of authors, given either as a list or as a
+// comma separated string, e.g. "Smith J, Jones K.".
+func splitAuthors(authors interface{}) []string {
+	var names []string
+	switch value := authors.(type) {
+	case []interface{}:
+		for _, name := range value {
+			names = append(names, fmt.Sprint(name))
+		}
+	case string:
+		names = strings.Split(value, ",")
+	}
+
+	split := []string{}
+	for _, name := range names {
+		name = strings.TrimSuffix(strings.TrimSpace(name), ".")
+		if name != "" {
+			split = append(split, name)
+		}
+	}
+	return split
+}
+
+// exportValue reads the value of a column from a document, following the
+// dots of nested columns. Lists are joined with "; " and other values which
+// are not strings or numbers are written as JSON.
+func exportValue(source map[string]interface{}, column string) string {
+	var value interface{} = source
+	for _, key := range strings.Split(column, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = object[key]
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64, bool:
+		return fmt.Sprint(v)
+	case []interface{}:
+		parts := []string{}
+		for _, item := range v {
+			if object, ok := item.(map[string]interface{}); ok {
+				body, _ := json.Marshal(object)
+				parts = append(parts, string(body))
+			} else {
+				parts = append(parts, fmt.Sprint(item))
+			}
+		}
+		return strings.Join(parts, "; ")
+	default:
+		body, _ := json.Marshal(v)
+		return string(body)
+	}
+}
+
+// newExportWriter returns the writer of the format.
+func newExportWriter(format string, w io.Writer, columns []string) exportWriter {
+	switch format {
+	case exportJSONL:
+		return &jsonlExportWriter{encoder: json.NewEncoder(w)}
+	case exportRIS:
+		return &risExportWriter{w: w}
+	case exportBibTeX:
+		return &bibtexExportWriter{w: w}
+	default:
+		return &csvExportWriter{w: csv.NewWriter(w), columns: columns}
+	}
+}
+
+// csvExportWriter writes a row of the columns of each result, after a header
+// row, with the id of the result first.
+type csvExportWriter struct {
+	w             *csv.Writer
+	columns       []string
+	headerWritten bool
+}
+
+func (e *csvExportWriter) write(record exportRecord) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	row := []string{record.ID}
+	for _, column := range e.columns {
+		row = append(row, exportValue(record.Source, column))
+	}
+	return e.w.Write(row)
+}
+
+// writeHeader writes the header row unless it has already been written.
+func (e *csvExportWriter) writeHeader() error {
+	if e.headerWritten {
+		return nil
+	}
+	e.headerWritten = true
+	return e.w.Write(append([]string{"id"}, e.columns...))
+}
+
+// flush writes the rows buffered, and the header row if no results have been
+// written, so that an empty export still has its header.
+func (e *csvExportWriter) flush() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonlExportWriter writes each result as a JSON object on its own line, with
+// its id and source.
+type jsonlExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (e *jsonlExportWriter) write(record exportRecord) error {
+	return e.encoder.Encode(gin.H{"id": record.ID, "source": record.Source})
+}
+
+func (e *jsonlExportWriter) flush() error {
+	return nil
+}
+
+// risExportWriter writes the citation of each result in RIS format.
+type risExportWriter struct {
+	w io.Writer
+}
+
+func (e *risExportWriter) write(record exportRecord) error {
+	cite := record.Citation
+	lines := []string{"TY  - " + cite.Type}
+	add := func(tag string, value string) {
+		value = strings.Join(strings.Fields(value), " ")
+		if value != "" {
+			lines = append(lines, tag+"  - "+value)
+		}
+	}
+	add("ID", record.ID)
+	add("TI", cite.Title)
+	for _, author := range cite.Authors {
+		add("AU", author)
+	}
+	add("JO", cite.Journal)
+	add("PY", cite.Year)
+	add("DA", cite.Date)
+	add("DO", cite.DOI)
+	add("AB", cite.Abstract)
+	lines = append(lines, "ER  - ", "")
+	_, err := io.WriteString(e.w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+func (e *risExportWriter) flush() error {
+	return nil
+}
+
+// bibtexExportWriter writes the citation of each result as a BibTeX entry,
+// keyed by the id of the result.
+type bibtexExportWriter struct {
+	w io.Writer
+}
+
+// bibtexKeyPattern matches the characters not allowed in BibTeX keys.
+var bibtexKeyPattern = regexp.MustCompile(`[^A-Za-z0-9_:-]`)
+
+// bibtexEntryTypes maps the types of citation to BibTeX entry types.
+var bibtexEntryTypes = map[string]string{
+	citationArticle:  "article",
+	citationPreprint: "unpublished",
+	citationBook:     "book",
+}
+
+func (e *bibtexExportWriter) write(record exportRecord) error {
+	cite := record.Citation
+	fields := []string{}
+	add := func(name string, value string) {
+		value = strings.Join(strings.Fields(value), " ")
+		if value != "" {
+			escaped := strings.NewReplacer(`\`, `\textbackslash{}`, "{", `\{`, "}", `\}`).Replace(value)
+			fields = append(fields, fmt.Sprintf("  %s = {%s}", name, escaped))
+		}
+	}
+	add("title", cite.Title)
+	add("author", strings.Join(cite.Authors, " and "))
+	add("journal", cite.Journal)
+	add("year", cite.Year)
+	add("doi", cite.DOI)
+	add("abstract", cite.Abstract)
+
+	entry := fmt.Sprintf("@%s{%s,\n%s\n}\n\n",
+		bibtexEntryTypes[cite.Type],
+		bibtexKeyPattern.ReplaceAllString(record.ID, "_"),
+		strings.Join(fields, ",\n"),
+	)
+	_, err := io.WriteString(e.w, entry)
+	return err
+}
+
+func (e *bibtexExportWriter) flush() error {
+	return nil
+}
@@ -0,0 +1,186 @@
+package search
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"hdruk/search-service/utils/mocks"
+)
+
+func TestExportValue(t *testing.T) {
+	source := map[string]interface{}{
+		"title":          "Dataset A",
+		"populationSize": 1500.0,
+		"dataType":       []interface{}{"Health", "Registry"},
+		"journalInfo":    map[string]interface{}{"journal": map[string]interface{}{"title": "BMJ"}},
+	}
+	assert.EqualValues(t, "Dataset A", exportValue(source, "title"))
+	assert.EqualValues(t, "1500", exportValue(source, "populationSize"))
+	assert.EqualValues(t, "Health; Registry", exportValue(source, "dataType"))
+	assert.EqualValues(t, "BMJ", exportValue(source, "journalInfo.journal.title"))
+	assert.EqualValues(t, `{"title":"BMJ"}`, exportValue(source, "journalInfo.journal"))
+	assert.EqualValues(t, "", exportValue(source, "title.missing"))
+}
+
+func TestSplitAuthors(t *testing.T) {
+	assert.EqualValues(t, []string{"Smith J", "Jones K"}, splitAuthors("Smith J, Jones K."))
+	assert.EqualValues(t, []string{"Smith J", "Jones K"}, splitAuthors([]interface{}{"Smith J", "Jones K"}))
+	assert.EqualValues(t, []string{}, splitAuthors(nil))
+}
+
+func TestCitationWriters(t *testing.T) {
+	record := exportRecord{
+		ID: "PMC/1",
+		Citation: citation{
+			Type:    citationArticle,
+			Title:   "Asthma {in} children",
+			Authors: []string{"Smith J", "Jones K"},
+			Journal: `BMJ\Open`,
+			Year:    "2020",
+			DOI:     "10.123/abc",
+		},
+	}
+
+	var ris bytes.Buffer
+	assert.Nil(t, newExportWriter(exportRIS, &ris, nil).write(record))
+	assert.EqualValues(t, strings.Join([]string{
+		"TY  - JOUR",
+		"ID  - PMC/1",
+		"TI  - Asthma {in} children",
+		"AU  - Smith J",
+		"AU  - Jones K",
+		`JO  - BMJ\Open`,
+		"PY  - 2020",
+		"DO  - 10.123/abc",
+		"ER  - ",
+		"",
+	}, "\n")+"\n", ris.String())
+
+	var bibtex bytes.Buffer
+	assert.Nil(t, newExportWriter(exportBibTeX, &bibtex, nil).write(record))
+	assert.EqualValues(t, strings.Join([]string{
+		"@article{PMC_1,",
+		`  title = {Asthma \{in\} children},`,
+		"  author = {Smith J and Jones K},",
+		`  journal = {BMJ\textbackslash{}Open},`,
+		"  year = {2020},",
+		"  doi = {10.123/abc}",
+		"}",
+		"",
+	}, "\n")+"\n", bibtex.String())
+}
+
+func TestExportSearchCSV(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	var searchBody string
+	closed := false
+	mockElasticResponses(func(req *http.Request) string {
+		switch {
+		case req.URL.Path == "/dataset/_pit":
+			return `{"id": "pit-1"}`
+		case req.URL.Path == "/_pit" && req.Method == "DELETE":
+			closed = true
+			return `{"succeeded": true}`
+		default:
+			body, _ := io.ReadAll(req.Body)
+			searchBody = string(body)
+			return `{"pit_id": "pit-2", "hits": {"hits": [
+				{"_id": "1", "_source": {"title": "Dataset A", "dataType": ["Health"]}, "sort": [1.0, 1]},
+				{"_id": "2", "_source": {"title": "Dataset, B"}, "sort": [0.5, 2]}
+			]}}`
+		}
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Params = gin.Params{{Key: "entity", Value: "datasets"}}
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Body = io.NopCloser(strings.NewReader(`{"format": "csv", "columns": ["title", "dataType"], "search": {"query": "asthma"}}`))
+
+	ExportSearch(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.EqualValues(t, "id,title,dataType\n1,Dataset A,Health\n2,\"Dataset, B\",\n", w.Body.String())
+	assert.Contains(t, searchBody, `"pit":{"id":"pit-1","keep_alive":"1m"}`)
+	assert.Contains(t, searchBody, `"_source":{"includes":["title","dataType"]}`)
+	assert.True(t, closed)
+}
+
+func TestExportSearchBibTeXColumns(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	var searchBody string
+	mockElasticResponses(func(req *http.Request) string {
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/_pit"):
+			return `{"id": "pit-1"}`
+		default:
+			body, _ := io.ReadAll(req.Body)
+			searchBody = string(body)
+			return `{"pit_id": "pit-1", "hits": {"hits": [
+				{"_id": "1", "_source": {"title": "Asthma in children", "journalName": "BMJ", "publicationDate": "2020-05-01"}, "sort": [1.0, 1]}
+			]}}`
+		}
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Params = gin.Params{{Key: "entity", Value: "publications"}}
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Body = io.NopCloser(strings.NewReader(`{"format": "bibtex", "columns": ["title"], "search": {"query": "asthma"}}`))
+
+	ExportSearch(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.NotContains(t, searchBody, `"includes":["title"]`)
+	assert.Contains(t, w.Body.String(), "  journal = {BMJ},")
+	assert.Contains(t, w.Body.String(), "  year = {2020}\n")
+}
+
+func TestExportSearchInvalid(t *testing.T) {
+	for _, body := range []string{
+		`{"format": "ris"}`,
+		`{"format": "xml"}`,
+		`{"format": "csv", "limit": -1}`,
+	} {
+		w := httptest.NewRecorder()
+		c := GetTestGinContext(w)
+		c.Params = gin.Params{{Key: "entity", Value: "datasets"}}
+		c.Request.Method = "POST"
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.Body = io.NopCloser(strings.NewReader(body))
+
+		ExportSearch(c)
+
+		assert.EqualValues(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestExportSearchInvalidPapers(t *testing.T) {
+	for _, body := range []string{
+		`{"format": "ris", "search": {"query": "asthma"}}`,
+		`{"format": "ris", "search": {"query": " ", "field": ["TITLE"]}}`,
+		`{"format": "ris", "search": {"query": "asthma", "field": "TITLE"}}`,
+	} {
+		w := httptest.NewRecorder()
+		c := GetTestGinContext(w)
+		c.Params = gin.Params{{Key: "entity", Value: federatedPapersExport}}
+		c.Request.Method = "POST"
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.Body = io.NopCloser(strings.NewReader(body))
+
+		ExportSearch(c)
+
+		assert.EqualValues(t, http.StatusBadRequest, w.Code, body)
+		assert.Empty(t, w.Header().Get("Content-Disposition"), body)
+	}
+}
@@ -0,0 +1,493 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Facet kinds, which tell the Gateway how to render and filter each facet.
+const (
+	facetTerms        = "terms"
+	facetDateRange    = "dateRange"
+	facetNumericRange = "numericRange"
+	facetBoolean      = "boolean"
+	facetHierarchy    = "hierarchy"
+)
+
+// Facet describes a filter available for an entity. Terms and boolean facets
+// list the values found in the index with their document counts, hierarchy
+// facets list them as a tree of nodes with children, while range facets give
+// the lowest and highest values found. SumOtherDocCount counts the documents
+// with values beyond the buckets listed, which can be found with
+// SearchFacetValues.
+type Facet struct {
+	Key              string  `json:"key"`
+	Kind             string  `json:"kind"`
+	Label            string  `json:"label"`
+	Buckets          []gin.H `json:"buckets"`
+	SumOtherDocCount int     `json:"sumOtherDocCount"`
+}
+
+// FacetsResponse lists the facets of an entity. FilterType is the key of the
+// entity in the filters of a Query.
+type FacetsResponse struct {
+	Entity     string  `json:"entity"`
+	FilterType string  `json:"filterType"`
+	Facets     []Facet `json:"facets"`
+}
+
+var numericFieldTypes = []string{"long", "integer", "short", "byte", "double", "float", "half_float", "scaled_float"}
+
+// identifierFields are keyword fields holding identifiers, such as DOIs,
+// which are unique to each document and so are not offered as facets. Fields
+// named in camelCase ending in Id or Ids, e.g. userId, are identifiers too.
+var identifierFields = []string{"id", "doi", "pmid", "pid", "uuid"}
+
+// isIdentifierField reports whether field, or the last part of a dotted
+// field, is an identifier.
+func isIdentifierField(field string) bool {
+	name := field[strings.LastIndex(field, ".")+1:]
+	return slices.Contains(identifierFields, strings.ToLower(name)) ||
+		strings.HasSuffix(name, "Id") || strings.HasSuffix(name, "Ids")
+}
+
+// EntityFilters lists the facets available for the entity type given in the
+// route, e.g. GET /filters/datasets, with their current values. Facets are
+// derived from the keyword, date, numeric and boolean fields of the index
+// mapping, so new filters appear as soon as a field is indexed. The values of
+// terms facets are ordered by count, or alphabetically with
+// ?order=alphabetical.
+func EntityFilters(c *gin.Context) {
+	entityName := c.Param("entity")
+	entity, ok := searchEntities[entityName]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown entity type %s", entityName)})
+		return
+	}
+
+	fieldTypes, err := indexFieldTypes(c.Request.Context(), entity.Index)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	facets := facetsFromMapping(fieldTypes)
+	results := searchIndex(c.Request.Context(), entity.Index, facetsElasticQuery(facets, c.Query("order")))
+	for i := range facets {
+		facets[i].Buckets = facetBuckets(facets[i], results.Aggregations)
+		if agg, ok := results.Aggregations[facets[i].Key].(map[string]interface{}); ok {
+			sumOther, _ := agg["sum_other_doc_count"].(float64)
+			facets[i].SumOtherDocCount = int(sumOther)
+		}
+	}
+
+	c.JSON(http.StatusOK, FacetsResponse{
+		Entity:     entityName,
+		FilterType: entity.FilterType,
+		Facets:     facets,
+	})
+}
+
+// indexFieldTypes returns the type of each field in the mapping of index,
+// with the fields of objects given by their dotted path.
+func indexFieldTypes(ctx context.Context, index string) (map[string]string, error) {
+	response, err := ElasticClient.Indices.GetMapping(
+		ElasticClient.Indices.GetMapping.WithContext(ctx),
+		ElasticClient.Indices.GetMapping.WithIndex(index),
+	)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to get mapping of index %s with %s", index, err.Error()))
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Failed to read elastic response with %s", err.Error()))
+		return nil, err
+	}
+	if response.IsError() {
+		slog.Warn(fmt.Sprintf("Get mapping returned status %d: %s", response.StatusCode, body))
+		return nil, fmt.Errorf("elastic responded with status %d", response.StatusCode)
+	}
+
+	type property struct {
+		Type       string                     `json:"type"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	var mappings map[string]struct {
+		Mappings property `json:"mappings"`
+	}
+	if err := json.Unmarshal(body, &mappings); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to unmarshal elastic response with %s", err.Error()))
+		return nil, err
+	}
+
+	fieldTypes := make(map[string]string)
+	var addProperties func(prefix string, properties map[string]json.RawMessage)
+	addProperties = func(prefix string, properties map[string]json.RawMessage) {
+		for name, raw := range properties {
+			var p property
+			if err := json.Unmarshal(raw, &p); err != nil {
+				continue
+			}
+			if len(p.Properties) > 0 {
+				addProperties(prefix+name+".", p.Properties)
+			} else if p.Type != "" {
+				fieldTypes[prefix+name] = p.Type
+			}
+		}
+	}
+	for _, mapping := range mappings {
+		addProperties("", mapping.Mappings.Properties)
+	}
+	return fieldTypes, nil
+}
+
+// facetsFromMapping returns the facets of the given fields, sorted by key.
+// The startDate and endDate fields of datasets are combined into the single
+// dateRange facet understood by the search filters, and identifier fields
+// are left out.
+func facetsFromMapping(fieldTypes map[string]string) []Facet {
+	facets := []Facet{}
+	_, hasStart := fieldTypes["startDate"]
+	_, hasEnd := fieldTypes["endDate"]
+	if hasStart && hasEnd {
+		facets = append(facets, Facet{Key: "dateRange", Kind: facetDateRange, Label: facetLabel("dateRange")})
+	}
+
+	for field, fieldType := range fieldTypes {
+		if isIdentifierField(field) {
+			continue
+		}
+		var kind string
+		switch {
+		case fieldType == "keyword" && hierarchicalFacets[field].Depth > 0:
+			kind = facetHierarchy
+		case fieldType == "keyword":
+			kind = facetTerms
+		case fieldType == "boolean":
+			kind = facetBoolean
+		case fieldType == "date" || fieldType == "date_nanos":
+			if hasStart && hasEnd && (field == "startDate" || field == "endDate") {
+				continue
+			}
+			kind = facetDateRange
+		case slices.Contains(numericFieldTypes, fieldType):
+			kind = facetNumericRange
+		default:
+			continue
+		}
+		facets = append(facets, Facet{Key: field, Kind: kind, Label: facetLabel(field)})
+	}
+
+	sort.Slice(facets, func(i, j int) bool {
+		return facets[i].Key < facets[j].Key
+	})
+	return facets
+}
+
+// facetsElasticQuery defines a single query aggregating the values of every
+// facet, with the values of terms facets in the given order. Numeric facets
+// declared in numericFacets are bucketed as they are by ListFilters.
+func facetsElasticQuery(facets []Facet, order string) gin.H {
+	aggs := gin.H{}
+	for _, facet := range facets {
+		switch facet.Kind {
+		case facetTerms, facetBoolean:
+			aggs[facet.Key] = termsAggregation(facet.Key, 1000, order)
+		case facetHierarchy:
+			aggs[facet.Key] = hierarchyAggregation(facet.Key, hierarchicalFacets[facet.Key].Depth, 1000)
+		case facetDateRange:
+			startField, endField := facet.Key, facet.Key
+			if dates, ok := dateFacets[facet.Key]; ok {
+				startField, endField = dates.StartField, dates.EndField
+			}
+			aggs[facet.Key+".startDate"] = gin.H{"min": gin.H{"field": startField}}
+			aggs[facet.Key+".endDate"] = gin.H{"max": gin.H{"field": endField}}
+		case facetNumericRange:
+			if numeric, ok := numericFacets[facet.Key]; ok {
+				for name, agg := range numericFacetAggs(facet.Key, numeric) {
+					aggs[name] = agg
+				}
+				continue
+			}
+			aggs[facet.Key+".min"] = gin.H{"min": gin.H{"field": facet.Key}}
+			aggs[facet.Key+".max"] = gin.H{"max": gin.H{"field": facet.Key}}
+		}
+	}
+	return gin.H{"size": 0, "aggs": aggs}
+}
+
+// facetBuckets reads the values of facet from the aggregations returned for
+// facetsElasticQuery, in the same bucket format as ListFilters.
+func facetBuckets(facet Facet, aggregations map[string]interface{}) []gin.H {
+	buckets := []gin.H{}
+	switch facet.Kind {
+	case facetHierarchy:
+		agg, _ := aggregations[facet.Key].(map[string]interface{})
+		buckets = hierarchyTree(agg, "")
+	case facetTerms, facetBoolean:
+		agg, _ := aggregations[facet.Key].(map[string]interface{})
+		aggBuckets, _ := agg["buckets"].([]interface{})
+		for _, b := range aggBuckets {
+			bucket, ok := b.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			key := bucket["key"]
+			if keyAsString, ok := bucket["key_as_string"]; ok {
+				key = keyAsString
+			}
+			buckets = append(buckets, gin.H{"key": key, "doc_count": bucket["doc_count"]})
+		}
+	case facetDateRange:
+		for _, bound := range []string{"startDate", "endDate"} {
+			agg, _ := aggregations[facet.Key+"."+bound].(map[string]interface{})
+			buckets = append(buckets, gin.H{"key": bound, "value": agg["value_as_string"]})
+		}
+	case facetNumericRange:
+		if numeric, ok := numericFacets[facet.Key]; ok {
+			var aggBuckets []interface{}
+			switch result := numericFacetResult(facet.Key, numeric, aggregations).(type) {
+			case map[string]interface{}:
+				aggBuckets, _ = result["buckets"].([]interface{})
+			case gin.H:
+				aggBuckets, _ = result["buckets"].([]interface{})
+			}
+			for _, b := range aggBuckets {
+				switch bucket := b.(type) {
+				case map[string]interface{}:
+					buckets = append(buckets, bucket)
+				case gin.H:
+					buckets = append(buckets, bucket)
+				}
+			}
+			break
+		}
+		for _, bound := range []string{"min", "max"} {
+			agg, _ := aggregations[facet.Key+"."+bound].(map[string]interface{})
+			buckets = append(buckets, gin.H{"key": bound, "value": agg["value"]})
+		}
+	}
+	return buckets
+}
+
+// facetLabel converts a camelCase or dotted field name into a display label,
+// e.g. publisherName becomes "Publisher name".
+func facetLabel(field string) string {
+	var label bytes.Buffer
+	for i, r := range strings.ReplaceAll(field, ".", " ") {
+		switch {
+		case i == 0:
+			label.WriteRune(unicode.ToUpper(r))
+		case unicode.IsUpper(r):
+			label.WriteRune(' ')
+			label.WriteRune(unicode.ToLower(r))
+		case r == '_':
+			label.WriteRune(' ')
+		default:
+			label.WriteRune(r)
+		}
+	}
+	return label.String()
+}
+
+// Ways of matching the query of a FacetValueSearch against facet values.
+const (
+	matchPrefix   = "prefix"
+	matchContains = "contains"
+)
+
+// Page sizes of SearchFacetValues.
+const (
+	defaultFacetValuePageSize = 50
+	maxFacetValuePageSize     = 1000
+)
+
+// FacetValueSearch represents a search within the values of the terms facet
+// Key. The other fields are optional:
+//   - Query is matched against the start of each value, or anywhere in the
+//     value when Match is "contains", ignoring case.
+//   - Size is the number of values in each page, defaulting to 50.
+//   - After is the AfterKey of the previous page.
+//   - Order sorts the values alphabetically, "asc" or "desc".
+//   - Filters restricts the documents counted, in the same format as a Query.
+//     Any filter on Key itself is ignored.
+type FacetValueSearch struct {
+	Key     string                            `json:"key"`
+	Query   string                            `json:"query"`
+	Match   string                            `json:"match"`
+	Size    int                               `json:"size"`
+	After   string                            `json:"after"`
+	Order   string                            `json:"order"`
+	Filters map[string]map[string]interface{} `json:"filters"`
+}
+
+// FacetValuesResponse holds a page of facet values. AfterKey is empty on the
+// last page. Documents with several values of the facet can bring
+// non-matching values into a page, which are removed, so a page may hold
+// fewer than Size values without being the last.
+type FacetValuesResponse struct {
+	Key      string  `json:"key"`
+	Buckets  []gin.H `json:"buckets"`
+	AfterKey string  `json:"afterKey"`
+}
+
+// SearchFacetValues pages through the values of a terms facet of the entity
+// type given in the route, e.g. POST /filters/datasets/values, using a
+// composite aggregation so that every value can be reached however many
+// there are.
+func SearchFacetValues(c *gin.Context) {
+	entityName := c.Param("entity")
+	entity, ok := searchEntities[entityName]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown entity type %s", entityName)})
+		return
+	}
+
+	var valueSearch FacetValueSearch
+	if err := c.BindJSON(&valueSearch); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to interpret facet value search with %s", err.Error()))
+		return
+	}
+	if err := validateFacetValueSearch(valueSearch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fieldTypes, err := indexFieldTypes(c.Request.Context(), entity.Index)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if fieldTypes[valueSearch.Key] != "keyword" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is not a terms facet of %s", valueSearch.Key, entityName)})
+		return
+	}
+
+	results := searchIndex(c.Request.Context(), entity.Index, facetValuesElasticQuery(entity, valueSearch))
+	c.JSON(http.StatusOK, facetValuesResponse(valueSearch, results.Aggregations))
+}
+
+func validateFacetValueSearch(valueSearch FacetValueSearch) error {
+	if valueSearch.Key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if valueSearch.Match != "" && valueSearch.Match != matchPrefix && valueSearch.Match != matchContains {
+		return fmt.Errorf("match must be one of %s or %s", matchPrefix, matchContains)
+	}
+	if valueSearch.Order != "" && valueSearch.Order != "asc" && valueSearch.Order != "desc" {
+		return fmt.Errorf("order must be one of asc or desc")
+	}
+	if valueSearch.Size < 0 || valueSearch.Size > maxFacetValuePageSize {
+		return fmt.Errorf("size must be between 1 and %d", maxFacetValuePageSize)
+	}
+	return nil
+}
+
+// facetValuesElasticQuery defines a composite aggregation over the values of
+// valueSearch.Key in the documents holding a matching value.
+func facetValuesElasticQuery(entity searchEntity, valueSearch FacetValueSearch) gin.H {
+	order := valueSearch.Order
+	if order == "" {
+		order = "asc"
+	}
+
+	_, mustFiltersByKey := buildFilters(valueSearch.Filters[entity.FilterType])
+	filters := []gin.H{}
+	for key, filter := range mustFiltersByKey {
+		if key != valueSearch.Key {
+			filters = append(filters, filter)
+		}
+	}
+	if valueSearch.Query != "" {
+		filters = append(filters, facetValueMatch(valueSearch))
+	}
+
+	composite := gin.H{
+		"size": facetValuePageSize(valueSearch),
+		"sources": []gin.H{
+			{"value": gin.H{"terms": gin.H{"field": valueSearch.Key, "order": order}}},
+		},
+	}
+	if valueSearch.After != "" {
+		composite["after"] = gin.H{"value": valueSearch.After}
+	}
+
+	return gin.H{
+		"size":  0,
+		"query": gin.H{"bool": gin.H{"filter": filters}},
+		"aggs": gin.H{
+			"values": gin.H{"composite": composite},
+		},
+	}
+}
+
+// facetValueMatch defines the query for documents with a value of
+// valueSearch.Key matching valueSearch.Query.
+func facetValueMatch(valueSearch FacetValueSearch) gin.H {
+	if valueSearch.Match == matchContains {
+		escaped := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`).Replace(valueSearch.Query)
+		return gin.H{"wildcard": gin.H{valueSearch.Key: gin.H{
+			"value":            "*" + escaped + "*",
+			"case_insensitive": true,
+		}}}
+	}
+	return gin.H{"prefix": gin.H{valueSearch.Key: gin.H{
+		"value":            valueSearch.Query,
+		"case_insensitive": true,
+	}}}
+}
+
+// facetValuesResponse reads the page of values matching valueSearch from the
+// composite aggregation returned for facetValuesElasticQuery.
+func facetValuesResponse(valueSearch FacetValueSearch, aggregations map[string]interface{}) FacetValuesResponse {
+	response := FacetValuesResponse{Key: valueSearch.Key, Buckets: []gin.H{}}
+	agg, _ := aggregations["values"].(map[string]interface{})
+
+	query := strings.ToLower(valueSearch.Query)
+	aggBuckets, _ := agg["buckets"].([]interface{})
+	for _, b := range aggBuckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		keys, _ := bucket["key"].(map[string]interface{})
+		value, _ := keys["value"].(string)
+		matched := strings.HasPrefix(strings.ToLower(value), query)
+		if valueSearch.Match == matchContains {
+			matched = strings.Contains(strings.ToLower(value), query)
+		}
+		if matched {
+			response.Buckets = append(response.Buckets, gin.H{"key": value, "doc_count": bucket["doc_count"]})
+		}
+	}
+
+	// Elastic returns an after_key with the last full page, so a page shorter
+	// than the size requested is the last
+	if afterKey, ok := agg["after_key"].(map[string]interface{}); ok && len(aggBuckets) > 0 {
+		if len(aggBuckets) == facetValuePageSize(valueSearch) {
+			response.AfterKey, _ = afterKey["value"].(string)
+		}
+	}
+	return response
+}
+
+func facetValuePageSize(valueSearch FacetValueSearch) int {
+	if valueSearch.Size == 0 {
+		return defaultFacetValuePageSize
+	}
+	return valueSearch.Size
+}
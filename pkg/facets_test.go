@@ -0,0 +1,179 @@
+package search
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"hdruk/search-service/utils/mocks"
+)
+
+func TestFacetsFromMapping(t *testing.T) {
+	facets := facetsFromMapping(map[string]string{
+		"title":          "text",
+		"publisherName":  "keyword",
+		"startDate":      "date",
+		"endDate":        "date",
+		"populationSize": "long",
+		"containsTissue": "boolean",
+		"meta.updatedAt": "date",
+		"doi":            "keyword",
+		"meta.userId":    "keyword",
+	})
+
+	assert.EqualValues(t, []Facet{
+		{Key: "containsTissue", Kind: facetBoolean, Label: "Contains tissue"},
+		{Key: "dateRange", Kind: facetDateRange, Label: "Date range"},
+		{Key: "meta.updatedAt", Kind: facetDateRange, Label: "Meta updated at"},
+		{Key: "populationSize", Kind: facetNumericRange, Label: "Population size"},
+		{Key: "publisherName", Kind: facetTerms, Label: "Publisher name"},
+	}, facets)
+
+	aggs := facetsElasticQuery(facets, orderAlphabetical)["aggs"].(gin.H)
+	assert.EqualValues(t, gin.H{"min": gin.H{"field": "startDate"}}, aggs["dateRange.startDate"])
+	assert.EqualValues(t, numericFacetAggs("populationSize", numericFacets["populationSize"])["populationSize"], aggs["populationSize"])
+	assert.NotContains(t, aggs, "populationSize.max")
+	assert.EqualValues(t, gin.H{"terms": gin.H{"field": "publisherName", "size": 1000, "order": gin.H{"_key": "asc"}}}, aggs["publisherName"])
+}
+
+func TestFacetBucketsNumeric(t *testing.T) {
+	var aggregations map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"populationSize": {"buckets": [
+			{"key": "Unreported", "from": -1, "to": 1, "doc_count": 3},
+			{"key": "1.0-10.0", "from": 1, "to": 10, "doc_count": 5}
+		]},
+		"score.min": {"value": 2},
+		"score.max": {"value": 9}
+	}`), &aggregations)
+
+	buckets := facetBuckets(Facet{Key: "populationSize", Kind: facetNumericRange}, aggregations)
+	assert.Len(t, buckets, 2)
+	assert.EqualValues(t, "Unreported", buckets[0]["key"])
+	assert.EqualValues(t, 5, buckets[1]["doc_count"])
+
+	buckets = facetBuckets(Facet{Key: "score", Kind: facetNumericRange}, aggregations)
+	assert.EqualValues(t, []gin.H{{"key": "min", "value": 2.0}, {"key": "max", "value": 9.0}}, buckets)
+}
+
+func TestEntityFilters(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	mockElasticResponses(func(req *http.Request) string {
+		if strings.HasSuffix(req.URL.Path, "/_mapping") {
+			return `{"tool": {"mappings": {"properties": {
+				"name": {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+				"license": {"type": "keyword"},
+				"createdAt": {"type": "date"}
+			}}}}`
+		}
+		return `{"hits": {"hits": []}, "aggregations": {
+			"license": {"sum_other_doc_count": 7, "buckets": [{"key": "MIT", "doc_count": 4}]},
+			"createdAt.startDate": {"value": 0, "value_as_string": "2020-01-01"},
+			"createdAt.endDate": {"value": 1, "value_as_string": "2024-01-01"}
+		}}`
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Params = gin.Params{{Key: "entity", Value: "tools"}}
+	c.Request.URL = &url.URL{}
+
+	EntityFilters(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	var testResp FacetsResponse
+	json.Unmarshal(w.Body.Bytes(), &testResp)
+	assert.EqualValues(t, "tool", testResp.FilterType)
+	assert.Len(t, testResp.Facets, 2)
+	assert.EqualValues(t, "createdAt", testResp.Facets[0].Key)
+	assert.EqualValues(t, "2024-01-01", testResp.Facets[0].Buckets[1]["value"])
+	assert.EqualValues(t, "license", testResp.Facets[1].Key)
+	assert.EqualValues(t, "MIT", testResp.Facets[1].Buckets[0]["key"])
+	assert.EqualValues(t, 7, testResp.Facets[1].SumOtherDocCount)
+	assert.Contains(t, w.Body.String(), `"sumOtherDocCount":7`)
+	assert.EqualValues(t, 7, testResp.Facets[1].SumOtherDocCount)
+}
+
+func TestEntityFiltersUnknownEntity(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Params = gin.Params{{Key: "entity", Value: "widgets"}}
+
+	EntityFilters(c)
+
+	assert.EqualValues(t, http.StatusNotFound, w.Code)
+}
+
+func TestFacetValuesElasticQuery(t *testing.T) {
+	valueSearch := FacetValueSearch{
+		Key:   "publisherName",
+		Query: "nhs*",
+		Match: matchContains,
+		Size:  2,
+		After: "NHS A",
+		Filters: map[string]map[string]interface{}{
+			"dataset": {
+				"publisherName": []interface{}{"NHS B"},
+				"dataType":      []interface{}{"Health"},
+			},
+		},
+	}
+
+	elasticQuery := facetValuesElasticQuery(searchEntities["datasets"], valueSearch)
+
+	filters := elasticQuery["query"].(gin.H)["bool"].(gin.H)["filter"].([]gin.H)
+	assert.Len(t, filters, 2)
+	assert.EqualValues(t, gin.H{"wildcard": gin.H{"publisherName": gin.H{
+		"value":            `*nhs\**`,
+		"case_insensitive": true,
+	}}}, filters[1])
+	composite := elasticQuery["aggs"].(gin.H)["values"].(gin.H)["composite"].(gin.H)
+	assert.EqualValues(t, 2, composite["size"])
+	assert.EqualValues(t, gin.H{"value": "NHS A"}, composite["after"])
+	assert.EqualValues(t, []gin.H{
+		{"value": gin.H{"terms": gin.H{"field": "publisherName", "order": "asc"}}},
+	}, composite["sources"])
+}
+
+func TestFacetValuesResponse(t *testing.T) {
+	var aggregations map[string]interface{}
+	json.Unmarshal([]byte(`{"values": {
+		"after_key": {"value": "Other"},
+		"buckets": [
+			{"key": {"value": "NHS A"}, "doc_count": 3},
+			{"key": {"value": "Other"}, "doc_count": 1}
+		]
+	}}`), &aggregations)
+
+	response := facetValuesResponse(FacetValueSearch{Key: "publisherName", Query: "nhs", Size: 2}, aggregations)
+	assert.EqualValues(t, []gin.H{{"key": "NHS A", "doc_count": float64(3)}}, response.Buckets)
+	assert.EqualValues(t, "Other", response.AfterKey)
+
+	response = facetValuesResponse(FacetValueSearch{Key: "publisherName", Query: "nhs", Size: 5}, aggregations)
+	assert.EqualValues(t, "", response.AfterKey)
+}
+
+func TestSearchFacetValuesNotTermsFacet(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	mockElasticResponses(func(req *http.Request) string {
+		return `{"tool": {"mappings": {"properties": {"name": {"type": "text"}}}}}`
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Params = gin.Params{{Key: "entity", Value: "tools"}}
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Body = io.NopCloser(strings.NewReader(`{"key": "name", "query": "a"}`))
+
+	SearchFacetValues(c)
+
+	assert.EqualValues(t, http.StatusBadRequest, w.Code)
+}
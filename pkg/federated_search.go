@@ -1,13 +1,19 @@
 package search
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
-	"os"
+	"net/url"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -45,6 +51,10 @@ type PaperCore struct {
 	PubTypeList           map[string]interface{} `json:"pubTypeList"`
 	FullTextUrlList       map[string][]PaperUrl  `json:"fullTextUrlList"`
 	FirrstPublicationDate string                 `json:"firstPublicationDate"`
+	PMID                  string                 `json:"pmid,omitempty"`
+	Source                string                 `json:"source,omitempty"`
+	GatewayPublication    *GatewayPublication    `json:"gatewayPublication,omitempty"`
+	MatchedQueries        []string               `json:"matchedQueries,omitempty"`
 }
 
 // PaperUrl represents the url objects returned from EuropePMC with each paper
@@ -56,75 +66,149 @@ type PaperUrl struct {
 	Url              string `json:"url"`
 }
 
+// FieldQuery represents a search for a single query string in the given
+// EuropePMC fields. PageSize and CursorMark are optional; CursorMark should be
+// the nextCursorMark returned with the previous page of results. Providers
+// optionally selects the literature providers to search.
 type FieldQuery struct {
 	QueryString string                            `json:"query"`
 	Field       []string                          `json:"field"`
 	Filters     map[string]map[string]interface{} `json:"filters"`
+	PageSize    int                               `json:"pageSize"`
+	CursorMark  string                            `json:"cursorMark"`
+	Providers   []string                          `json:"providers"`
 }
 
+// ArrayFieldQuery represents a search for several query strings in the given
+// EuropePMC fields. CursorMark is the combined cursor returned as
+// nextCursorMark with the previous page of results. MergeStrategy is one of
+// "roundRobin" (the default), "reciprocalRank" or "mostMatched".
 type ArrayFieldQuery struct {
-	QueryString []string                          `json:"query"`
-	Field       []string                          `json:"field"`
-	Filters     map[string]map[string]interface{} `json:"filters"`
+	QueryString   []string                          `json:"query"`
+	Field         []string                          `json:"field"`
+	Filters       map[string]map[string]interface{} `json:"filters"`
+	PageSize      int                               `json:"pageSize"`
+	CursorMark    string                            `json:"cursorMark"`
+	MergeStrategy string                            `json:"mergeStrategy"`
 }
 
-var (
-	Client HTTPClient
-)
-
-func init() {
-	Client = &http.Client{Timeout: 30 * time.Second}
+// arrayCursorPosition records how far through the results of one query string
+// of an ArrayFieldQuery the previous pages have got. CursorMark is the EuropePMC
+// cursor of the page currently being consumed and Offset the number of papers
+// from that page which have already been returned. Seen holds the keys of any
+// papers beyond Offset which were returned early, e.g. because another query
+// string ranked them highly, so that they are not returned again.
+type arrayCursorPosition struct {
+	CursorMark string   `json:"cursorMark"`
+	Offset     int      `json:"offset"`
+	Done       bool     `json:"done"`
+	Seen       []string `json:"seen,omitempty"`
 }
 
+const (
+	defaultPMCPageSize = 100
+	maxPMCPageSize     = 1000
+	initialCursorMark  = "*"
+)
+
 // DOISearch takes the given candidate doi string, attempts to extract the DOI
 // number from it, then searches the EuropePMC articles API for papers
-// matching that doi.
-// Returns results in PMCCoreResponse format.
+// matching that doi. Unfiltered searches are answered from the epmc_cache
+// index when a fresh copy of the paper is held there.
+// Returns results in PMCCoreResponse format, or in LiteratureResponse format
+// if providers are given in the request.
 func DOISearch(c *gin.Context) {
 	var query Query
 	if err := c.BindJSON(&query); err != nil {
 		return
 	}
 
-	queryString := buildDoiQuery(query)
+	if len(query.Providers) > 0 {
+		respondWithLiterature(c, query.Providers, func(ctx context.Context, provider LiteratureProvider) (LiteratureResult, error) {
+			return provider.SearchDOI(ctx, query)
+		})
+		return
+	}
 
-	urlPath := fmt.Sprintf(
-		"%s/search?%s&resultType=core&format=json&pageSize=100",
-		os.Getenv("PMC_URL"),
-		queryString,
-	)
+	result, found := PMCCoreResponse{}, false
+	if _, filtered := query.Filters["paper"]; !filtered {
+		result, found = cachedPaperByDOI(c.Request.Context(), normaliseDOI(query.QueryString))
+	}
+	if !found {
+		urlPath, err := epmcSearchURL(buildDoiQuery(query), defaultPMCPageSize, initialCursorMark)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-	respBody := getPMC(urlPath)
+		result, err = fetchPMC(c.Request.Context(), urlPath)
+		if err != nil {
+			c.JSON(pmcErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
 
-	var result PMCCoreResponse
-	json.Unmarshal(respBody, &result)
+	annotatePublications(c.Request.Context(), result.ResultList["result"])
 
 	c.JSON(http.StatusOK, result)
 }
 
 // FieldSearch searches the EuropePMC articles API for papers where the given
 // query string appears in the given field (e.g. "ABSTRACT", "METHODS", "SUPPL").
-// Returns results as an array of PaperCore.
+// Returns results as an array of PaperCore, with the nextCursorMark to pass
+// back in the following request to fetch the next page.
+// If providers are given in the request, results are returned in
+// LiteratureResponse format, with a nextCursorMark combining the cursor of
+// each provider.
 func FieldSearch(c *gin.Context) {
 	var query FieldQuery
 	if err := c.BindJSON(&query); err != nil {
 		return
 	}
+	if err := validateFieldQuery(query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	queryString := buildQueryString(query)
+	if len(query.Providers) > 0 {
+		providers, err := selectLiteratureProviders(query.Providers)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		cursors, err := decodeLiteratureCursor(query.CursorMark, providers)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-	urlPath := fmt.Sprintf(
-		"%s/search?%s&resultType=core&format=json&pageSize=100",
-		os.Getenv("PMC_URL"),
-		queryString,
-	)
+		response, err := searchLiteratureFields(c.Request.Context(), providers, query, cursors)
+		if err != nil {
+			c.JSON(pmcErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	urlPath, err := epmcSearchURL(buildQueryString(query), pmcPageSize(query.PageSize), query.CursorMark)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	respBody := getPMC(urlPath)
+	result, err := fetchPMC(c.Request.Context(), urlPath)
+	if err != nil {
+		c.JSON(pmcErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
 
-	var result PMCCoreResponse
-	json.Unmarshal(respBody, &result)
+	annotatePublications(c.Request.Context(), result.ResultList["result"])
 
 	aggs := calculateAggregations(result)
+	if firstPMCPage(query.CursorMark) {
+		addPMCFacetCounts(c.Request.Context(), aggs, []FieldQuery{query})
+	}
 	result.Aggregations = aggs
 
 	c.JSON(http.StatusOK, result)
@@ -132,98 +216,429 @@ func FieldSearch(c *gin.Context) {
 
 // ArrayFieldSearch searches the EuropePMC articles API for papers where the given
 // query strings appears in the given field (e.g. "ABSTRACT", "METHODS", "SUPPL").
-// Returns results as an array of PaperCore.
+// Returns results as an array of PaperCore, with a combined nextCursorMark
+// tracking the position reached in the results of each query string.
 func ArrayFieldSearch(c *gin.Context) {
 	var queryArray ArrayFieldQuery
 	if err := c.BindJSON(&queryArray); err != nil {
 		return
 	}
 
+	if err := validateArrayFieldQuery(queryArray); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pageSize := pmcPageSize(queryArray.PageSize)
+	positions, total, err := decodeArrayCursor(queryArray.CursorMark, queryArray.QueryString, pageSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validMergeStrategy(queryArray.MergeStrategy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	allResults := PMCCoreResponse{
 		ResultList: map[string][]PaperCore{
 			"result": {},
 		},
 	}
-	maxResults := 100
 
-	resultChannel := make(chan PMCCoreResponse)
+	// Results are held in request order so that the merged page, and hence
+	// the combined cursor, are deterministic.
+	pages := make([]PMCCoreResponse, len(queryArray.QueryString))
+	errs := make([]error, len(queryArray.QueryString))
 	var wg sync.WaitGroup
 
-	for _, query := range queryArray.QueryString {
+	for i, query := range queryArray.QueryString {
+		if positions[i].Done {
+			continue
+		}
 		wg.Add(1)
-		go func(query string) {
+		go func(i int, query string) {
 			defer wg.Done()
-			resultChannel <- epmcFieldQuey(query, queryArray)
-		}(query)
+			pages[i], errs[i] = epmcFieldQuey(c.Request.Context(), query, queryArray, pageSize, positions[i].CursorMark)
+		}(i, query)
 	}
+	wg.Wait()
 
-	go func() {
-		wg.Wait()
-		close(resultChannel)
-	}()
+	// A page missing from one query string would leave its cursor position
+	// unknown, so any failure fails the whole request.
+	if err := errors.Join(errs...); err != nil {
+		c.JSON(pmcErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
 
-	results := [][]PaperCore{}
-	for r := range resultChannel {
-		results = append(results, r.ResultList["result"])
+	// Papers returned early by a previous page are treated as consumed so
+	// that they are neither returned again nor hold back the cursor.
+	consumed := make(map[string]bool)
+	for _, position := range positions {
+		for _, key := range position.Seen {
+			consumed[key] = true
+		}
 	}
-	shuffledResults := shuffleArrays(results)
-	if len(shuffledResults) < maxResults {
-		allResults.ResultList["result"] = shuffledResults
-	} else {
-		allResults.ResultList["result"] = shuffledResults[0:maxResults]
+
+	results := make([][]PaperCore, len(pages))
+	for i, page := range pages {
+		results[i] = unconsumedPapers(page, positions[i], consumed)
 	}
 
+	merged := mergePMCResults(results, queryArray.QueryString, queryArray.MergeStrategy)
+	if len(merged) > pageSize {
+		merged = merged[0:pageSize]
+	}
+	for _, paper := range merged {
+		consumed[paperCacheKey(paper)] = true
+	}
+	allResults.ResultList["result"] = merged
+
+	for i, page := range pages {
+		if positions[i].Done {
+			continue
+		}
+		positions[i] = advanceArrayPosition(positions[i], page, consumed, pageSize)
+	}
+
+	annotatePublications(c.Request.Context(), allResults.ResultList["result"])
+
+	fieldQueries := []FieldQuery{}
+	for _, query := range queryArray.QueryString {
+		fieldQueries = append(fieldQueries, FieldQuery{
+			QueryString: query,
+			Field:       queryArray.Field,
+			Filters:     queryArray.Filters,
+		})
+	}
 	aggs := calculateAggregations(allResults)
+	// The total is found with the first page and carried in the cursor, so
+	// that it stays the same as the client pages through the results.
+	if firstPMCPage(queryArray.CursorMark) {
+		var ok bool
+		total, ok = addPMCFacetCounts(c.Request.Context(), aggs, fieldQueries)
+		if !ok {
+			// Without a count from EuropePMC the papers found so far are the
+			// best available estimate of the deduplicated total.
+			total = len(mergePMCResults(results, queryArray.QueryString, mergeRoundRobin))
+		}
+	}
 	allResults.Aggregations = aggs
-	allResults.HitCount = len(allResults.ResultList["result"])
+	allResults.HitCount = total
+	allResults.NextCursorMark = encodeArrayCursor(positions, queryArray.QueryString, pageSize, total)
 
 	c.JSON(http.StatusOK, allResults)
 }
 
-func epmcFieldQuey(query string, queryArray ArrayFieldQuery) PMCCoreResponse {
+// respondWithLiterature runs search against the named literature providers
+// and responds with the combined results.
+func respondWithLiterature(
+	c *gin.Context,
+	names []string,
+	search func(ctx context.Context, provider LiteratureProvider) (LiteratureResult, error),
+) {
+	providers, err := selectLiteratureProviders(names)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := searchLiterature(c.Request.Context(), providers, search)
+	if err != nil {
+		c.JSON(pmcErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func epmcFieldQuey(ctx context.Context, query string, queryArray ArrayFieldQuery, pageSize int, cursorMark string) (PMCCoreResponse, error) {
 	singleFieldQuery := FieldQuery{
 		QueryString: query,
 		Field:       queryArray.Field,
 		Filters:     queryArray.Filters,
 	}
-	queryString := buildQueryString(singleFieldQuery)
+	urlPath, err := epmcSearchURL(buildQueryString(singleFieldQuery), pageSize, cursorMark)
+	if err != nil {
+		return PMCCoreResponse{}, err
+	}
 
-	urlPath := fmt.Sprintf(
-		"%s/search?%s&resultType=core&format=json&pageSize=100",
-		os.Getenv("PMC_URL"),
-		queryString,
-	)
+	return fetchPMC(ctx, urlPath)
+}
 
-	respBody := getPMC(urlPath)
+// validateFieldQuery checks that a FieldQuery gives a query string and the
+// fields to search it in.
+func validateFieldQuery(query FieldQuery) error {
+	if strings.TrimSpace(query.QueryString) == "" {
+		return errors.New("query must not be empty")
+	}
+	return validateFields(query.Field)
+}
 
-	var result PMCCoreResponse
-	json.Unmarshal(respBody, &result)
+// validateArrayFieldQuery checks that an ArrayFieldQuery gives at least one
+// query string, none of them empty, and the fields to search them in.
+func validateArrayFieldQuery(query ArrayFieldQuery) error {
+	if len(query.QueryString) == 0 {
+		return errors.New("query must give at least one query string")
+	}
+	for _, queryString := range query.QueryString {
+		if strings.TrimSpace(queryString) == "" {
+			return errors.New("query strings must not be empty")
+		}
+	}
+	return validateFields(query.Field)
+}
+
+// pmcSearchFields are the EuropePMC search fields which field searches may
+// use. Fields are written into the query unescaped, so nothing else is
+// accepted.
+var pmcSearchFields = []string{
+	"TITLE", "ABSTRACT", "TITLE_ABS", "KW", "AUTH", "AFF", "JOURNAL", "DOI",
+	"INTRO", "METHODS", "RESULTS", "DISCUSS", "CONCL", "CASE", "ACK_FUND",
+	"AUTH_CON", "COMP_INT", "ABBR", "APPENDIX", "FIG", "TABLE", "REF", "SUPPL",
+	"BODY", "MESH", "GRANT_AGENCY", "ORGANISM", "DISEASE", "GENE_PROTEIN",
+	"CHEMICAL", "GO_TERM", "EXPERIMENTAL_METHOD",
+}
+
+// validateFields checks that fields gives at least one field to search, each
+// of them one of pmcSearchFields.
+func validateFields(fields []string) error {
+	if len(fields) == 0 {
+		return errors.New("field must give at least one field to search")
+	}
+	for _, field := range fields {
+		if !slices.Contains(pmcSearchFields, field) {
+			return fmt.Errorf("field %q is not a EuropePMC search field", field)
+		}
+	}
+	return nil
+}
+
+// epmcSearchURL builds the url of a search of the EuropePMC articles API for
+// the page of results of the given size starting at cursorMark. An error is
+// returned if query is nil, as EuropePMC rejects an empty query.
+func epmcSearchURL(query pmcQuery, pageSize int, cursorMark string) (string, error) {
+	if query == nil {
+		return "", errEmptyPMCQuery
+	}
+	if cursorMark == "" {
+		cursorMark = initialCursorMark
+	}
+	params := url.Values{}
+	params.Set("query", query.String())
+	params.Set("resultType", "core")
+	params.Set("format", "json")
+	params.Set("pageSize", strconv.Itoa(pageSize))
+	params.Set("cursorMark", cursorMark)
+	return fmt.Sprintf("%s/search?%s", pmcBaseURL, params.Encode()), nil
+}
+
+// pmcPageSize returns the requested page size, defaulting to 100 and capped at
+// the EuropePMC maximum of 1000.
+func pmcPageSize(pageSize int) int {
+	if pageSize <= 0 {
+		return defaultPMCPageSize
+	}
+	if pageSize > maxPMCPageSize {
+		return maxPMCPageSize
+	}
+	return pageSize
+}
+
+// advanceArrayCursor returns the position of a query string after taken papers
+// from the given page have been returned. If the page was only partly used the
+// next request fetches the same page again and skips the papers already seen.
+func advanceArrayCursor(position arrayCursorPosition, page PMCCoreResponse, taken int, pageSize int) arrayCursorPosition {
+	pageLength := len(page.ResultList["result"])
+	if position.Offset+taken < pageLength {
+		position.Offset += taken
+		return position
+	}
+	if pageLength < pageSize || page.NextCursorMark == "" || page.NextCursorMark == position.CursorMark {
+		return arrayCursorPosition{CursorMark: position.CursorMark, Done: true}
+	}
+	return arrayCursorPosition{CursorMark: page.NextCursorMark}
+}
+
+// unconsumedPapers returns the papers of page from position onwards which have
+// not already been returned.
+func unconsumedPapers(page PMCCoreResponse, position arrayCursorPosition, consumed map[string]bool) []PaperCore {
+	papers := []PaperCore{}
+	if position.Done {
+		return papers
+	}
+	result := page.ResultList["result"]
+	for i := position.Offset; i < len(result); i++ {
+		if !consumed[paperCacheKey(result[i])] {
+			papers = append(papers, result[i])
+		}
+	}
+	return papers
+}
+
+// advanceArrayPosition returns the position of a query string once the papers
+// in consumed have been returned. The offset only moves past the unbroken run
+// of consumed papers, with any consumed after a gap recorded as Seen.
+func advanceArrayPosition(position arrayCursorPosition, page PMCCoreResponse, consumed map[string]bool, pageSize int) arrayCursorPosition {
+	result := page.ResultList["result"]
+	taken := 0
+	for position.Offset+taken < len(result) && consumed[paperCacheKey(result[position.Offset+taken])] {
+		taken++
+	}
 
-	return result
+	next := advanceArrayCursor(position, page, taken, pageSize)
+	next.Seen = nil
+	if next.Done || next.CursorMark != position.CursorMark {
+		return next
+	}
+	for _, paper := range result[next.Offset:] {
+		if key := paperCacheKey(paper); consumed[key] {
+			next.Seen = append(next.Seen, key)
+		}
+	}
+	return next
+}
+
+// arrayCursor is the combined cursor of an ArrayFieldQuery, holding the
+// position of each query string by its index in the query. Queries and
+// PageSize identify the search the cursor was built for, so that it is not
+// applied to a different one. Total is the hit count found with the first
+// page, returned again with every later page.
+type arrayCursor struct {
+	Queries   string                `json:"queries"`
+	PageSize  int                   `json:"pageSize"`
+	Total     int                   `json:"total"`
+	Positions []arrayCursorPosition `json:"positions"`
+}
+
+// decodeArrayCursor decodes the combined cursor of an ArrayFieldQuery into the
+// position of each query string and the total hit count. An empty cursor starts every query string
+// from the first page. A cursor built for different query strings or a
+// different page size is rejected, as is one with an offset outside the page.
+func decodeArrayCursor(cursor string, queries []string, pageSize int) ([]arrayCursorPosition, int, error) {
+	positions := make([]arrayCursorPosition, len(queries))
+	for i := range positions {
+		positions[i].CursorMark = initialCursorMark
+	}
+	if cursor == "" || cursor == initialCursorMark {
+		return positions, 0, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid cursorMark: %w", err)
+	}
+	var combined arrayCursor
+	if err := json.Unmarshal(decoded, &combined); err != nil {
+		return nil, 0, fmt.Errorf("invalid cursorMark: %w", err)
+	}
+	if combined.Queries != queriesDigest(queries) || len(combined.Positions) != len(queries) {
+		return nil, 0, errors.New("cursorMark was returned for a different list of queries")
+	}
+	if combined.PageSize != pageSize {
+		return nil, 0, fmt.Errorf("cursorMark was returned for a page size of %d", combined.PageSize)
+	}
+	for _, position := range combined.Positions {
+		if position.Offset < 0 || position.Offset >= pageSize {
+			return nil, 0, fmt.Errorf("invalid cursorMark: offset %d is outside the page", position.Offset)
+		}
+	}
+	return combined.Positions, combined.Total, nil
+}
+
+// encodeArrayCursor encodes the position of each query string and the total
+// hit count into a single opaque cursor. An empty string is returned once all
+// results have been seen.
+func encodeArrayCursor(positions []arrayCursorPosition, queries []string, pageSize int, total int) string {
+	finished := true
+	for _, position := range positions {
+		if !position.Done {
+			finished = false
+		}
+	}
+	if finished {
+		return ""
+	}
+	encoded, err := json.Marshal(arrayCursor{
+		Queries:   queriesDigest(queries),
+		PageSize:  pageSize,
+		Total:     total,
+		Positions: positions,
+	})
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to encode cursor with: %s", err.Error()))
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// queriesDigest returns a short digest of the query strings of an
+// ArrayFieldQuery, in order, to identify them in its cursor.
+func queriesDigest(queries []string) string {
+	encoded, _ := json.Marshal(queries)
+	sum := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// searchPMC queries the EuropePMC articles API using the given urlPath and
+// decodes the response.
+func searchPMC(ctx context.Context, urlPath string) (PMCCoreResponse, error) {
+	var result PMCCoreResponse
+	respBody, err := getPMC(ctx, urlPath)
+	if err != nil {
+		return result, err
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to unmarshal EPMC response with: %s", err.Error()))
+		return result, fmt.Errorf("invalid response from EuropePMC: %w", err)
+	}
+	return result, nil
 }
 
 // getPMC queries the EuropePMC articles API using the given urlPath.
-func getPMC(urlPath string) []byte {
-	req, err := http.NewRequest("GET", urlPath, strings.NewReader(""))
+// An error is returned if the request fails or EuropePMC responds with a
+// status other than 200.
+func getPMC(ctx context.Context, urlPath string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", urlPath, nil)
 	if err != nil {
 		slog.Info(fmt.Sprintf("Failed to build EPMC query with: %s", err.Error()))
-		return nil
+		return nil, err
 	}
 	req.Header.Add("Content-Type", "application/json")
 
-	response, err := Client.Do(req)
+	response, err := PMCClient.Do(req)
 	if err != nil {
 		slog.Info(fmt.Sprintf("Failed to execute EPMC query with: %s", err.Error()))
-		return nil
+		return nil, err
 	}
 	defer response.Body.Close()
 
 	respBody, err := io.ReadAll(response.Body)
 	if err != nil {
 		slog.Warn(fmt.Sprintf("Failed to get EPMC response with: %s", err.Error()))
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		slog.Warn(fmt.Sprintf("EPMC query returned status %d: %s", response.StatusCode, respBody))
+		return nil, fmt.Errorf("EuropePMC responded with status %d", response.StatusCode)
 	}
 
-	return respBody
+	return respBody, nil
+}
+
+// pmcErrorStatus returns the status code to respond with when a query to
+// EuropePMC fails.
+func pmcErrorStatus(err error) int {
+	if errors.Is(err, errEmptyPMCQuery) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
 }
 
 // extractDOI attempts to extract a doi number (starting "10.") from the doi string.
@@ -233,112 +648,129 @@ func extractDOI(doi string) string {
 		slog.Debug(fmt.Sprintf("String is not a valid doi: %s", doi))
 		return doi
 	}
-	endInd := len(doi)
-	doiNum := doi[startInd:endInd]
-	doiNum = strings.Replace(doiNum, "(", "\\(", -1)
-	doiNum = strings.Replace(doiNum, ")", "\\)", -1)
-
-	return doiNum
+	return doi[startInd:]
 }
 
-func buildDoiQuery(query Query) string {
+func buildDoiQuery(query Query) pmcQuery {
 	doi := extractDOI(query.QueryString)
-	queryString := fmt.Sprintf("query=(DOI:%s)", doi)
+	return pmcAnd(
+		newPMCPhrase("DOI", doi),
+		getFilters(query.Filters),
+	)
+}
 
-	_, ok := query.Filters["paper"]
-	if ok {
-		filterString := getFilters(query.Filters)
-		queryString = fmt.Sprintf("%s%%20AND%%20%s", queryString, filterString)
-		return queryString
-	} else {
-		return queryString
+func buildQueryString(query FieldQuery) pmcQuery {
+	fieldClauses := []pmcQuery{}
+	for _, fieldString := range query.Field {
+		fieldClauses = append(fieldClauses, newPMCTerm(fieldString, query.QueryString))
 	}
+	return pmcAnd(
+		pmcOr(fieldClauses...),
+		getFilters(query.Filters),
+	)
 }
 
-func buildQueryString(query FieldQuery) string {
-	queryString := "query=(("
-	r := strings.NewReplacer(
-		" ", "%20",
-		"/", "",
-	)
-	queryFormatted := r.Replace(query.QueryString)
-	for i, fieldString := range query.Field {
-		if i == (len(query.Field) - 1) {
-			queryString = fmt.Sprintf(
-				"%s%s:%s))", queryString, fieldString, queryFormatted,
-			)
-		} else {
-			queryString = fmt.Sprintf(
-				"%s%s:%s)%%20OR%%20(", queryString, fieldString, queryFormatted,
-			)
-		}
+// getFilters converts the "paper" filters of a query into EuropePMC clauses,
+// returning nil if no filters apply.
+func getFilters(filters map[string]map[string]interface{}) pmcQuery {
+	var dateFilter pmcQuery
+	if from, to, ok := paperYearRange(filters); ok {
+		dateFilter = pmcRange{Field: "PUB_YEAR", From: from, To: to}
 	}
-	_, ok := query.Filters["paper"]
-	if ok {
-		filterString := getFilters(query.Filters)
-		fullString := fmt.Sprintf("%s%%20AND%%20%s", queryString, filterString)
-		return fullString
-	} else {
-		return queryString
-	}
-}
-
-func getFilters(filters map[string]map[string]interface{}) string {
-	var queryString string
-	var filterType []string
-	var allFilterType string
-	if val, ok := filters["paper"]["publicationDate"]; ok {
-		filterDate := fmt.Sprintf(
-			"PUB_YEAR:[%s%%20TO%%20%s]",
-			val.([]interface{})[0].(string),
-			val.([]interface{})[1].(string),
-		)
-		queryString = filterDate
-	}
-	if val, ok := filters["paper"]["publicationType"]; ok {
-		for _, t := range val.([]interface{}) {
-			str := publicationTypeFilter(t.(string))
-			filterType = append(filterType, str)
-		}
-		allFilterType = strings.Join(filterType, "%20OR%20")
-		if queryString != "" {
-			queryString = fmt.Sprintf("%s%%20AND%%20%s", queryString, allFilterType)
-		} else {
-			queryString = allFilterType
+
+	typeFilters := []pmcQuery{}
+	for _, pubType := range paperTypeFilters(filters) {
+		typeFilters = append(typeFilters, publicationTypeFilter(pubType))
+	}
+
+	return pmcAnd(dateFilter, pmcOr(typeFilters...))
+}
+
+// paperYearRange returns the publication years given by the "publicationDate"
+// paper filter. A single year leaves the end of the range open.
+func paperYearRange(filters map[string]map[string]interface{}) (from string, to string, ok bool) {
+	val, ok := filters["paper"]["publicationDate"].([]interface{})
+	if !ok || len(val) == 0 {
+		return "", "", false
+	}
+	from, _ = val[0].(string)
+	if len(val) > 1 {
+		to, _ = val[1].(string)
+	}
+	return from, to, true
+}
+
+// paperTypeFilters returns the gateway publication types given by the
+// "publicationType" paper filter.
+func paperTypeFilters(filters map[string]map[string]interface{}) []string {
+	pubTypes := []string{}
+	if val, ok := filters["paper"]["publicationType"].([]interface{}); ok {
+		for _, t := range val {
+			if pubType, ok := t.(string); ok {
+				pubTypes = append(pubTypes, pubType)
+			}
 		}
 	}
-	return queryString
-}
-
-func publicationTypeFilter(pubType string) string {
-	var filterStr string
-	if pubType == "Research articles" {
-		filterStr = strings.Replace(
-			"(((SRC:MED OR SRC:PMC OR SRC:AGR OR SRC:CBA) NOT (PUB_TYPE:\"Review\")))", " ", "%20", -1,
-		)
-	} else if pubType == "Review articles" {
-		filterStr = "PUB_TYPE:REVIEW"
-	} else if pubType == "Preprints" {
-		filterStr = "SRC:PPR"
-	} else if pubType == "Books and documents" {
-		filterStr = "HAS_BOOK:Y"
-	} else {
+	return pubTypes
+}
+
+// publicationTypeFilter returns the EuropePMC clause matching the given
+// gateway publication type, or nil if the type is not recognised.
+func publicationTypeFilter(pubType string) pmcQuery {
+	switch pubType {
+	case "Research articles":
+		return pmcNot{
+			Include: pmcOr(
+				pmcTerm{Field: "SRC", Value: "MED"},
+				pmcTerm{Field: "SRC", Value: "PMC"},
+				pmcTerm{Field: "SRC", Value: "AGR"},
+				pmcTerm{Field: "SRC", Value: "CBA"},
+			),
+			Exclude: pmcPhrase{Field: "PUB_TYPE", Value: "Review"},
+		}
+	case "Review articles":
+		return pmcTerm{Field: "PUB_TYPE", Value: "REVIEW"}
+	case "Preprints":
+		return pmcTerm{Field: "SRC", Value: "PPR"}
+	case "Books and documents":
+		return pmcTerm{Field: "HAS_BOOK", Value: "Y"}
+	default:
 		slog.Debug(fmt.Sprintf("Unknown filter option: %s", pubType))
+		return nil
 	}
-
-	return filterStr
 }
 
+// calculateAggregations computes the facets shown alongside federated paper
+// results from the given page of results: the earliest and latest publication
+// year, a per-year histogram, and publication type, journal and open access
+// buckets. Facets in the same format, counted across every hit, can be
+// overlaid using countPMCFacets.
 func calculateAggregations(results PMCCoreResponse) gin.H {
 	aggregations := make(map[string]interface{})
 	minDate := time.Now()
 	maxDate, _ := time.Parse("2006", "1900")
+
+	yearCounts := make(map[string]int)
+	journalCounts := make(map[string]int)
+	typeCounts := make(map[string]int)
+	openAccess := 0
 	for _, res := range results.ResultList["result"] {
+		for _, category := range paperPublicationTypes(res) {
+			typeCounts[category]++
+		}
+		if journal := paperJournal(res); journal != "" {
+			journalCounts[journal]++
+		}
+		if paperOpenAccess(res) {
+			openAccess++
+		}
+
 		d, err := time.Parse("2006", res.PubYear)
 		if err != nil {
 			slog.Info(fmt.Sprintf("Failed to convert year to date: %s", res.PubYear))
 			continue
 		}
+		yearCounts[res.PubYear]++
 		if d.Before(minDate) {
 			minDate = d
 		}
@@ -349,9 +781,214 @@ func calculateAggregations(results PMCCoreResponse) gin.H {
 	}
 	aggregations["startDate"] = gin.H{"value_as_string": minDate.Format(time.RFC3339)}
 	aggregations["endDate"] = gin.H{"value_as_string": maxDate.Format(time.RFC3339)}
+
+	years := []string{}
+	for year := range yearCounts {
+		years = append(years, year)
+	}
+	sort.Strings(years)
+	yearBuckets := []gin.H{}
+	for _, year := range years {
+		yearBuckets = append(yearBuckets, gin.H{"key": year, "doc_count": yearCounts[year]})
+	}
+	aggregations["pubYear"] = gin.H{"buckets": yearBuckets}
+
+	journals := []string{}
+	for journal := range journalCounts {
+		journals = append(journals, journal)
+	}
+	sort.Slice(journals, func(i, j int) bool {
+		if journalCounts[journals[i]] != journalCounts[journals[j]] {
+			return journalCounts[journals[i]] > journalCounts[journals[j]]
+		}
+		return journals[i] < journals[j]
+	})
+	journalBuckets := []gin.H{}
+	for _, journal := range journals {
+		journalBuckets = append(journalBuckets, gin.H{"key": journal, "doc_count": journalCounts[journal]})
+	}
+	aggregations["journal"] = gin.H{"buckets": journalBuckets}
+
+	typeBuckets := []gin.H{}
+	for _, category := range publicationTypeCategories {
+		typeBuckets = append(typeBuckets, gin.H{"key": category, "doc_count": typeCounts[category]})
+	}
+	aggregations["publicationType"] = gin.H{"buckets": typeBuckets}
+	aggregations["openAccess"] = openAccessBuckets(openAccess, len(results.ResultList["result"]))
+
 	return aggregations
 }
 
+// publicationTypeCategories are the gateway publication types understood by
+// publicationTypeFilter, in the order they are shown.
+var publicationTypeCategories = []string{
+	"Research articles",
+	"Review articles",
+	"Preprints",
+	"Books and documents",
+}
+
+// paperPublicationTypes returns the gateway publication types of a paper,
+// following the same rules as publicationTypeFilter.
+func paperPublicationTypes(paper PaperCore) []string {
+	isReview := false
+	if pubTypes, ok := paper.PubTypeList["pubType"].([]interface{}); ok {
+		for _, t := range pubTypes {
+			if s, ok := t.(string); ok && strings.Contains(strings.ToLower(s), "review") {
+				isReview = true
+			}
+		}
+	}
+
+	categories := []string{}
+	switch paper.Source {
+	case "MED", "PMC", "AGR", "CBA":
+		if !isReview {
+			categories = append(categories, "Research articles")
+		}
+	case "PPR":
+		categories = append(categories, "Preprints")
+	}
+	if isReview {
+		categories = append(categories, "Review articles")
+	}
+	if len(paper.BookOrReportDetails) > 0 {
+		categories = append(categories, "Books and documents")
+	}
+	return categories
+}
+
+// paperJournal returns the title of the journal a paper was published in.
+func paperJournal(paper PaperCore) string {
+	journal, ok := paper.JournalInfo["journal"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	title, _ := journal["title"].(string)
+	return title
+}
+
+// paperOpenAccess reports whether any of the full text links of a paper are
+// open access.
+func paperOpenAccess(paper PaperCore) bool {
+	for _, u := range paper.FullTextUrlList["fullTextUrl"] {
+		if u.AvailabilityCode == "OA" || strings.EqualFold(u.Availability, "Open access") {
+			return true
+		}
+	}
+	return false
+}
+
+func openAccessBuckets(openAccess int, total int) gin.H {
+	return gin.H{
+		"buckets": []gin.H{
+			{"key": "Open access", "doc_count": openAccess},
+			{"key": "Not open access", "doc_count": max(total-openAccess, 0)},
+		},
+	}
+}
+
+// countPMCFacets counts the publication type and open access facets across
+// every hit of the given field queries, rather than a single page, using the
+// hitCount of EuropePMC searches restricted to each bucket. As with elastic
+// facets, the publication type counts ignore any publication type filter so
+// that other types can still be selected. The number of distinct papers
+// matching any of the queries is also returned.
+func countPMCFacets(ctx context.Context, queries []FieldQuery) (gin.H, int, error) {
+	allQueries := []pmcQuery{}
+	unfilteredTypeQueries := []pmcQuery{}
+	for _, query := range queries {
+		allQueries = append(allQueries, buildQueryString(query))
+		query.Filters = withoutPaperFilter(query.Filters, "publicationType")
+		unfilteredTypeQueries = append(unfilteredTypeQueries, buildQueryString(query))
+	}
+	fullQuery := pmcOr(allQueries...)
+	typeQuery := pmcOr(unfilteredTypeQueries...)
+	if fullQuery == nil {
+		return gin.H{}, 0, nil
+	}
+
+	countQueries := []pmcQuery{
+		fullQuery,
+		pmcAnd(fullQuery, pmcTerm{Field: "OPEN_ACCESS", Value: "y"}),
+	}
+	for _, category := range publicationTypeCategories {
+		countQueries = append(countQueries, pmcAnd(typeQuery, publicationTypeFilter(category)))
+	}
+
+	counts := make([]int, len(countQueries))
+	errs := make([]error, len(countQueries))
+	var wg sync.WaitGroup
+	for i, countQuery := range countQueries {
+		wg.Add(1)
+		go func(i int, countQuery pmcQuery) {
+			defer wg.Done()
+			urlPath, err := epmcSearchURL(countQuery, 1, initialCursorMark)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			var result PMCCoreResponse
+			result, errs[i] = fetchPMC(ctx, urlPath)
+			counts[i] = result.HitCount
+		}(i, countQuery)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, 0, err
+	}
+
+	typeBuckets := []gin.H{}
+	for i, category := range publicationTypeCategories {
+		typeBuckets = append(typeBuckets, gin.H{"key": category, "doc_count": counts[i+2]})
+	}
+	return gin.H{
+		"publicationType": gin.H{"buckets": typeBuckets},
+		"openAccess":      openAccessBuckets(counts[1], counts[0]),
+	}, counts[0], nil
+}
+
+// withoutPaperFilter returns a copy of filters without the given paper filter.
+func withoutPaperFilter(filters map[string]map[string]interface{}, key string) map[string]map[string]interface{} {
+	copied := make(map[string]map[string]interface{})
+	for filterType, byKey := range filters {
+		copied[filterType] = make(map[string]interface{})
+		for k, v := range byKey {
+			if filterType == "paper" && k == key {
+				continue
+			}
+			copied[filterType][k] = v
+		}
+	}
+	return copied
+}
+
+// addPMCFacetCounts overlays the facets counted across every hit of the given
+// queries onto aggregations, keeping the page level counts if EuropePMC
+// cannot be queried. Returns the number of distinct papers matching any of the
+// queries, and whether it could be counted.
+func addPMCFacetCounts(ctx context.Context, aggregations gin.H, queries []FieldQuery) (int, bool) {
+	// The counts are extra requests beyond the search itself, so their
+	// failures are kept out of the circuit breaker.
+	ctx = withoutBreakerAccounting(ctx)
+	counted, total, err := countPMCFacets(ctx, queries)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to count EPMC facets, using page counts: %s", err.Error()))
+		return 0, false
+	}
+	for k, v := range counted {
+		aggregations[k] = v
+	}
+	return total, true
+}
+
+// firstPMCPage reports whether cursorMark requests the first page of results.
+// Facets are only counted across every hit for the first page, as the counts
+// take several requests to EuropePMC and do not change from page to page.
+func firstPMCPage(cursorMark string) bool {
+	return cursorMark == "" || cursorMark == initialCursorMark
+}
+
 // shuffleArrays takes an array of arrays of generic type and
 // returns a single array where the elements are the first of each input array,
 // the second of each input array, etc until all input arrays are exhausted.
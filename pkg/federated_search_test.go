@@ -2,12 +2,16 @@ package search
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"hdruk/search-service/utils/mocks"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -60,7 +64,7 @@ var epmcRespJson = `{
 }`
 
 func init() {
-	Client = &mocks.MockClient{}
+	PMCClient = &mocks.MockClient{}
 }
 
 func MockPostToDOISearch(c *gin.Context) {
@@ -121,7 +125,7 @@ func MockPostToArrayFieldSearch(c *gin.Context) {
 func TestExtractDOI(t *testing.T) {
 	doi := "https://doi.org/10.1010/a11-22(22)33v3"
 	extracted := extractDOI(doi)
-	expected := "10.1010/a11-22\\(22\\)33v3"
+	expected := "10.1010/a11-22(22)33v3"
 
 	assert.EqualValues(t, expected, extracted)
 
@@ -228,12 +232,54 @@ func TestArrayFieldSearch(t *testing.T) {
 	var testResp PMCCoreResponse
 	json.Unmarshal(bodyBytes, &testResp)
 
-	assert.EqualValues(t, 2, int(testResp.HitCount))
+	// Both query strings find the same paper, which is returned once
+	assert.EqualValues(t, 1, int(testResp.HitCount))
+	assert.Len(t, testResp.ResultList["result"], 1)
 	assert.EqualValues(t, "0000000", testResp.ResultList["result"][0].ID)
+	assert.EqualValues(t, []string{
+		"A Very Useful Dataset (AVUD)", "A Second Very Useful Dataset (ASVUD)",
+	}, testResp.ResultList["result"][0].MatchedQueries)
 	assert.Contains(t, testResp.Aggregations, "startDate")
 	assert.Contains(t, testResp.Aggregations, "endDate")
 }
 
+func TestFieldSearchValidation(t *testing.T) {
+	for _, body := range []string{
+		`{"query": "asthma"}`,
+		`{"query": " ", "field": ["TITLE"]}`,
+		`{"query": "asthma", "field": [""], "providers": ["europepmc"]}`,
+		`{"query": "asthma", "field": ["TITLE:x OR AUTH"]}`,
+		`{"query": "asthma", "field": ["TITLE", "title"]}`,
+	} {
+		w := httptest.NewRecorder()
+		c := GetTestGinContext(w)
+		c.Request.Method = "POST"
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.Body = io.NopCloser(bytes.NewBufferString(body))
+
+		FieldSearch(c)
+
+		assert.EqualValues(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	for _, body := range []string{
+		`{"query": ["asthma"]}`,
+		`{"query": [], "field": ["TITLE"]}`,
+		`{"query": ["asthma", ""], "field": ["TITLE"]}`,
+		`{"query": ["asthma"], "field": ["(TITLE"]}`,
+	} {
+		w := httptest.NewRecorder()
+		c := GetTestGinContext(w)
+		c.Request.Method = "POST"
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.Body = io.NopCloser(bytes.NewBufferString(body))
+
+		ArrayFieldSearch(c)
+
+		assert.EqualValues(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
 func TestBuildQueryString(t *testing.T) {
 	query := FieldQuery{
 		QueryString: "A Very Useful / Dataset (AVUD)",
@@ -246,13 +292,13 @@ func TestBuildQueryString(t *testing.T) {
 		},
 	}
 
-	queryString := buildQueryString(query)
+	queryString := buildQueryString(query).String()
 
-	assert.Contains(t, queryString, "TITLE")
+	assert.Contains(t, queryString, "TITLE:(A Very Useful \\/ Dataset \\(AVUD\\))")
+	assert.Contains(t, queryString, " OR ABSTRACT:")
 	assert.Contains(t, queryString, "PUB_TYPE:REVIEW")
 	assert.Contains(t, queryString, "SRC:PPR")
-	assert.Contains(t, queryString, "PUB_YEAR:[2020%20TO%202021]")
-	assert.NotContains(t, queryString, "/")
+	assert.Contains(t, queryString, "PUB_YEAR:[2020 TO 2021]")
 }
 
 func TestBuildDoiQuery(t *testing.T) {
@@ -266,12 +312,12 @@ func TestBuildDoiQuery(t *testing.T) {
 		},
 	}
 
-	queryString := buildDoiQuery(query)
+	queryString := buildDoiQuery(query).String()
 
-	assert.Contains(t, queryString, "DOI:10.3310/abcde")
+	assert.Contains(t, queryString, "DOI:\"10.3310/abcde\"")
 	assert.Contains(t, queryString, "PUB_TYPE:REVIEW")
 	assert.Contains(t, queryString, "SRC:PPR")
-	assert.Contains(t, queryString, "PUB_YEAR:[2020%20TO%202021]")
+	assert.Contains(t, queryString, "PUB_YEAR:[2020 TO 2021]")
 }
 
 func TestCalculateAggregations(t *testing.T) {
@@ -308,3 +354,259 @@ func TestShuffleArrays(t *testing.T) {
 
 	assert.EqualValues(t, expected, shuffled)
 }
+
+func TestFieldSearchCursor(t *testing.T) {
+	var mu sync.Mutex
+	requestedUrls := []string{}
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		requestedUrls = append(requestedUrls, req.URL.String())
+		mu.Unlock()
+		r := io.NopCloser(bytes.NewReader([]byte(epmcRespJson)))
+		return &http.Response{
+			StatusCode: 200,
+			Body:       r,
+		}, nil
+	}
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	bodyBytes, _ := json.Marshal(gin.H{
+		"query":      "asthma",
+		"field":      []string{"TITLE"},
+		"pageSize":   25,
+		"cursorMark": "AoIIP4AAACg=",
+	})
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+	FieldSearch(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	// Only the page of results is requested, as facets are counted on the first page
+	assert.Len(t, requestedUrls, 1)
+	assert.Contains(t, requestedUrls[0], "pageSize=25")
+	assert.Contains(t, requestedUrls[0], "cursorMark=AoIIP4AAACg%3D")
+}
+
+func TestPMCPageSize(t *testing.T) {
+	assert.EqualValues(t, 100, pmcPageSize(0))
+	assert.EqualValues(t, 25, pmcPageSize(25))
+	assert.EqualValues(t, 1000, pmcPageSize(5000))
+}
+
+func TestArrayCursor(t *testing.T) {
+	queries := []string{"query A", "query A", "query B"}
+
+	positions, total, err := decodeArrayCursor("", queries, 10)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "*", positions[0].CursorMark)
+	assert.EqualValues(t, "*", positions[2].CursorMark)
+	assert.EqualValues(t, 0, total)
+
+	positions[0] = arrayCursorPosition{CursorMark: "abc", Offset: 3}
+	positions[1] = arrayCursorPosition{CursorMark: "*", Offset: 1}
+	positions[2] = arrayCursorPosition{CursorMark: "def", Done: true}
+	cursor := encodeArrayCursor(positions, queries, 10, 42)
+	assert.NotEmpty(t, cursor)
+
+	decoded, total, err := decodeArrayCursor(cursor, queries, 10)
+	assert.Nil(t, err)
+	assert.EqualValues(t, positions, decoded)
+	assert.EqualValues(t, 42, total)
+
+	_, _, err = decodeArrayCursor(cursor, []string{"query B", "query A", "query A"}, 10)
+	assert.NotNil(t, err)
+	_, _, err = decodeArrayCursor(cursor, queries, 20)
+	assert.NotNil(t, err)
+
+	positions[0].Done = true
+	positions[1].Done = true
+	assert.Empty(t, encodeArrayCursor(positions, queries, 10, 42))
+
+	_, _, err = decodeArrayCursor("not a cursor", queries, 10)
+	assert.NotNil(t, err)
+}
+
+func TestArrayFieldSearchHitCountPages(t *testing.T) {
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		query := req.URL.Query()
+		body := `{"hitCount": 37, "resultList": {"result": []}}`
+		switch {
+		case query.Get("pageSize") == "1":
+		case strings.Contains(query.Get("query"), "asthma") && query.Get("cursorMark") == "*":
+			body = `{"hitCount": 3, "nextCursorMark": "a-next", "resultList": {"result": [{"id": "a1"}, {"id": "a2"}]}}`
+		case strings.Contains(query.Get("query"), "asthma"):
+			body = `{"hitCount": 3, "nextCursorMark": "a-last", "resultList": {"result": [{"id": "a3"}]}}`
+		case query.Get("cursorMark") == "*":
+			body = `{"hitCount": 1, "resultList": {"result": [{"id": "c1"}]}}`
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+
+	search := func(cursorMark string) PMCCoreResponse {
+		w := httptest.NewRecorder()
+		c := GetTestGinContext(w)
+		c.Request.Method = "POST"
+		c.Request.Header.Set("Content-Type", "application/json")
+		body, _ := json.Marshal(gin.H{"query": []string{"asthma", "copd"}, "field": []string{"TITLE"}, "pageSize": 2, "cursorMark": cursorMark})
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+
+		ArrayFieldSearch(c)
+
+		assert.EqualValues(t, http.StatusOK, w.Code)
+		var testResp PMCCoreResponse
+		json.Unmarshal(w.Body.Bytes(), &testResp)
+		return testResp
+	}
+
+	first := search("")
+	assert.EqualValues(t, 37, first.HitCount)
+	assert.Len(t, first.ResultList["result"], 2)
+	assert.NotEmpty(t, first.NextCursorMark)
+
+	second := search(first.NextCursorMark)
+	assert.EqualValues(t, 37, second.HitCount)
+	assert.NotEmpty(t, second.ResultList["result"])
+}
+
+func TestArrayFieldSearchInvalidOffset(t *testing.T) {
+	queries := []string{"asthma", "copd"}
+	for _, offset := range []int{-1, 10} {
+		cursor := encodeArrayCursor([]arrayCursorPosition{
+			{CursorMark: "*", Offset: offset},
+			{CursorMark: "*"},
+		}, queries, 10, 0)
+		body, _ := json.Marshal(gin.H{"query": queries, "field": []string{"TITLE"}, "pageSize": 10, "cursorMark": cursor})
+
+		w := httptest.NewRecorder()
+		c := GetTestGinContext(w)
+		c.Request.Method = "POST"
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+
+		ArrayFieldSearch(c)
+
+		assert.EqualValues(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid cursorMark")
+	}
+}
+
+func TestAdvanceArrayCursor(t *testing.T) {
+	page := PMCCoreResponse{
+		NextCursorMark: "next",
+		ResultList: map[string][]PaperCore{
+			"result": {{ID: "1"}, {ID: "2"}, {ID: "3"}},
+		},
+	}
+	start := arrayCursorPosition{CursorMark: "*"}
+
+	// Partly consumed page is revisited with an offset
+	partial := advanceArrayCursor(start, page, 2, 3)
+	assert.EqualValues(t, arrayCursorPosition{CursorMark: "*", Offset: 2}, partial)
+
+	// Fully consumed full page moves on to the next cursor
+	full := advanceArrayCursor(partial, page, 1, 3)
+	assert.EqualValues(t, arrayCursorPosition{CursorMark: "next"}, full)
+
+	// Fully consumed short page means there are no more results
+	done := advanceArrayCursor(start, page, 3, 10)
+	assert.True(t, done.Done)
+}
+
+func TestCalculateAggregationsFacets(t *testing.T) {
+	pmcCore := PMCCoreResponse{
+		ResultList: map[string][]PaperCore{
+			"result": {
+				{
+					PubYear:     "2020",
+					Source:      "MED",
+					JournalInfo: map[string]interface{}{"journal": map[string]interface{}{"title": "Journal A"}},
+					FullTextUrlList: map[string][]PaperUrl{
+						"fullTextUrl": {{Availability: "Open access", AvailabilityCode: "OA"}},
+					},
+				},
+				{
+					PubYear:     "2020",
+					Source:      "MED",
+					PubTypeList: map[string]interface{}{"pubType": []interface{}{"Review", "Journal Article"}},
+					JournalInfo: map[string]interface{}{"journal": map[string]interface{}{"title": "Journal B"}},
+				},
+				{
+					PubYear:     "2018",
+					Source:      "PPR",
+					JournalInfo: map[string]interface{}{"journal": map[string]interface{}{"title": "Journal B"}},
+				},
+			},
+		},
+	}
+
+	aggregations := calculateAggregations(pmcCore)
+
+	assert.EqualValues(t, []gin.H{
+		{"key": "2018", "doc_count": 1},
+		{"key": "2020", "doc_count": 2},
+	}, aggregations["pubYear"].(gin.H)["buckets"])
+	assert.EqualValues(t, []gin.H{
+		{"key": "Journal B", "doc_count": 2},
+		{"key": "Journal A", "doc_count": 1},
+	}, aggregations["journal"].(gin.H)["buckets"])
+	assert.EqualValues(t, []gin.H{
+		{"key": "Research articles", "doc_count": 1},
+		{"key": "Review articles", "doc_count": 1},
+		{"key": "Preprints", "doc_count": 1},
+		{"key": "Books and documents", "doc_count": 0},
+	}, aggregations["publicationType"].(gin.H)["buckets"])
+	assert.EqualValues(t, []gin.H{
+		{"key": "Open access", "doc_count": 1},
+		{"key": "Not open access", "doc_count": 2},
+	}, aggregations["openAccess"].(gin.H)["buckets"])
+}
+
+func TestCountPMCFacets(t *testing.T) {
+	mocks.GetDoFunc = func(req *http.Request) (*http.Response, error) {
+		// Return a different hit count for each facet query. The full query
+		// is filtered to preprints, so shares the preprint count.
+		query := req.URL.Query().Get("query")
+		hitCount := 100
+		if strings.Contains(query, "OPEN_ACCESS:y") {
+			hitCount = 2
+		} else if strings.Contains(query, "PUB_TYPE:REVIEW") {
+			hitCount = 10
+		} else if strings.Contains(query, "SRC:PPR") {
+			hitCount = 5
+		} else if strings.Contains(query, "HAS_BOOK:Y") {
+			hitCount = 1
+		} else if strings.Contains(query, "NOT") {
+			hitCount = 80
+		}
+		r := io.NopCloser(bytes.NewReader([]byte(fmt.Sprintf(`{"hitCount": %d}`, hitCount))))
+		return &http.Response{StatusCode: 200, Body: r}, nil
+	}
+
+	queries := []FieldQuery{{
+		QueryString: "asthma",
+		Field:       []string{"TITLE"},
+		Filters: map[string]map[string]interface{}{
+			"paper": {"publicationType": []interface{}{"Preprints"}},
+		},
+	}}
+	facets, total, err := countPMCFacets(context.Background(), queries)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 5, total)
+	assert.EqualValues(t, []gin.H{
+		{"key": "Research articles", "doc_count": 80},
+		{"key": "Review articles", "doc_count": 10},
+		{"key": "Preprints", "doc_count": 5},
+		{"key": "Books and documents", "doc_count": 1},
+	}, facets["publicationType"].(gin.H)["buckets"])
+	assert.EqualValues(t, []gin.H{
+		{"key": "Open access", "doc_count": 2},
+		{"key": "Not open access", "doc_count": 3},
+	}, facets["openAccess"].(gin.H)["buckets"])
+
+	// The publication type filter is not changed on the original query
+	assert.Contains(t, queries[0].Filters["paper"], "publicationType")
+}
@@ -1,14 +1,10 @@
 package search
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"strings"
-	"sync"
 
 	"github.com/gin-gonic/gin"
 )
@@ -28,6 +24,9 @@ ListFilters lists all the values available for the filter type and key pairs
 in the given FilterRequest.
 The `type` must match an existing elasticsearch index.
 The `keys` must match a field name in that index.
+The optional `order` sorts the values by "count" (the default) or "alphabetical".
+Date filters take an optional `interval` of "year" or "month" to also return a
+histogram of the documents over time.
 The expected structure of a FilterRequest is:
 
 ```
@@ -53,38 +52,40 @@ func ListFilters(c *gin.Context) {
 		slog.Warn(fmt.Sprintf("Could not bind filter request: %s", c.Request.Body))
 	}
 
-	type result struct {
-		index int
-		entry gin.H
+	// Filters whose type or keys are not recognised are left out, and the
+	// rest are requested in a single multi search.
+	requests := []multiSearchRequest{}
+	requested := []map[string]interface{}{}
+	for _, filter := range filterRequest.Filters {
+		index, ok := filterIndex(filter)
+		if !ok {
+			continue
+		}
+		requests = append(requests, multiSearchRequest{Index: index, Body: filtersRequest(filter)})
+		requested = append(requested, filter)
 	}
 
-	resultCh := make(chan result, len(filterRequest.Filters))
-	var wg sync.WaitGroup
-
-	for i, filter := range filterRequest.Filters {
-		wg.Add(1)
-		go func(i int, filter map[string]interface{}) {
-			defer wg.Done()
-			entry := queryFilter(filter)
-			if entry != nil {
-				resultCh <- result{index: i, entry: entry}
-			}
-		}(i, filter)
+	allFilters := []gin.H{}
+	if len(requests) == 0 {
+		c.JSON(http.StatusOK, gin.H{"filters": allFilters})
+		return
 	}
 
-	go func() {
-		wg.Wait()
-		close(resultCh)
-	}()
-
-	// Collect results preserving insertion order.
-	ordered := make([]gin.H, len(filterRequest.Filters))
-	for r := range resultCh {
-		ordered[r.index] = r.entry
+	responses, err := multiSearch(c.Request.Context(), requests)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to execute filters request: %s", err.Error()))
+		responses = make([]multiSearchResult, len(requests))
+		for i := range responses {
+			responses[i].Err = err
+		}
 	}
-	allFilters := []gin.H{}
-	for _, entry := range ordered {
-		if entry != nil {
+
+	for i, filter := range requested {
+		if responses[i].Err != nil {
+			allFilters = append(allFilters, filterErrorEntry(filter, responses[i].Err))
+			continue
+		}
+		if entry := filterEntry(filter, responses[i].Response); entry != nil {
 			allFilters = append(allFilters, entry)
 		}
 	}
@@ -92,64 +93,39 @@ func ListFilters(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"filters": allFilters})
 }
 
-// queryFilter executes a single filter aggregation query against Elastic and
-// returns the formatted gin.H entry for inclusion in the ListFilters response.
-// Extracting this into its own function ensures response.Body is closed after
-// each query rather than accumulating defers until ListFilters returns.
-func queryFilter(filter map[string]interface{}) gin.H {
+// filterIndex returns the index holding the entity type of a filter, and
+// false if the filter's type or keys are not recognised.
+func filterIndex(filter map[string]interface{}) (string, bool) {
 	filterType, ok := filter["type"].(string)
 	if !ok {
 		slog.Debug(fmt.Sprintf("Filter type in %v not recognised", filter))
-		return nil
+		return "", false
 	}
 
-	filterKey, ok := filter["keys"].(string)
-	if !ok {
+	if _, ok := filter["keys"].(string); !ok {
 		slog.Debug(fmt.Sprintf("Filter keys in %v not recognised", filter))
-		return nil
+		return "", false
 	}
 
-	var index string
 	if filterType == "dataUseRegister" || filterType == "dataProvider" {
-		index = strings.ToLower(filterType)
+		return strings.ToLower(filterType), true
 	} else if filterType == "paper" {
-		index = "publication"
-	} else {
-		index = filterType
-	}
-
-	var buf bytes.Buffer
-	elasticQuery := filtersRequest(filter)
-	if err := json.NewEncoder(&buf).Encode(elasticQuery); err != nil {
-		slog.Info(fmt.Sprintf("Failed to encode filters request: %s", err.Error()))
-	}
-
-	response, err := ElasticClient.Search(
-		ElasticClient.Search.WithIndex(index),
-		ElasticClient.Search.WithBody(&buf),
-	)
-	if err != nil {
-		slog.Warn(err.Error())
-		return nil
-	}
-	defer response.Body.Close()
-
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		slog.Warn(err.Error())
-		return nil
+		return "publication", true
 	}
+	return filterType, true
+}
 
-	var elasticResp SearchResponse
-	if err := json.Unmarshal(body, &elasticResp); err != nil {
-		slog.Warn(fmt.Sprintf("Failed to unmarshal filter response: %s", err.Error()))
-	}
+// filterEntry formats the response of a filter aggregation query as the
+// gin.H entry for inclusion in the ListFilters response.
+func filterEntry(filter map[string]interface{}, elasticResp SearchResponse) gin.H {
+	filterType, _ := filter["type"].(string)
+	filterKey, _ := filter["keys"].(string)
 
 	if len(elasticResp.Aggregations) == 0 {
 		slog.Warn(fmt.Sprintf("No aggregations returned for filter: %s - %s", filterType, filterKey))
 	}
 
-	if filterKey == "dateRange" || filterKey == "publicationDate" {
+	if _, ok := dateFacets[filterKey]; ok {
 		startAgg, ok := elasticResp.Aggregations["startDate"].(map[string]interface{})
 		if !ok {
 			slog.Warn(fmt.Sprintf("Unexpected startDate aggregation format for filter: %s - %s", filterType, filterKey))
@@ -160,14 +136,31 @@ func queryFilter(filter map[string]interface{}) gin.H {
 			slog.Warn(fmt.Sprintf("Unexpected endDate aggregation format for filter: %s - %s", filterType, filterKey))
 			return nil
 		}
+		dateEntry := gin.H{
+			"buckets": []gin.H{
+				{"key": "startDate", "value": startAgg["value_as_string"]},
+				{"key": "endDate", "value": endAgg["value_as_string"]},
+			},
+		}
+		if histogram, ok := elasticResp.Aggregations[filterKey].(map[string]interface{}); ok {
+			dateEntry["histogram"] = histogram["buckets"]
+		}
+		return gin.H{filterType: gin.H{filterKey: dateEntry}}
+	}
+
+	if facet, ok := numericFacets[filterKey]; ok {
 		return gin.H{
 			filterType: gin.H{
-				filterKey: gin.H{
-					"buckets": []gin.H{
-						{"key": "startDate", "value": startAgg["value_as_string"]},
-						{"key": "endDate", "value": endAgg["value_as_string"]},
-					},
-				},
+				filterKey: numericFacetResult(filterKey, facet, elasticResp.Aggregations),
+			},
+		}
+	}
+
+	if _, ok := hierarchicalFacets[filterKey]; ok {
+		hierarchyAgg, _ := elasticResp.Aggregations[filterKey].(map[string]interface{})
+		return gin.H{
+			filterType: gin.H{
+				filterKey: gin.H{"buckets": hierarchyTree(hierarchyAgg, "")},
 			},
 		}
 	}
@@ -175,56 +168,51 @@ func queryFilter(filter map[string]interface{}) gin.H {
 	return gin.H{filterType: elasticResp.Aggregations}
 }
 
+// filterErrorEntry reports the error of a failed filter aggregation query in
+// place of its entry in the ListFilters response.
+func filterErrorEntry(filter map[string]interface{}, err error) gin.H {
+	filterType, _ := filter["type"].(string)
+	filterKey, _ := filter["keys"].(string)
+	return gin.H{filterType: gin.H{filterKey: gin.H{"error": err.Error()}}}
+}
+
 func filtersRequest(filter map[string]interface{}) gin.H {
 	filterKey, ok := filter["keys"].(string)
 	var aggs gin.H
 	if !ok {
 		slog.Info(fmt.Sprintf("Filter key in %s not recognised", filter["keys"]))
 	}
-	if filterKey == "dateRange" {
+	if facet, ok := dateFacets[filterKey]; ok {
+		interval, _ := filter["interval"].(string)
 		aggs = gin.H{
 			"size": 0,
-			"aggs": gin.H{
-				"startDate": gin.H{
-					"min": gin.H{"field": "startDate"},
-				},
-				"endDate": gin.H{
-					"max": gin.H{"field": "endDate"},
-				},
-			},
+			"aggs": dateAggregations(filterKey, facet, interval),
+		}
+	} else if facet, ok := numericFacets[filterKey]; ok {
+		aggs = gin.H{
+			"size": 0,
+			"aggs": numericFacetAggs(filterKey, facet),
 		}
-	} else if filterKey == "publicationDate" {
+	} else if facet, ok := hierarchicalFacets[filterKey]; ok {
 		aggs = gin.H{
 			"size": 0,
 			"aggs": gin.H{
-				"startDate": gin.H{
-					"min": gin.H{"field": "publicationDate"},
-				},
-				"endDate": gin.H{
-					"max": gin.H{"field": "publicationDate"},
-				},
+				filterKey: hierarchyAggregation(filterKey, facet.Depth, 1000),
 			},
 		}
-	} else if filterKey == "populationSize" {
-		ranges := populationRangesCache
+	} else if facet, ok := geoFacets[filterKey]; ok {
 		aggs = gin.H{
 			"size": 0,
 			"aggs": gin.H{
-				"populationSize": gin.H{
-					"range": gin.H{"field": filterKey, "ranges": ranges},
-				},
+				filterKey: geoAggregation(facet, geoPrecision(filter)),
 			},
 		}
 	} else {
+		order, _ := filter["order"].(string)
 		aggs = gin.H{
 			"size": 0,
 			"aggs": gin.H{
-				filter["keys"].(string): gin.H{
-					"terms": gin.H{
-						"field": filter["keys"].(string),
-						"size":  1000,
-					},
-				},
+				filterKey: termsAggregation(filterKey, 1000, order),
 			},
 		}
 	}
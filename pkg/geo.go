@@ -0,0 +1,312 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// geoFacet describes a geographic filter over documents holding the areas
+// they cover in ShapeField and the centre of each area in PointField.
+type geoFacet struct {
+	ShapeField string
+	PointField string
+}
+
+// geoFacets maps the keys of geographic filters to their definitions.
+var geoFacets = map[string]geoFacet{
+	"geographicCoverage": {ShapeField: "geographicCoverage", PointField: "geographicCentre"},
+}
+
+// geographicCoveragePipeline is the ingest pipeline populating the geographic
+// coverage of datasets and data providers from their geographicLocation.
+const geographicCoveragePipeline = "geographic_coverage"
+
+// Relations between the areas covered by documents and the shape filtered.
+const (
+	geoIntersects = "intersects"
+	geoWithin     = "within"
+	geoContains   = "contains"
+)
+
+// Precision of the tiles of geotile_grid aggregations, with the whole world
+// in a single tile at precision 0.
+const (
+	defaultGeoPrecision = 6
+	maxGeoPrecision     = 29
+)
+
+// geoDistancePattern matches the distances of distance filters, e.g. 50km.
+var geoDistancePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(km|mi|m)$`)
+
+// place is the bounding box of a named area in the gazetteer, in degrees.
+type place struct {
+	West  float64
+	North float64
+	East  float64
+	South float64
+}
+
+// cityPlace returns a box of 0.1 degrees either side of the centre of a city.
+func cityPlace(lat float64, lon float64) place {
+	return place{West: lon - 0.1, North: lat + 0.1, East: lon + 0.1, South: lat - 0.1}
+}
+
+// envelope returns the shape of the place in elastic's envelope format, from
+// its top left corner to its bottom right corner as [lon, lat].
+func (p place) envelope() gin.H {
+	return gin.H{
+		"type":        "envelope",
+		"coordinates": [][]float64{{p.West, p.North}, {p.East, p.South}},
+	}
+}
+
+// centre returns the centre of the place as [lon, lat].
+func (p place) centre() []float64 {
+	return []float64{(p.West + p.East) / 2, (p.North + p.South) / 2}
+}
+
+// gazetteer maps the names of the UK, its nations, the regions of England and
+// its major cities to the areas they cover. The names match the values of
+// geographicLocation and the geographyParents.
+var gazetteer = map[string]place{
+	"United Kingdom":           {West: -8.65, North: 60.86, East: 1.77, South: 49.86},
+	"England":                  {West: -5.72, North: 55.81, East: 1.77, South: 49.86},
+	"Scotland":                 {West: -8.65, North: 60.86, East: -0.72, South: 54.63},
+	"Wales":                    {West: -5.35, North: 53.44, East: -2.65, South: 51.34},
+	"Northern Ireland":         {West: -8.18, North: 55.31, East: -5.43, South: 54.02},
+	"North East":               {West: -2.69, North: 55.81, East: -0.79, South: 54.45},
+	"North West":               {West: -3.64, North: 55.19, East: -1.91, South: 52.95},
+	"Yorkshire and The Humber": {West: -2.57, North: 54.56, East: 0.15, South: 53.30},
+	"East Midlands":            {West: -1.60, North: 53.62, East: 0.36, South: 51.98},
+	"West Midlands":            {West: -3.24, North: 53.23, East: -1.17, South: 51.83},
+	"East of England":          {West: -0.75, North: 53.00, East: 1.77, South: 51.45},
+	"London":                   {West: -0.51, North: 51.69, East: 0.34, South: 51.28},
+	"South East":               {West: -1.96, North: 52.20, East: 1.45, South: 50.57},
+	"South West":               {West: -5.72, North: 52.12, East: -1.49, South: 49.86},
+	"Belfast":                  cityPlace(54.60, -5.93),
+	"Birmingham":               cityPlace(52.49, -1.89),
+	"Bristol":                  cityPlace(51.45, -2.59),
+	"Cardiff":                  cityPlace(51.48, -3.18),
+	"Edinburgh":                cityPlace(55.95, -3.19),
+	"Glasgow":                  cityPlace(55.86, -4.25),
+	"Leeds":                    cityPlace(53.80, -1.55),
+	"Liverpool":                cityPlace(53.41, -2.98),
+	"Manchester":               cityPlace(53.48, -2.24),
+	"Newcastle upon Tyne":      cityPlace(54.98, -1.61),
+	"Nottingham":               cityPlace(52.95, -1.15),
+	"Sheffield":                cityPlace(53.38, -1.47),
+}
+
+// lookupPlace finds a place in the gazetteer, ignoring case.
+func lookupPlace(name string) (place, bool) {
+	if p, ok := gazetteer[name]; ok {
+		return p, true
+	}
+	for placeName, p := range gazetteer {
+		if strings.EqualFold(placeName, name) {
+			return p, true
+		}
+	}
+	return place{}, false
+}
+
+// geographicCoverageScript populates geographicCoverage with the envelope of
+// each geographicLocation found in the gazetteer, and geographicCentre with
+// their centres. Locations missing from the gazetteer are left out.
+const geographicCoverageScript = `
+List locations = ctx.geographicLocation == null ? [] :
+  (ctx.geographicLocation instanceof List ? ctx.geographicLocation : [ctx.geographicLocation]);
+
+List shapes = new ArrayList();
+List centres = new ArrayList();
+for (def location : locations) {
+  def place = params.places.get(location);
+  if (place != null) {
+    shapes.add(place.envelope);
+    centres.add(place.centre);
+  }
+}
+if (shapes.isEmpty()) {
+  ctx.remove('geographicCoverage');
+  ctx.remove('geographicCentre');
+} else {
+  ctx.geographicCoverage = shapes;
+  ctx.geographicCentre = centres;
+}
+`
+
+// geographicCoveragePipelineBody defines the ingest pipeline populating the
+// geographic coverage of documents from the gazetteer.
+func geographicCoveragePipelineBody() gin.H {
+	places := gin.H{}
+	for name, p := range gazetteer {
+		places[name] = gin.H{"envelope": p.envelope(), "centre": p.centre()}
+	}
+	return gin.H{
+		"description": "Populates the geographic coverage of documents from their geographic location",
+		"processors": []gin.H{
+			{"script": gin.H{
+				"lang":   "painless",
+				"source": geographicCoverageScript,
+				"params": gin.H{"places": places},
+			}},
+		},
+	}
+}
+
+// geoFilter builds the filter clause for a geographic filter, given as an
+// object of one of the forms
+//
+//	{"boundingBox": {"top": 55.8, "left": -3.6, "bottom": 53.3, "right": 0.2}}
+//	{"distance": "50km", "lat": 53.8, "lon": -1.55}
+//	{"distance": "50km", "near": "Leeds"}
+//	{"region": "Scotland"}
+//
+// where boundingBox and region also take a relation of intersects (default),
+// within or contains between the areas covered and the shape filtered.
+func geoFilter(facet geoFacet, terms interface{}) (gin.H, error) {
+	options, ok := terms.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("geographic filters must be an object")
+	}
+
+	if _, ok := options["distance"]; ok {
+		return geoDistanceFilter(facet, options)
+	}
+
+	var shape gin.H
+	if box, ok := options["boundingBox"]; ok {
+		p, err := boundingBox(box)
+		if err != nil {
+			return nil, err
+		}
+		shape = p.envelope()
+	} else if region, ok := options["region"]; ok {
+		name, _ := region.(string)
+		p, ok := lookupPlace(name)
+		if !ok {
+			return nil, fmt.Errorf("region %v not recognised", region)
+		}
+		shape = p.envelope()
+	} else {
+		return nil, errors.New("geographic filters take one of boundingBox, distance or region")
+	}
+
+	relation := geoIntersects
+	if value, ok := options["relation"]; ok {
+		relation, _ = value.(string)
+		if relation != geoIntersects && relation != geoWithin && relation != geoContains {
+			return nil, fmt.Errorf("relation must be one of %s, %s or %s", geoIntersects, geoWithin, geoContains)
+		}
+	}
+	return gin.H{
+		"geo_shape": gin.H{
+			facet.ShapeField: gin.H{"shape": shape, "relation": relation},
+		},
+	}, nil
+}
+
+// geoDistanceFilter builds the filter clause matching documents covering an
+// area within the distance of a point, or of the centre of a named place.
+func geoDistanceFilter(facet geoFacet, options map[string]interface{}) (gin.H, error) {
+	distance, _ := options["distance"].(string)
+	if !geoDistancePattern.MatchString(distance) {
+		return nil, errors.New("distance must be a number of km, mi or m, e.g. 50km")
+	}
+
+	var point []float64
+	if near, ok := options["near"]; ok {
+		name, _ := near.(string)
+		p, ok := lookupPlace(name)
+		if !ok {
+			return nil, fmt.Errorf("place %v not recognised", near)
+		}
+		point = p.centre()
+	} else {
+		lat, latOk := options["lat"].(float64)
+		lon, lonOk := options["lon"].(float64)
+		if !latOk || !lonOk {
+			return nil, errors.New("distance filters take lat and lon, or near")
+		}
+		if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			return nil, errors.New("lat must be within ±90 and lon within ±180")
+		}
+		point = []float64{lon, lat}
+	}
+	return gin.H{
+		"geo_distance": gin.H{
+			"distance":       distance,
+			facet.ShapeField: point,
+		},
+	}, nil
+}
+
+// boundingBox reads the top, left, bottom and right of a bounding box.
+func boundingBox(box interface{}) (place, error) {
+	corners, ok := box.(map[string]interface{})
+	if !ok {
+		return place{}, errors.New("boundingBox must be an object of top, left, bottom and right")
+	}
+	var p place
+	for key, value := range map[string]*float64{
+		"top":    &p.North,
+		"left":   &p.West,
+		"bottom": &p.South,
+		"right":  &p.East,
+	} {
+		v, ok := corners[key].(float64)
+		if !ok {
+			return place{}, fmt.Errorf("boundingBox must have a numeric %s", key)
+		}
+		*value = v
+	}
+	if p.North < p.South {
+		return place{}, errors.New("boundingBox top must not be below bottom")
+	}
+	return p, nil
+}
+
+// geoAggregation defines a geotile_grid aggregation of the centres of the
+// areas covered by documents, for clustering them on a map. Each tile holds
+// the centroid of its documents, so that clusters can be placed where the
+// documents are rather than at the centre of the tile.
+func geoAggregation(facet geoFacet, precision int) gin.H {
+	return gin.H{
+		"geotile_grid": gin.H{
+			"field":     facet.PointField,
+			"precision": precision,
+		},
+		"aggs": gin.H{
+			"centroid": gin.H{"geo_centroid": gin.H{"field": facet.PointField}},
+		},
+	}
+}
+
+// geoPrecision reads the precision of a geographic aggregation, defaulting to
+// defaultGeoPrecision.
+func geoPrecision(agg map[string]interface{}) int {
+	if precision, ok := agg["precision"].(float64); ok {
+		return int(precision)
+	}
+	return defaultGeoPrecision
+}
+
+// validateGeoAggregations checks the precision of each geographic aggregation
+// in a Query.
+func validateGeoAggregations(aggregations []map[string]interface{}) error {
+	for _, agg := range aggregations {
+		value, ok := agg["precision"]
+		if !ok {
+			continue
+		}
+		precision, ok := value.(float64)
+		if !ok || precision != float64(int(precision)) || precision < 0 || precision > maxGeoPrecision {
+			return fmt.Errorf("precision %v must be a whole number from 0 to %d", value, maxGeoPrecision)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeoFilterRegion(t *testing.T) {
+	filter, err := geoFilter(geoFacets["geographicCoverage"], map[string]interface{}{"region": "scotland"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, gin.H{
+		"geo_shape": gin.H{
+			"geographicCoverage": gin.H{
+				"shape": gin.H{
+					"type":        "envelope",
+					"coordinates": [][]float64{{-8.65, 60.86}, {-0.72, 54.63}},
+				},
+				"relation": geoIntersects,
+			},
+		},
+	}, filter)
+
+	_, err = geoFilter(geoFacets["geographicCoverage"], map[string]interface{}{"region": "Atlantis"})
+	assert.NotNil(t, err)
+}
+
+func TestGeoFilterBoundingBox(t *testing.T) {
+	filter, err := geoFilter(geoFacets["geographicCoverage"], map[string]interface{}{
+		"boundingBox": map[string]interface{}{"top": 55.0, "left": -3.0, "bottom": 53.0, "right": 0.0},
+		"relation":    "within",
+	})
+	assert.Nil(t, err)
+	shape := filter["geo_shape"].(gin.H)["geographicCoverage"].(gin.H)
+	assert.EqualValues(t, [][]float64{{-3.0, 55.0}, {0.0, 53.0}}, shape["shape"].(gin.H)["coordinates"])
+	assert.EqualValues(t, geoWithin, shape["relation"])
+
+	_, err = geoFilter(geoFacets["geographicCoverage"], map[string]interface{}{
+		"boundingBox": map[string]interface{}{"top": 53.0, "left": -3.0, "bottom": 55.0, "right": 0.0},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestGeoFilterDistance(t *testing.T) {
+	filter, err := geoFilter(geoFacets["geographicCoverage"], map[string]interface{}{
+		"distance": "50km", "lat": 53.8, "lon": -1.55,
+	})
+	assert.Nil(t, err)
+	assert.EqualValues(t, gin.H{
+		"geo_distance": gin.H{
+			"distance":           "50km",
+			"geographicCoverage": []float64{-1.55, 53.8},
+		},
+	}, filter)
+
+	filter, err = geoFilter(geoFacets["geographicCoverage"], map[string]interface{}{
+		"distance": "10mi", "near": "Leeds",
+	})
+	assert.Nil(t, err)
+	assert.InDeltaSlice(t, []float64{-1.55, 53.8}, filter["geo_distance"].(gin.H)["geographicCoverage"], 1e-9)
+
+	_, err = geoFilter(geoFacets["geographicCoverage"], map[string]interface{}{"distance": "far", "near": "Leeds"})
+	assert.NotNil(t, err)
+	_, err = geoFilter(geoFacets["geographicCoverage"], map[string]interface{}{"distance": "5km", "lat": 95.0, "lon": 0.0})
+	assert.NotNil(t, err)
+}
+
+func TestBuildAggregationsGeo(t *testing.T) {
+	query := Query{
+		Aggregations: []map[string]interface{}{
+			{"type": "dataset", "keys": "geographicCoverage", "precision": 4.0},
+		},
+	}
+	aggs := buildAggregations(query, map[string]gin.H{})
+	inner := aggs["geographicCoverage"].(gin.H)["aggs"].(gin.H)
+	assert.EqualValues(t, gin.H{
+		"geotile_grid": gin.H{"field": "geographicCentre", "precision": 4},
+		"aggs": gin.H{
+			"centroid": gin.H{"geo_centroid": gin.H{"field": "geographicCentre"}},
+		},
+	}, inner["geographicCoverage"])
+}
+
+func TestValidateQueryGeo(t *testing.T) {
+	query := Query{
+		Filters: map[string]map[string]interface{}{
+			"dataset": {"geographicCoverage": map[string]interface{}{"region": "Atlantis"}},
+		},
+	}
+	assert.NotNil(t, validateQuery(query, "datasets"))
+
+	query = Query{
+		Aggregations: []map[string]interface{}{
+			{"type": "dataset", "keys": "geographicCoverage", "precision": 30.0},
+		},
+	}
+	assert.NotNil(t, validateQuery(query, "datasets"))
+}
+
+func TestGeographicCoveragePipelineBody(t *testing.T) {
+	pipeline := geographicCoveragePipelineBody()
+	script := pipeline["processors"].([]gin.H)[0]["script"].(gin.H)
+	places := script["params"].(gin.H)["places"].(gin.H)
+	assert.Len(t, places, len(gazetteer))
+	for name := range geographyParents {
+		assert.Contains(t, places, name)
+	}
+}
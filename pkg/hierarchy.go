@@ -0,0 +1,206 @@
+package search
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// facetPathSeparator separates the levels in the values of a hierarchical
+// facet, e.g. "United Kingdom > England > London".
+const facetPathSeparator = " > "
+
+// hierarchicalFacet describes a keyword field holding the path of each node
+// in a tree of values. Every ancestor of a node is stored with it, so that
+// filtering on a path selects the whole subtree.
+type hierarchicalFacet struct {
+	// Depth is the number of levels in the tree.
+	Depth int
+}
+
+// hierarchicalFacets maps the path fields of the dataset index, populated by
+// the datasetFacetPathsPipeline, to their definitions.
+var hierarchicalFacets = map[string]hierarchicalFacet{
+	"dataTypePath":           {Depth: 2},
+	"geographicLocationPath": {Depth: 3},
+}
+
+// datasetFacetPathsPipeline is the ingest pipeline run on every dataset
+// indexed, set as the default pipeline of the dataset index.
+const datasetFacetPathsPipeline = "dataset_facet_paths"
+
+// geographyParents places the geographic locations of datasets in a tree,
+// mapping each location to the one containing it. Locations missing from the
+// table are placed at the top of the tree.
+var geographyParents = map[string]string{
+	"England":                  "United Kingdom",
+	"Scotland":                 "United Kingdom",
+	"Wales":                    "United Kingdom",
+	"Northern Ireland":         "United Kingdom",
+	"North East":               "England",
+	"North West":               "England",
+	"Yorkshire and The Humber": "England",
+	"East Midlands":            "England",
+	"West Midlands":            "England",
+	"East of England":          "England",
+	"London":                   "England",
+	"South East":               "England",
+	"South West":               "England",
+}
+
+// dataSubTypeParents maps each dataSubType of datasets to the dataType it
+// belongs to, so that a dataset of several types places each sub type under
+// its own type only. Sub types missing from the table, such as "Others" which
+// appears under many types, are placed under the dataType of datasets with a
+// single type and left out otherwise.
+var dataSubTypeParents = map[string]string{
+	"Cancer":                  "Health and disease",
+	"Cardiovascular":          "Health and disease",
+	"Mental health":           "Health and disease",
+	"Metabolic and endocrine": "Health and disease",
+	"Neurological":            "Health and disease",
+	"Rare diseases":           "Health and disease",
+	"Respiratory":             "Health and disease",
+	"Vaccines":                "Treatments/Interventions",
+	"Medicines":               "Treatments/Interventions",
+	"Laboratory":              "Measurements/Tests",
+	"Physiological":           "Measurements/Tests",
+	"CT":                      "Imaging types",
+	"MRI":                     "Imaging types",
+	"PET":                     "Imaging types",
+	"X-ray":                   "Imaging types",
+	"Ultrasound":              "Imaging types",
+	"Genomics":                "Omics",
+	"Proteomics":              "Omics",
+	"Transcriptomics":         "Omics",
+	"Metabolomics":            "Omics",
+	"Education":               "Socioeconomic",
+	"Employment":              "Socioeconomic",
+	"Income":                  "Socioeconomic",
+	"Housing":                 "Socioeconomic",
+	"Smoking":                 "Lifestyle",
+	"Physical activity":       "Lifestyle",
+	"Diet":                    "Lifestyle",
+	"Alcohol":                 "Lifestyle",
+	"Disease registry":        "Registry",
+	"Births and deaths":       "Registry",
+}
+
+// facetPathsScript populates dataTypePath with each dataType and each
+// dataSubType beneath its own type through dataSubTypeParents, and
+// geographicLocationPath with the path of each geographicLocation through
+// geographyParents, including every ancestor.
+const facetPathsScript = `
+List asList(def value) {
+  if (value == null) { return []; }
+  if (value instanceof List) { return value; }
+  return [value];
+}
+
+List dataTypes = asList(ctx.dataType);
+Set typePaths = new LinkedHashSet(dataTypes);
+for (def subType : asList(ctx.dataSubType)) {
+  def dataType = params.subTypeParents.get(subType);
+  if (dataType == null && dataTypes.size() == 1) {
+    dataType = dataTypes.get(0);
+  }
+  if (dataType != null && dataTypes.contains(dataType)) {
+    typePaths.add(dataType + params.separator + subType);
+  }
+}
+ctx.dataTypePath = new ArrayList(typePaths);
+
+Set locationPaths = new LinkedHashSet();
+for (def location : asList(ctx.geographicLocation)) {
+  String path = location;
+  def parent = params.parents.get(location);
+  while (parent != null) {
+    path = parent + params.separator + path;
+    parent = params.parents.get(parent);
+  }
+  String prefix = null;
+  for (String part : path.splitOnToken(params.separator)) {
+    prefix = prefix == null ? part : prefix + params.separator + part;
+    locationPaths.add(prefix);
+  }
+}
+ctx.geographicLocationPath = new ArrayList(locationPaths);
+`
+
+// datasetFacetPathsPipelineBody defines the ingest pipeline populating the
+// hierarchical facets of datasets, which then runs the geographic coverage
+// and indexed at pipelines.
+func datasetFacetPathsPipelineBody() gin.H {
+	return gin.H{
+		"description": "Populates the hierarchical facet paths of datasets",
+		"processors": []gin.H{
+			{"script": gin.H{
+				"lang":   "painless",
+				"source": facetPathsScript,
+				"params": gin.H{
+					"separator":      facetPathSeparator,
+					"parents":        geographyParents,
+					"subTypeParents": dataSubTypeParents,
+				},
+			}},
+			{"pipeline": gin.H{"name": geographicCoveragePipeline}},
+			{"pipeline": gin.H{"name": indexedAtPipeline}},
+		},
+	}
+}
+
+// hierarchyAggregation defines nested terms aggregations over the path field,
+// one for each level of the tree, with each level's buckets in the children
+// aggregation of the level above.
+func hierarchyAggregation(field string, depth int, size int) gin.H {
+	var agg gin.H
+	for level := depth; level >= 1; level-- {
+		levelAgg := gin.H{"terms": gin.H{
+			"field":   field,
+			"size":    size,
+			"include": hierarchyLevelPattern(level),
+		}}
+		if agg != nil {
+			levelAgg["aggs"] = gin.H{"children": agg}
+		}
+		agg = levelAgg
+	}
+	return agg
+}
+
+// hierarchyLevelPattern matches the paths of the nodes at level of a tree,
+// with the top of the tree at level 1.
+func hierarchyLevelPattern(level int) string {
+	node := `[^\>]+`
+	return node + strings.Repeat(` \> `+node, level-1)
+}
+
+// hierarchyTree converts the buckets of a hierarchyAggregation into a tree of
+// nodes, each with the key of its own level, its full path, its document
+// count and its children. A document in several subtrees brings paths from
+// each into the children of every one, so children not beneath parent are
+// removed.
+func hierarchyTree(agg map[string]interface{}, parent string) []gin.H {
+	nodes := []gin.H{}
+	buckets, _ := agg["buckets"].([]interface{})
+	for _, b := range buckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path, _ := bucket["key"].(string)
+		if parent != "" && !strings.HasPrefix(path, parent+facetPathSeparator) {
+			continue
+		}
+
+		levels := strings.Split(path, facetPathSeparator)
+		children, _ := bucket["children"].(map[string]interface{})
+		nodes = append(nodes, gin.H{
+			"key":       levels[len(levels)-1],
+			"path":      path,
+			"doc_count": bucket["doc_count"],
+			"children":  hierarchyTree(children, path),
+		})
+	}
+	return nodes
+}
@@ -0,0 +1,76 @@
+package search
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHierarchyAggregation(t *testing.T) {
+	agg := hierarchyAggregation("dataTypePath", 2, 10)
+
+	assert.EqualValues(t, gin.H{
+		"terms": gin.H{"field": "dataTypePath", "size": 10, "include": `[^\>]+`},
+		"aggs": gin.H{
+			"children": gin.H{
+				"terms": gin.H{"field": "dataTypePath", "size": 10, "include": `[^\>]+ \> [^\>]+`},
+			},
+		},
+	}, agg)
+}
+
+func TestHierarchyTree(t *testing.T) {
+	var agg map[string]interface{}
+	json.Unmarshal([]byte(`{"buckets": [
+		{"key": "Health and disease", "doc_count": 5, "children": {"buckets": [
+			{"key": "Health and disease > Cancer", "doc_count": 3},
+			{"key": "Imaging > MRI", "doc_count": 1}
+		]}},
+		{"key": "Imaging", "doc_count": 1, "children": {"buckets": [
+			{"key": "Imaging > MRI", "doc_count": 1}
+		]}}
+	]}`), &agg)
+
+	tree := hierarchyTree(agg, "")
+
+	assert.Len(t, tree, 2)
+	assert.EqualValues(t, "Health and disease", tree[0]["key"])
+	children := tree[0]["children"].([]gin.H)
+	assert.Len(t, children, 1)
+	assert.EqualValues(t, "Cancer", children[0]["key"])
+	assert.EqualValues(t, "Health and disease > Cancer", children[0]["path"])
+	assert.EqualValues(t, 3, children[0]["doc_count"])
+	assert.EqualValues(t, []gin.H{}, children[0]["children"])
+}
+
+func TestFlattenAggsHierarchy(t *testing.T) {
+	var elasticResp SearchResponse
+	json.Unmarshal([]byte(`{"aggregations": {
+		"geographicLocationPath": {"doc_count": 2, "geographicLocationPath": {"buckets": [
+			{"key": "United Kingdom", "doc_count": 2, "children": {"buckets": []}}
+		]}}
+	}}`), &elasticResp)
+
+	aggs := flattenAggs(elasticResp)
+
+	buckets := aggs["geographicLocationPath"].(gin.H)["buckets"].([]gin.H)
+	assert.Len(t, buckets, 1)
+	assert.EqualValues(t, "United Kingdom", buckets[0]["path"])
+}
+
+func TestDatasetFacetPathsPipelineBody(t *testing.T) {
+	script := datasetFacetPathsPipelineBody()["processors"].([]gin.H)[0]["script"].(gin.H)
+	params := script["params"].(gin.H)
+	assert.EqualValues(t, facetPathSeparator, params["separator"])
+	assert.EqualValues(t, "England", params["parents"].(map[string]string)["London"])
+
+	// A dataset of several types places each sub type under its own type.
+	subTypeParents := params["subTypeParents"].(map[string]string)
+	assert.EqualValues(t, "Health and disease", subTypeParents["Cancer"])
+	assert.EqualValues(t, "Imaging types", subTypeParents["MRI"])
+	assert.NotContains(t, subTypeParents, "Others")
+	assert.Contains(t, script["source"], "params.subTypeParents.get(subType)")
+	assert.NotContains(t, script["source"], "for (def dataType : asList(ctx.dataType))")
+}
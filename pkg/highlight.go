@@ -0,0 +1,99 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HighlightOptions represents the highlighting requested with a search. All
+// fields are optional:
+//   - Fields are the fields to highlight, defaulting to the entity's
+//     HighlightFields. Each must be one of the entity's TextFields.
+//   - FragmentSize is the length in characters of each snippet, with 0
+//     returning the whole text of the field.
+//   - NumberOfFragments is the most snippets returned for each field.
+//   - PreTags and PostTags wrap the matched terms, defaulting to <em></em>.
+//   - NoMatchSize is the length of the snippet taken from the start of a
+//     field when it has no matches, with 0 returning nothing.
+type HighlightOptions struct {
+	Fields            []string `json:"fields"`
+	FragmentSize      *int     `json:"fragmentSize"`
+	NumberOfFragments *int     `json:"numberOfFragments"`
+	PreTags           []string `json:"preTags"`
+	PostTags          []string `json:"postTags"`
+	NoMatchSize       *int     `json:"noMatchSize"`
+}
+
+// validateHighlight checks the highlight options of a search of the given
+// entities. Requested fields must be a text field of at least one of them.
+func validateHighlight(options HighlightOptions, entities ...searchEntity) error {
+	if options.FragmentSize != nil && *options.FragmentSize < 0 {
+		return errors.New("highlight fragmentSize must not be negative")
+	}
+	if options.NumberOfFragments != nil && *options.NumberOfFragments < 0 {
+		return errors.New("highlight numberOfFragments must not be negative")
+	}
+	if options.NoMatchSize != nil && *options.NoMatchSize < 0 {
+		return errors.New("highlight noMatchSize must not be negative")
+	}
+	if len(options.PreTags) != len(options.PostTags) {
+		return errors.New("highlight preTags and postTags must be the same length")
+	}
+	for _, field := range options.Fields {
+		found := false
+		for _, entity := range entities {
+			if slices.Contains(entity.TextFields, field) {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s cannot be highlighted", field)
+		}
+	}
+	return nil
+}
+
+// highlightConfig defines the highlight section of a search of entity.
+// Without options the whole text of each of the entity's HighlightFields is
+// returned when it matches. Requested fields the entity does not hold are
+// ignored, so a search of every entity can ask for fields of any of them.
+func highlightConfig(entity searchEntity, options HighlightOptions) gin.H {
+	fields := []string{}
+	for _, field := range options.Fields {
+		if slices.Contains(entity.TextFields, field) {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		fields = entity.HighlightFields
+	}
+
+	fieldConfig := gin.H{
+		"boundary_scanner": "sentence",
+		"fragment_size":    0,
+		"no_match_size":    0,
+	}
+	if options.FragmentSize != nil {
+		fieldConfig["fragment_size"] = *options.FragmentSize
+	}
+	if options.NumberOfFragments != nil {
+		fieldConfig["number_of_fragments"] = *options.NumberOfFragments
+	}
+	if options.NoMatchSize != nil {
+		fieldConfig["no_match_size"] = *options.NoMatchSize
+	}
+
+	highlightFields := gin.H{}
+	for _, field := range fields {
+		highlightFields[field] = fieldConfig
+	}
+	highlight := gin.H{"fields": highlightFields}
+	if len(options.PreTags) > 0 {
+		highlight["pre_tags"] = options.PreTags
+		highlight["post_tags"] = options.PostTags
+	}
+	return highlight
+}
@@ -0,0 +1,71 @@
+package search
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHighlightConfigDefaults(t *testing.T) {
+	highlight := highlightConfig(searchEntities["tools"], HighlightOptions{})
+
+	fieldConfig := gin.H{"boundary_scanner": "sentence", "fragment_size": 0, "no_match_size": 0}
+	assert.EqualValues(t, gin.H{
+		"fields": gin.H{"name": fieldConfig, "description": fieldConfig},
+	}, highlight)
+}
+
+func TestHighlightConfigOptions(t *testing.T) {
+	fragmentSize, fragments, noMatchSize := 150, 3, 100
+	options := HighlightOptions{
+		Fields:            []string{"abstract", "projectTitle"},
+		FragmentSize:      &fragmentSize,
+		NumberOfFragments: &fragments,
+		PreTags:           []string{"<mark>"},
+		PostTags:          []string{"</mark>"},
+		NoMatchSize:       &noMatchSize,
+	}
+
+	highlight := highlightConfig(searchEntities["datasets"], options)
+
+	assert.EqualValues(t, gin.H{
+		"fields": gin.H{
+			"abstract": gin.H{
+				"boundary_scanner":    "sentence",
+				"fragment_size":       150,
+				"number_of_fragments": 3,
+				"no_match_size":       100,
+			},
+		},
+		"pre_tags":  []string{"<mark>"},
+		"post_tags": []string{"</mark>"},
+	}, highlight)
+}
+
+func TestValidateHighlight(t *testing.T) {
+	negative := -1
+	assert.Nil(t, validateHighlight(HighlightOptions{Fields: []string{"laySummary"}}, searchEntities["dur"]))
+	assert.NotNil(t, validateHighlight(HighlightOptions{Fields: []string{"laySummary"}}, searchEntities["tools"]))
+	assert.NotNil(t, validateHighlight(HighlightOptions{FragmentSize: &negative}))
+	assert.NotNil(t, validateHighlight(HighlightOptions{PreTags: []string{"<mark>"}}))
+
+	assert.Nil(t, validateQuery(Query{Highlight: HighlightOptions{Fields: []string{"laySummary"}}}, ""))
+	assert.NotNil(t, validateQuery(Query{Highlight: HighlightOptions{Fields: []string{"laySummary"}}}, "tools"))
+}
+
+func TestToolSearchInvalidHighlight(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Body = io.NopCloser(bytes.NewBufferString(`{"query": "asthma", "highlight": {"fields": ["license"]}}`))
+
+	ToolSearch(c)
+
+	assert.EqualValues(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,472 @@
+package search
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// LiteratureProvider is a source of federated paper search results, such as
+// EuropePMC or Crossref. Providers return papers in the normalised Paper
+// format so that results from several providers can be combined.
+type LiteratureProvider interface {
+	// Name is the identifier used to select the provider in requests.
+	Name() string
+	// SearchDOI returns the papers with the given DOI, taken from
+	// query.QueryString, which may include a resolver prefix.
+	SearchDOI(ctx context.Context, query Query) (LiteratureResult, error)
+	// SearchFields returns a page of papers matching the query string in the
+	// given fields, using EuropePMC field names (e.g. "TITLE", "ABSTRACT").
+	SearchFields(ctx context.Context, query FieldQuery) (LiteratureResult, error)
+}
+
+// Paper is the provider independent representation of a federated paper
+// search result. PublicationTypes uses the gateway publication types (e.g.
+// "Research articles", "Preprints").
+type Paper struct {
+	Provider           string              `json:"provider"`
+	Providers          []string            `json:"providers"`
+	ID                 string              `json:"id"`
+	DOI                string              `json:"doi"`
+	PMID               string              `json:"pmid,omitempty"`
+	Title              string              `json:"title"`
+	Authors            []string            `json:"authors"`
+	Journal            string              `json:"journal"`
+	PubYear            string              `json:"pubYear"`
+	Abstract           string              `json:"abstract"`
+	PublicationTypes   []string            `json:"publicationTypes"`
+	URL                string              `json:"url"`
+	OpenAccess         bool                `json:"openAccess"`
+	GatewayPublication *GatewayPublication `json:"gatewayPublication,omitempty"`
+}
+
+// LiteratureResult is a page of results from a single provider.
+type LiteratureResult struct {
+	HitCount       int
+	NextCursorMark string
+	Papers         []Paper
+}
+
+// LiteratureProviderStatus summarises the results from one provider of a
+// federated search. Error is set if the provider could not be searched.
+type LiteratureProviderStatus struct {
+	HitCount       int    `json:"hitCount"`
+	NextCursorMark string `json:"nextCursorMark,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// LiteratureResponse is returned by federated paper searches which select
+// providers. HitCount is the number of distinct papers returned, with the
+// total available from each provider given in Providers. NextCursorMark is
+// the cursor of the next page of a field search, combining the cursor of
+// each provider when several are searched.
+type LiteratureResponse struct {
+	HitCount       int                                 `json:"hitCount"`
+	NextCursorMark string                              `json:"nextCursorMark,omitempty"`
+	Papers         []Paper                             `json:"papers"`
+	Providers      map[string]LiteratureProviderStatus `json:"providers"`
+}
+
+const (
+	defaultCrossrefURL = "https://api.crossref.org"
+	defaultOpenAlexURL = "https://api.openalex.org"
+)
+
+var literatureProviders map[string]LiteratureProvider
+
+func init() {
+	literatureProviders = newLiteratureProviders(defaultPMCConfig(), defaultCrossrefURL, defaultOpenAlexURL, "")
+}
+
+// InitLiteratureProviders configures the federated literature providers from
+// environment variables. Crossref and OpenAlex share the timeout and retry
+// settings of the EuropePMC client, but each has its own circuit breaker.
+// Must be called after godotenv.Load() in main so that env vars are available.
+func InitLiteratureProviders() {
+	crossrefURL := os.Getenv("CROSSREF_URL")
+	if crossrefURL == "" {
+		crossrefURL = defaultCrossrefURL
+	}
+	openAlexURL := os.Getenv("OPENALEX_URL")
+	if openAlexURL == "" {
+		openAlexURL = defaultOpenAlexURL
+	}
+	literatureProviders = newLiteratureProviders(pmcConfigFromEnv(), crossrefURL, openAlexURL, os.Getenv("LITERATURE_MAILTO"))
+}
+
+func newLiteratureProviders(config PMCConfig, crossrefURL string, openAlexURL string, mailto string) map[string]LiteratureProvider {
+	providers := []LiteratureProvider{
+		europePMCProvider{},
+		NewCrossrefProvider(NewResilientClient(&http.Client{Timeout: config.Timeout}, config), crossrefURL, mailto),
+		NewOpenAlexProvider(NewResilientClient(&http.Client{Timeout: config.Timeout}, config), openAlexURL, mailto),
+	}
+	byName := make(map[string]LiteratureProvider)
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
+	return byName
+}
+
+// selectLiteratureProviders returns the providers with the given names, in
+// the order given.
+func selectLiteratureProviders(names []string) ([]LiteratureProvider, error) {
+	providers := []LiteratureProvider{}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		provider, ok := literatureProviders[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown literature provider %q", name)
+		}
+		if seen[provider.Name()] {
+			continue
+		}
+		seen[provider.Name()] = true
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// searchLiterature runs search against each of the providers concurrently
+// and combines their results, with papers found by several providers
+// returned once. An error is only returned if every provider fails; otherwise
+// the failures are reported in the status of each provider.
+func searchLiterature(
+	ctx context.Context,
+	providers []LiteratureProvider,
+	search func(ctx context.Context, provider LiteratureProvider) (LiteratureResult, error),
+) (LiteratureResponse, error) {
+	results := make([]LiteratureResult, len(providers))
+	errs := make([]error, len(providers))
+	var wg sync.WaitGroup
+	for i, provider := range providers {
+		wg.Add(1)
+		go func(i int, provider LiteratureProvider) {
+			defer wg.Done()
+			results[i], errs[i] = search(ctx, provider)
+		}(i, provider)
+	}
+	wg.Wait()
+
+	response := LiteratureResponse{
+		Papers:    []Paper{},
+		Providers: make(map[string]LiteratureProviderStatus),
+	}
+	failed := 0
+	pages := [][]Paper{}
+	for i, provider := range providers {
+		if errs[i] != nil {
+			slog.Warn(fmt.Sprintf("Failed to search %s with: %s", provider.Name(), errs[i].Error()))
+			response.Providers[provider.Name()] = LiteratureProviderStatus{Error: errs[i].Error()}
+			failed++
+			continue
+		}
+		response.Providers[provider.Name()] = LiteratureProviderStatus{
+			HitCount:       results[i].HitCount,
+			NextCursorMark: results[i].NextCursorMark,
+		}
+		pages = append(pages, results[i].Papers)
+	}
+	if failed == len(providers) && failed > 0 {
+		return response, errors.Join(errs...)
+	}
+
+	if len(pages) > 0 {
+		response.Papers = dedupePapers(shuffleArrays(pages))
+	}
+	annotatePapers(ctx, response.Papers)
+	response.HitCount = len(response.Papers)
+	return response, nil
+}
+
+// searchLiteratureFields runs a field search against each of the providers
+// from its position in cursors, as decoded by decodeLiteratureCursor.
+// Providers missing from cursors have no more results and are not searched.
+// The cursor of the following page is returned as the NextCursorMark of the
+// response, with a provider which fails kept at its current position so that
+// it is retried.
+func searchLiteratureFields(
+	ctx context.Context,
+	providers []LiteratureProvider,
+	query FieldQuery,
+	cursors map[string]string,
+) (LiteratureResponse, error) {
+	var mu sync.Mutex
+	next := make(map[string]string)
+	response, err := searchLiterature(ctx, providers, func(ctx context.Context, provider LiteratureProvider) (LiteratureResult, error) {
+		cursor, ok := cursors[provider.Name()]
+		if !ok {
+			return LiteratureResult{Papers: []Paper{}}, nil
+		}
+		providerQuery := query
+		providerQuery.CursorMark = cursor
+		result, err := provider.SearchFields(ctx, providerQuery)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			next[provider.Name()] = cursor
+		} else if len(result.Papers) > 0 && result.NextCursorMark != "" && result.NextCursorMark != cursor {
+			next[provider.Name()] = result.NextCursorMark
+		}
+		return result, err
+	})
+	response.NextCursorMark = encodeLiteratureCursor(providers, next)
+	return response, err
+}
+
+// literatureCursor is the combined cursor of a field search of several
+// literature providers. Cursors holds the cursor of each provider which has
+// more results, by name, and Providers the names of every provider searched,
+// so that the cursor is not applied to a different selection.
+type literatureCursor struct {
+	Providers []string          `json:"providers"`
+	Cursors   map[string]string `json:"cursors"`
+}
+
+// decodeLiteratureCursor decodes the cursor of a field search into the cursor
+// of each provider, by name. An empty cursor starts every provider from the
+// first page. When a single provider is searched its own cursor is used as
+// is; otherwise the cursor must be one returned by encodeLiteratureCursor for
+// the same providers.
+func decodeLiteratureCursor(cursor string, providers []LiteratureProvider) (map[string]string, error) {
+	cursors := make(map[string]string)
+	if cursor == "" || cursor == initialCursorMark {
+		for _, provider := range providers {
+			cursors[provider.Name()] = initialCursorMark
+		}
+		return cursors, nil
+	}
+	if len(providers) == 1 {
+		cursors[providers[0].Name()] = cursor
+		return cursors, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursorMark: %w", err)
+	}
+	var combined literatureCursor
+	if err := json.Unmarshal(decoded, &combined); err != nil {
+		return nil, fmt.Errorf("invalid cursorMark: %w", err)
+	}
+	if !slices.Equal(combined.Providers, providerNames(providers)) {
+		return nil, errors.New("cursorMark was returned for different providers")
+	}
+	for name, providerCursor := range combined.Cursors {
+		if slices.Contains(combined.Providers, name) {
+			cursors[name] = providerCursor
+		}
+	}
+	return cursors, nil
+}
+
+// encodeLiteratureCursor encodes the cursor of each provider with more results
+// into a single opaque cursor, or returns the provider's own cursor when a
+// single provider is searched. An empty string is returned once every
+// provider's results have been seen.
+func encodeLiteratureCursor(providers []LiteratureProvider, cursors map[string]string) string {
+	if len(cursors) == 0 {
+		return ""
+	}
+	if len(providers) == 1 {
+		return cursors[providers[0].Name()]
+	}
+	encoded, err := json.Marshal(literatureCursor{
+		Providers: providerNames(providers),
+		Cursors:   cursors,
+	})
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to encode cursor with: %s", err.Error()))
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// providerNames returns the sorted names of the providers.
+func providerNames(providers []LiteratureProvider) []string {
+	names := []string{}
+	for _, provider := range providers {
+		names = append(names, provider.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dedupePapers removes repeat papers, matched by DOI, keeping the first copy.
+// Fields missing from the first copy are filled in from later ones, and the
+// providers of every copy are recorded.
+func dedupePapers(papers []Paper) []Paper {
+	deduped := []Paper{}
+	byDOI := make(map[string]int)
+	for _, paper := range papers {
+		if len(paper.Providers) == 0 {
+			paper.Providers = []string{paper.Provider}
+		}
+		doi := normaliseDOI(paper.DOI)
+		i, ok := byDOI[doi]
+		if doi == "" || !ok {
+			if doi != "" {
+				byDOI[doi] = len(deduped)
+			}
+			deduped = append(deduped, paper)
+			continue
+		}
+
+		existing := &deduped[i]
+		existing.Providers = append(existing.Providers, paper.Providers...)
+		for _, field := range []struct{ into, from *string }{
+			{&existing.PMID, &paper.PMID},
+			{&existing.Title, &paper.Title},
+			{&existing.Journal, &paper.Journal},
+			{&existing.PubYear, &paper.PubYear},
+			{&existing.Abstract, &paper.Abstract},
+			{&existing.URL, &paper.URL},
+		} {
+			if *field.into == "" {
+				*field.into = *field.from
+			}
+		}
+		if len(existing.Authors) == 0 {
+			existing.Authors = paper.Authors
+		}
+		existing.OpenAccess = existing.OpenAccess || paper.OpenAccess
+	}
+	return deduped
+}
+
+// annotatePapers sets GatewayPublication on each paper whose DOI matches a
+// document in the gateway's publication index.
+func annotatePapers(ctx context.Context, papers []Paper) {
+	dois := []string{}
+	for _, paper := range papers {
+		dois = append(dois, paper.DOI)
+	}
+	publications := gatewayPublications(ctx, dois)
+	for i := range papers {
+		if publication, ok := publications[normaliseDOI(papers[i].DOI)]; ok {
+			papers[i].GatewayPublication = publication
+		}
+	}
+}
+
+// getLiterature sends a GET request to a literature provider and returns the
+// response body. found is false if the provider responds with 404, which
+// providers use for unknown DOIs.
+func getLiterature(ctx context.Context, client HTTPClient, provider string, urlPath string) (body []byte, found bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", urlPath, nil)
+	if err != nil {
+		slog.Info(fmt.Sprintf("Failed to build %s query with: %s", provider, err.Error()))
+		return nil, false, err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	response, err := client.Do(req)
+	if err != nil {
+		slog.Info(fmt.Sprintf("Failed to execute %s query with: %s", provider, err.Error()))
+		return nil, false, err
+	}
+	defer response.Body.Close()
+
+	body, err = io.ReadAll(response.Body)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to get %s response with: %s", provider, err.Error()))
+		return nil, false, err
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		slog.Warn(fmt.Sprintf("%s query returned status %d: %s", provider, response.StatusCode, body))
+		return nil, false, fmt.Errorf("%s responded with status %d", provider, response.StatusCode)
+	}
+	return body, true, nil
+}
+
+// europePMCProvider adapts the EuropePMC search functions to the
+// LiteratureProvider interface. Requests go through the shared PMCClient and
+// epmc_cache index.
+type europePMCProvider struct{}
+
+func (europePMCProvider) Name() string {
+	return "europepmc"
+}
+
+func (europePMCProvider) SearchDOI(ctx context.Context, query Query) (LiteratureResult, error) {
+	result, found := PMCCoreResponse{}, false
+	if _, filtered := query.Filters["paper"]; !filtered {
+		result, found = cachedPaperByDOI(ctx, normaliseDOI(query.QueryString))
+	}
+	if !found {
+		urlPath, err := epmcSearchURL(buildDoiQuery(query), defaultPMCPageSize, initialCursorMark)
+		if err != nil {
+			return LiteratureResult{}, err
+		}
+		if result, err = fetchPMC(ctx, urlPath); err != nil {
+			return LiteratureResult{}, err
+		}
+	}
+	return literatureResultFromPMC(result), nil
+}
+
+func (europePMCProvider) SearchFields(ctx context.Context, query FieldQuery) (LiteratureResult, error) {
+	urlPath, err := epmcSearchURL(buildQueryString(query), pmcPageSize(query.PageSize), query.CursorMark)
+	if err != nil {
+		return LiteratureResult{}, err
+	}
+	result, err := fetchPMC(ctx, urlPath)
+	if err != nil {
+		return LiteratureResult{}, err
+	}
+	return literatureResultFromPMC(result), nil
+}
+
+func literatureResultFromPMC(result PMCCoreResponse) LiteratureResult {
+	papers := []Paper{}
+	for _, core := range result.ResultList["result"] {
+		papers = append(papers, paperFromPMC(core))
+	}
+	return LiteratureResult{
+		HitCount:       result.HitCount,
+		NextCursorMark: result.NextCursorMark,
+		Papers:         papers,
+	}
+}
+
+// paperFromPMC converts a EuropePMC result into the normalised Paper format.
+func paperFromPMC(core PaperCore) Paper {
+	paper := Paper{
+		Provider:         "europepmc",
+		ID:               core.ID,
+		DOI:              core.DOI,
+		PMID:             core.PMID,
+		Title:            core.Title,
+		Authors:          []string{},
+		Journal:          paperJournal(core),
+		PubYear:          core.PubYear,
+		Abstract:         core.AbstractText,
+		PublicationTypes: paperPublicationTypes(core),
+		OpenAccess:       paperOpenAccess(core),
+	}
+	for _, author := range strings.Split(core.AuthorString, ",") {
+		if author = strings.TrimSuffix(strings.TrimSpace(author), "."); author != "" {
+			paper.Authors = append(paper.Authors, author)
+		}
+	}
+	if urls := core.FullTextUrlList["fullTextUrl"]; len(urls) > 0 {
+		paper.URL = urls[0].Url
+	} else if doi := normaliseDOI(core.DOI); doi != "" {
+		paper.URL = fmt.Sprintf("https://doi.org/%s", doi)
+	}
+	return paper
+}
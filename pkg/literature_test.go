@@ -0,0 +1,218 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProvider returns the same result for every search.
+type fakeProvider struct {
+	name   string
+	result LiteratureResult
+	err    error
+}
+
+func (f fakeProvider) Name() string {
+	return f.name
+}
+
+func (f fakeProvider) SearchDOI(ctx context.Context, query Query) (LiteratureResult, error) {
+	return f.result, f.err
+}
+
+func (f fakeProvider) SearchFields(ctx context.Context, query FieldQuery) (LiteratureResult, error) {
+	return f.result, f.err
+}
+
+func TestDedupePapers(t *testing.T) {
+	papers := []Paper{
+		{Provider: "crossref", ID: "10.123/abc", DOI: "10.123/abc", Title: "A publication"},
+		{Provider: "openalex", ID: "W1", DOI: "10.123/ABC", Abstract: "An abstract", OpenAccess: true},
+		{Provider: "openalex", ID: "W2"},
+		{Provider: "crossref", ID: "W3"},
+	}
+
+	deduped := dedupePapers(papers)
+
+	assert.Len(t, deduped, 3)
+	assert.EqualValues(t, "10.123/abc", deduped[0].ID)
+	assert.EqualValues(t, []string{"crossref", "openalex"}, deduped[0].Providers)
+	assert.EqualValues(t, "An abstract", deduped[0].Abstract)
+	assert.True(t, deduped[0].OpenAccess)
+}
+
+func TestSearchLiteraturePartialFailure(t *testing.T) {
+	providers := []LiteratureProvider{
+		fakeProvider{name: "crossref", err: errors.New("Crossref responded with status 500")},
+		fakeProvider{name: "openalex", result: LiteratureResult{
+			HitCount:       10,
+			NextCursorMark: "next",
+			Papers:         []Paper{{Provider: "openalex", ID: "W1"}},
+		}},
+	}
+
+	response, err := searchLiterature(context.Background(), providers,
+		func(ctx context.Context, provider LiteratureProvider) (LiteratureResult, error) {
+			return provider.SearchFields(ctx, FieldQuery{})
+		})
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, response.HitCount)
+	assert.EqualValues(t, "Crossref responded with status 500", response.Providers["crossref"].Error)
+	assert.EqualValues(t, LiteratureProviderStatus{HitCount: 10, NextCursorMark: "next"}, response.Providers["openalex"])
+}
+
+func TestSearchLiteratureAllFail(t *testing.T) {
+	providers := []LiteratureProvider{
+		fakeProvider{name: "crossref", err: ErrCircuitOpen},
+	}
+
+	_, err := searchLiterature(context.Background(), providers,
+		func(ctx context.Context, provider LiteratureProvider) (LiteratureResult, error) {
+			return provider.SearchDOI(ctx, Query{})
+		})
+
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+}
+
+func TestPaperFromPMC(t *testing.T) {
+	var core PMCCoreResponse
+	json.Unmarshal([]byte(epmcRespJson), &core)
+
+	paper := paperFromPMC(core.ResultList["result"][0])
+
+	assert.EqualValues(t, "europepmc", paper.Provider)
+	assert.EqualValues(t, "10.123/abc", paper.DOI)
+	assert.EqualValues(t, []string{"Monday A", "Tuesday B", "Wednesday C"}, paper.Authors)
+	assert.EqualValues(t, "Journal of Health", paper.Journal)
+	assert.EqualValues(t, []string{"Research articles"}, paper.PublicationTypes)
+}
+
+func TestFieldSearchProviders(t *testing.T) {
+	defer func(providers map[string]LiteratureProvider) { literatureProviders = providers }(literatureProviders)
+	literatureProviders = map[string]LiteratureProvider{
+		"crossref": fakeProvider{name: "crossref", result: LiteratureResult{
+			HitCount: 1,
+			Papers:   []Paper{{Provider: "crossref", ID: "10.123/abc", DOI: "10.123/abc"}},
+		}},
+		"openalex": fakeProvider{name: "openalex", result: LiteratureResult{
+			HitCount: 1,
+			Papers:   []Paper{{Provider: "openalex", ID: "W1", DOI: "https://doi.org/10.123/abc"}},
+		}},
+	}
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	bodyBytes, _ := json.Marshal(gin.H{
+		"query":     "asthma",
+		"field":     []string{"TITLE"},
+		"providers": []string{"Crossref", "openalex"},
+	})
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+	FieldSearch(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	var testResp LiteratureResponse
+	json.Unmarshal(w.Body.Bytes(), &testResp)
+	assert.EqualValues(t, 1, testResp.HitCount)
+	assert.EqualValues(t, []string{"crossref", "openalex"}, testResp.Papers[0].Providers)
+	assert.Contains(t, testResp.Providers, "openalex")
+}
+
+// cursorProvider returns a page of one paper per cursor, recording the
+// cursor it was searched from, and has no more results after the last page.
+type cursorProvider struct {
+	name     string
+	pages    map[string]string
+	searched *[]string
+}
+
+func (p cursorProvider) Name() string {
+	return p.name
+}
+
+func (p cursorProvider) SearchDOI(ctx context.Context, query Query) (LiteratureResult, error) {
+	return LiteratureResult{}, nil
+}
+
+func (p cursorProvider) SearchFields(ctx context.Context, query FieldQuery) (LiteratureResult, error) {
+	*p.searched = append(*p.searched, p.name+":"+query.CursorMark)
+	next, ok := p.pages[query.CursorMark]
+	if !ok {
+		return LiteratureResult{Papers: []Paper{}}, nil
+	}
+	return LiteratureResult{
+		HitCount:       len(p.pages),
+		NextCursorMark: next,
+		Papers:         []Paper{{Provider: p.name, ID: p.name + query.CursorMark}},
+	}, nil
+}
+
+func TestSearchLiteratureFieldsCursor(t *testing.T) {
+	crossrefSearched, openAlexSearched := []string{}, []string{}
+	providers := []LiteratureProvider{
+		cursorProvider{name: "crossref", pages: map[string]string{"*": "c1", "c1": "c2"}, searched: &crossrefSearched},
+		cursorProvider{name: "openalex", pages: map[string]string{"*": "o1"}, searched: &openAlexSearched},
+	}
+
+	cursors, err := decodeLiteratureCursor("", providers)
+	assert.Nil(t, err)
+	response, err := searchLiteratureFields(context.Background(), providers, FieldQuery{}, cursors)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, response.HitCount)
+	assert.NotEmpty(t, response.NextCursorMark)
+
+	cursors, err = decodeLiteratureCursor(response.NextCursorMark, providers)
+	assert.Nil(t, err)
+	assert.EqualValues(t, map[string]string{"crossref": "c1", "openalex": "o1"}, cursors)
+	response, err = searchLiteratureFields(context.Background(), providers, FieldQuery{}, cursors)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, response.HitCount)
+
+	cursors, err = decodeLiteratureCursor(response.NextCursorMark, providers)
+	assert.Nil(t, err)
+	assert.EqualValues(t, map[string]string{"crossref": "c2"}, cursors)
+	response, err = searchLiteratureFields(context.Background(), providers, FieldQuery{}, cursors)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, response.HitCount)
+	assert.Empty(t, response.NextCursorMark)
+
+	assert.EqualValues(t, []string{"crossref:*", "crossref:c1", "crossref:c2"}, crossrefSearched)
+	assert.EqualValues(t, []string{"openalex:*", "openalex:o1"}, openAlexSearched)
+
+	other := append([]LiteratureProvider{fakeProvider{name: "europepmc"}}, providers[1:]...)
+	_, err = decodeLiteratureCursor(encodeLiteratureCursor(providers, map[string]string{"crossref": "c1"}), other)
+	assert.NotNil(t, err)
+	cursors, err = decodeLiteratureCursor("c1", providers[:1])
+	assert.Nil(t, err)
+	assert.EqualValues(t, map[string]string{"crossref": "c1"}, cursors)
+	assert.EqualValues(t, "c2", encodeLiteratureCursor(providers[:1], map[string]string{"crossref": "c2"}))
+}
+
+func TestDOISearchUnknownProvider(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	bodyBytes, _ := json.Marshal(gin.H{
+		"query":     "10.123/abc",
+		"providers": []string{"scopus"},
+	})
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+	DOISearch(c)
+
+	assert.EqualValues(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,95 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multiSearchRequest is a single search within a multi search request.
+type multiSearchRequest struct {
+	Index string
+	Body  gin.H
+}
+
+// multiSearchResult is the response to a single search within a multi search
+// request, or the error elastic gave for it.
+type multiSearchResult struct {
+	Response SearchResponse
+	Err      error
+}
+
+// multiSearch runs several searches in a single _msearch request, returning
+// their results in the order of requests. A search which fails on its own
+// returns its error in its result, rather than failing the others. An error
+// is only returned if the request as a whole fails.
+func multiSearch(ctx context.Context, requests []multiSearchRequest) ([]multiSearchResult, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, request := range requests {
+		if err := encoder.Encode(gin.H{"index": request.Index}); err != nil {
+			return nil, err
+		}
+		if err := encoder.Encode(request.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := readElasticResponse(ElasticClient.Msearch(
+		&buf,
+		ElasticClient.Msearch.WithContext(ctx),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var msearchResp struct {
+		Responses []json.RawMessage `json:"responses"`
+	}
+	if err := json.Unmarshal(body, &msearchResp); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to unmarshal multi search response with %s", err.Error()))
+		return nil, err
+	}
+
+	results := make([]multiSearchResult, len(requests))
+	for i, request := range requests {
+		if i >= len(msearchResp.Responses) {
+			results[i].Err = fmt.Errorf("no response to search of index %s", request.Index)
+		} else if err := searchError(msearchResp.Responses[i]); err != nil {
+			results[i].Err = fmt.Errorf("search of index %s failed: %w", request.Index, err)
+		} else if err := json.Unmarshal(msearchResp.Responses[i], &results[i].Response); err != nil {
+			results[i].Err = fmt.Errorf("failed to unmarshal response from index %s: %w", request.Index, err)
+		}
+		if results[i].Err != nil {
+			slog.Warn(results[i].Err.Error())
+		}
+	}
+	return results, nil
+}
+
+// searchError returns the error in the body of a failed search, or nil if the
+// search succeeded.
+func searchError(body []byte) error {
+	var failure struct {
+		Error *struct {
+			Type      string      `json:"type"`
+			Reason    string      `json:"reason"`
+			RootCause []RootCause `json:"root_cause"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &failure); err != nil || failure.Error == nil {
+		return nil
+	}
+	if len(failure.Error.RootCause) > 0 && failure.Error.RootCause[0].Reason != "" {
+		return errors.New(failure.Error.RootCause[0].Reason)
+	}
+	if failure.Error.Reason != "" {
+		return errors.New(failure.Error.Reason)
+	}
+	return errors.New(failure.Error.Type)
+}
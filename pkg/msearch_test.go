@@ -0,0 +1,215 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"hdruk/search-service/utils/mocks"
+)
+
+func TestMultiSearch(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	mockElasticResponses(func(req *http.Request) string {
+		return `{"responses": [
+			{"took": 1, "hits": {"hits": [{"_id": "1", "_score": 1}]}},
+			{"error": {"root_cause": [{"type": "index_not_found_exception", "reason": "no such index [tool]"}]}, "status": 404}
+		]}`
+	})
+
+	results, err := multiSearch(context.Background(), []multiSearchRequest{
+		{Index: "dataset", Body: gin.H{"size": 1}},
+		{Index: "tool", Body: gin.H{"size": 1}},
+		{Index: "collection", Body: gin.H{"size": 1}},
+	})
+
+	assert.Nil(t, err)
+	assert.Len(t, results, 3)
+	assert.Nil(t, results[0].Err)
+	assert.EqualValues(t, "1", results[0].Response.Hits.Hits[0].Id)
+	assert.EqualError(t, results[1].Err, "search of index tool failed: no such index [tool]")
+	assert.Nil(t, results[1].Response.Hits.Hits)
+	assert.EqualError(t, results[2].Err, "no response to search of index collection")
+}
+
+func TestSearchGenericMultiSearch(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	paths := []string{}
+	mockElasticResponses(func(req *http.Request) string {
+		paths = append(paths, req.URL.Path)
+		responses := []string{}
+		for i := range genericSearches {
+			if i == 1 {
+				responses = append(responses, `{"error": {"root_cause": [{"type": "search_phase_execution_exception", "reason": "all shards failed"}]}, "status": 400}`)
+				continue
+			}
+			responses = append(responses, `{"took": 2, "hits": {"hits": [{"_id": "1", "_score": 1}]}, "aggregations": {}}`)
+		}
+		return `{"responses": [` + strings.Join(responses, ",") + `]}`
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	MockPostToSearch(c)
+
+	SearchGeneric(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, []string{"/_msearch"}, paths)
+
+	var testResp map[string]genericSearchResult
+	json.Unmarshal(w.Body.Bytes(), &testResp)
+	assert.Len(t, testResp, len(genericSearches))
+	assert.EqualValues(t, 2, testResp["dataset"].Took)
+	assert.Empty(t, testResp["dataset"].Error)
+	assert.Len(t, testResp["datacustodiannetwork"].Hits.Hits, 1)
+	assert.Nil(t, testResp["tool"].Hits.Hits)
+	assert.EqualValues(t, "search of index tool failed: all shards failed", testResp["tool"].Error)
+}
+
+func TestListFiltersMultiSearch(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	var msearchBody string
+	mockElasticResponses(func(req *http.Request) string {
+		body, _ := io.ReadAll(req.Body)
+		msearchBody = string(body)
+		return `{"responses": [
+			{"aggregations": {"publisherName": {"buckets": [{"key": "A", "doc_count": 2}]}}},
+			{"error": {"root_cause": [{"type": "index_not_found_exception", "reason": "no such index [tool]"}]}, "status": 404}
+		]}`
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Body = io.NopCloser(strings.NewReader(`{"filters": [
+		{"type": "dataset", "keys": "publisherName"},
+		{"type": "dataset"},
+		{"type": "tool", "keys": "programmingLanguage"}
+	]}`))
+
+	ListFilters(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.Len(t, strings.Split(strings.TrimSpace(msearchBody), "\n"), 4)
+
+	var testResp map[string][]map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &testResp)
+	assert.Len(t, testResp["filters"], 2)
+	assert.Contains(t, testResp["filters"][0], "dataset")
+	assert.EqualValues(t, map[string]interface{}{
+		"programmingLanguage": map[string]interface{}{"error": "search of index tool failed: no such index [tool]"},
+	}, testResp["filters"][1]["tool"])
+}
+
+// benchmarkLatency stands in for the connection and queueing overhead of each
+// request to elastic.
+const benchmarkLatency = 2 * time.Millisecond
+
+// fanOutSearchGeneric runs the searches of SearchGeneric as separate requests
+// in goroutines, as it did before using a multi search, for comparison.
+func fanOutSearchGeneric(ctx context.Context, query Query) map[string]SearchResponse {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := map[string]SearchResponse{}
+	for _, search := range genericSearches {
+		wg.Add(1)
+		go func(search genericSearch) {
+			defer wg.Done()
+			index := searchEntities[search.Entity].Index
+			result := executeSearch(ctx, index, entityElasticConfigs[search.Entity](query), query, search.EntityType, "")
+			mu.Lock()
+			results[search.ResultKey] = result
+			mu.Unlock()
+		}(search)
+	}
+	wg.Wait()
+	return results
+}
+
+func BenchmarkSearchGenericFanOut(b *testing.B) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	ElasticClient = mocks.MockElasticClientWithLatency(benchmarkLatency)
+	query := Query{QueryString: "asthma"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fanOutSearchGeneric(context.Background(), query)
+	}
+}
+
+func BenchmarkSearchGenericMultiSearch(b *testing.B) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	ElasticClient = mocks.MockElasticClientWithLatency(benchmarkLatency)
+	body, _ := json.Marshal(Query{QueryString: "asthma"})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := GetTestGinContext(httptest.NewRecorder())
+		MockPost(c)
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		SearchGeneric(c)
+	}
+}
+
+func BenchmarkListFiltersFanOut(b *testing.B) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	ElasticClient = mocks.MockElasticClientWithLatency(benchmarkLatency)
+	filters := benchmarkFilters()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		for _, filter := range filters {
+			wg.Add(1)
+			go func(filter map[string]interface{}) {
+				defer wg.Done()
+				index, _ := filterIndex(filter)
+				var elasticResp SearchResponse
+				if responses, err := multiSearch(context.Background(), []multiSearchRequest{{Index: index, Body: filtersRequest(filter)}}); err == nil {
+					elasticResp = responses[0].Response
+				}
+				filterEntry(filter, elasticResp)
+			}(filter)
+		}
+		wg.Wait()
+	}
+}
+
+func BenchmarkListFiltersMultiSearch(b *testing.B) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	ElasticClient = mocks.MockElasticClientWithLatency(benchmarkLatency)
+	body, _ := json.Marshal(gin.H{"filters": benchmarkFilters()})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := GetTestGinContext(httptest.NewRecorder())
+		MockPost(c)
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		ListFilters(c)
+	}
+}
+
+// benchmarkFilters returns a filter listing of the size requested by the
+// gateway's search page.
+func benchmarkFilters() []map[string]interface{} {
+	filters := []map[string]interface{}{}
+	for _, key := range []string{"publisherName", "dataType", "dataTypePath", "geographicLocation", "populationSize", "dateRange", "accessService", "collectionName"} {
+		filters = append(filters, map[string]interface{}{"type": "dataset", "keys": key})
+	}
+	for _, key := range []string{"programmingLanguage", "typeCategory", "license"} {
+		filters = append(filters, map[string]interface{}{"type": "tool", "keys": key})
+	}
+	return filters
+}
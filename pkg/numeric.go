@@ -0,0 +1,172 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scales of the buckets of a numeric facet.
+const (
+	// numericRanges buckets values into the facet's explicit Ranges.
+	numericRanges = "ranges"
+	// numericLog buckets values into Steps ranges growing by a factor of Base,
+	// starting from 1.
+	numericLog = "log"
+	// numericHistogram buckets values into ranges of width Interval.
+	numericHistogram = "histogram"
+	// numericAuto buckets values into about Buckets ranges chosen by elastic
+	// to fit the values found.
+	numericAuto = "auto"
+)
+
+// unreportedKey is the key of the bucket counting documents with the
+// Unreported value of a numeric facet.
+const unreportedKey = "Unreported"
+
+// numericFacet describes how the values of a numeric field are bucketed when
+// the field is aggregated, and how it is filtered.
+type numericFacet struct {
+	Scale    string
+	Ranges   []gin.H
+	Base     float64
+	Steps    int
+	Interval float64
+	Buckets  int
+	// Unreported is the value recording that the field was not reported, if
+	// any. Documents with this value are counted in their own bucket, and
+	// included by a filter with includeUnreported.
+	Unreported *float64
+}
+
+var unreportedPopulation = -1.0
+
+// numericFacets maps numeric fields to the definition of their facet.
+var numericFacets = map[string]numericFacet{
+	"populationSize": {Scale: numericLog, Base: 10, Steps: 9, Unreported: &unreportedPopulation},
+}
+
+// ranges returns the buckets of a facet with a range or log scale. The
+// Unreported bucket runs from the Unreported value up to the first range.
+func (f numericFacet) ranges() []gin.H {
+	ranges := []gin.H{}
+	switch f.Scale {
+	case numericRanges:
+		ranges = append(ranges, f.Ranges...)
+	case numericLog:
+		for i := 0; i < f.Steps; i++ {
+			ranges = append(ranges, gin.H{
+				"from": math.Pow(f.Base, float64(i)),
+				"to":   math.Pow(f.Base, float64(i+1)),
+			})
+		}
+	}
+	if f.Unreported != nil && len(ranges) > 0 {
+		ranges = append([]gin.H{{"from": *f.Unreported, "to": ranges[0]["from"], "key": unreportedKey}}, ranges...)
+	}
+	return ranges
+}
+
+// numericFacetAggs defines the aggregations of field, keyed by name. Range
+// and log scales use a single range aggregation named after the field, while
+// histograms leave out the Unreported value, which is counted by a separate
+// aggregation.
+func numericFacetAggs(field string, facet numericFacet) gin.H {
+	if facet.Scale == numericRanges || facet.Scale == numericLog {
+		return gin.H{field: gin.H{"range": gin.H{"field": field, "ranges": facet.ranges()}}}
+	}
+
+	var histogram gin.H
+	if facet.Scale == numericAuto {
+		histogram = gin.H{"variable_width_histogram": gin.H{"field": field, "buckets": facet.Buckets}}
+	} else {
+		histogram = gin.H{"histogram": gin.H{"field": field, "interval": facet.Interval}}
+	}
+	if facet.Unreported == nil {
+		return gin.H{field: histogram}
+	}
+
+	unreported := gin.H{"term": gin.H{field: *facet.Unreported}}
+	return gin.H{
+		field: gin.H{
+			"filter": gin.H{"bool": gin.H{"must_not": []gin.H{unreported}}},
+			"aggs":   gin.H{field: histogram},
+		},
+		field + unreportedKey: gin.H{"filter": unreported},
+	}
+}
+
+// numericFacetResult reads the buckets of field from the aggregations defined
+// by numericFacetAggs, with any Unreported bucket first, in the same format as
+// a range aggregation.
+func numericFacetResult(field string, facet numericFacet, aggs map[string]interface{}) interface{} {
+	if facet.Scale == numericRanges || facet.Scale == numericLog {
+		return aggs[field]
+	}
+
+	buckets := []interface{}{}
+	histogram, _ := aggs[field].(map[string]interface{})
+	if facet.Unreported != nil {
+		unreported, _ := aggs[field+unreportedKey].(map[string]interface{})
+		buckets = append(buckets, gin.H{"key": unreportedKey, "doc_count": unreported["doc_count"]})
+		histogram, _ = histogram[field].(map[string]interface{})
+	}
+	histogramBuckets, _ := histogram["buckets"].([]interface{})
+	buckets = append(buckets, histogramBuckets...)
+	return gin.H{"buckets": buckets}
+}
+
+// validateNumericFilter checks that a numeric filter is an object in the
+// format taken by numericFilter, with numeric bounds.
+func validateNumericFilter(terms interface{}) error {
+	options, ok := terms.(map[string]interface{})
+	if !ok {
+		return errors.New("numeric filters must be an object with from and to bounds")
+	}
+	for _, key := range []string{"from", "to"} {
+		if value, ok := options[key]; ok && value != nil {
+			if _, ok := value.(float64); !ok {
+				return fmt.Errorf("%s must be a number", key)
+			}
+		}
+	}
+	if value, ok := options["includeUnreported"]; ok {
+		if _, ok := value.(bool); !ok {
+			return errors.New("includeUnreported must be true or false")
+		}
+	}
+	return nil
+}
+
+// numericFilter builds the filter clause for field from an object of the form
+//
+//	{"from": 1000, "to": 10000, "includeUnreported": true}
+//
+// where either bound may be left out, and includeUnreported also matches
+// documents with the facet's Unreported value.
+func numericFilter(field string, facet numericFacet, terms interface{}) gin.H {
+	options, _ := terms.(map[string]interface{})
+	bounds := gin.H{}
+	if from, ok := options["from"]; ok {
+		bounds["gte"] = from
+	}
+	if to, ok := options["to"]; ok {
+		bounds["lte"] = to
+	}
+	rangeClause := gin.H{"range": gin.H{field: bounds}}
+
+	includeUnreported, _ := options["includeUnreported"].(bool)
+	if includeUnreported && facet.Unreported != nil {
+		return gin.H{
+			"bool": gin.H{
+				"should": []gin.H{
+					rangeClause,
+					{"term": gin.H{field: *facet.Unreported}},
+				},
+			},
+		}
+	}
+	return gin.H{"bool": gin.H{"must": []gin.H{rangeClause}}}
+}
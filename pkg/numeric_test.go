@@ -0,0 +1,76 @@
+package search
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumericFacetLogRanges(t *testing.T) {
+	ranges := numericFacets["populationSize"].ranges()
+
+	assert.Len(t, ranges, 10)
+	assert.EqualValues(t, gin.H{"from": -1.0, "to": 1.0, "key": "Unreported"}, ranges[0])
+	assert.EqualValues(t, gin.H{"from": 1.0, "to": 10.0}, ranges[1])
+	assert.EqualValues(t, gin.H{"from": 1e8, "to": 1e9}, ranges[9])
+}
+
+func TestNumericFacetExplicitRanges(t *testing.T) {
+	facet := numericFacet{Scale: numericRanges, Ranges: []gin.H{{"to": 18}, {"from": 18}}}
+
+	assert.EqualValues(t, gin.H{
+		"age": gin.H{"range": gin.H{"field": "age", "ranges": []gin.H{{"to": 18}, {"from": 18}}}},
+	}, numericFacetAggs("age", facet))
+}
+
+func TestNumericFacetHistogram(t *testing.T) {
+	unreported := -1.0
+	facet := numericFacet{Scale: numericHistogram, Interval: 100, Unreported: &unreported}
+
+	aggs := numericFacetAggs("participants", facet)
+	assert.EqualValues(t, gin.H{
+		"filter": gin.H{"bool": gin.H{"must_not": []gin.H{{"term": gin.H{"participants": -1.0}}}}},
+		"aggs":   gin.H{"participants": gin.H{"histogram": gin.H{"field": "participants", "interval": 100.0}}},
+	}, aggs["participants"])
+	assert.EqualValues(t, gin.H{"filter": gin.H{"term": gin.H{"participants": -1.0}}}, aggs["participantsUnreported"])
+
+	var elasticAggs map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"participants": {"doc_count": 3, "participants": {"buckets": [{"key": 0, "doc_count": 3}]}},
+		"participantsUnreported": {"doc_count": 2}
+	}`), &elasticAggs)
+	result := numericFacetResult("participants", facet, elasticAggs).(gin.H)
+	buckets := result["buckets"].([]interface{})
+	assert.Len(t, buckets, 2)
+	assert.EqualValues(t, gin.H{"key": "Unreported", "doc_count": 2.0}, buckets[0])
+}
+
+func TestNumericFacetAuto(t *testing.T) {
+	facet := numericFacet{Scale: numericAuto, Buckets: 10}
+
+	assert.EqualValues(t, gin.H{
+		"size": gin.H{"variable_width_histogram": gin.H{"field": "size", "buckets": 10}},
+	}, numericFacetAggs("size", facet))
+}
+
+func TestNumericFilter(t *testing.T) {
+	facet := numericFacets["populationSize"]
+
+	assert.EqualValues(t, gin.H{"bool": gin.H{"should": []gin.H{
+		{"range": gin.H{"populationSize": gin.H{"gte": 10, "lte": 100}}},
+		{"term": gin.H{"populationSize": -1.0}},
+	}}}, numericFilter("populationSize", facet, map[string]interface{}{"from": 10, "to": 100, "includeUnreported": true}))
+
+	assert.EqualValues(t, gin.H{"bool": gin.H{"must": []gin.H{
+		{"range": gin.H{"populationSize": gin.H{"gte": 10}}},
+	}}}, numericFilter("populationSize", facet, map[string]interface{}{"from": 10}))
+}
+
+func TestValidateNumericFilter(t *testing.T) {
+	assert.Nil(t, validateNumericFilter(map[string]interface{}{"from": 10.0, "to": nil, "includeUnreported": true}))
+	assert.NotNil(t, validateNumericFilter([]interface{}{10.0, 100.0}))
+	assert.NotNil(t, validateNumericFilter(map[string]interface{}{"from": "10"}))
+	assert.NotNil(t, validateNumericFilter(map[string]interface{}{"includeUnreported": "yes"}))
+}
@@ -0,0 +1,239 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxOpenAlexPageSize is the largest page of results OpenAlex will return.
+const maxOpenAlexPageSize = 200
+
+// openAlexWork represents the metadata returned by the OpenAlex API for each
+// work.
+type openAlexWork struct {
+	ID              string `json:"id"`
+	DOI             string `json:"doi"`
+	Title           string `json:"title"`
+	PublicationYear int    `json:"publication_year"`
+	Type            string `json:"type"`
+	IDs             struct {
+		PMID string `json:"pmid"`
+	} `json:"ids"`
+	Authorships []struct {
+		Author struct {
+			DisplayName string `json:"display_name"`
+		} `json:"author"`
+	} `json:"authorships"`
+	PrimaryLocation *struct {
+		LandingPageURL string `json:"landing_page_url"`
+		Source         *struct {
+			DisplayName string `json:"display_name"`
+		} `json:"source"`
+	} `json:"primary_location"`
+	OpenAccess struct {
+		IsOA bool `json:"is_oa"`
+	} `json:"open_access"`
+	AbstractInvertedIndex map[string][]int `json:"abstract_inverted_index"`
+}
+
+// openAlexSearchResponse is returned by the OpenAlex works route.
+type openAlexSearchResponse struct {
+	Meta struct {
+		Count      int    `json:"count"`
+		NextCursor string `json:"next_cursor"`
+	} `json:"meta"`
+	Results []openAlexWork `json:"results"`
+}
+
+// OpenAlexProvider searches the OpenAlex catalogue of scholarly works.
+type OpenAlexProvider struct {
+	Client  HTTPClient
+	BaseURL string
+	Mailto  string
+}
+
+// NewOpenAlexProvider returns a provider querying the OpenAlex API at
+// baseURL. mailto is sent with each request to use OpenAlex's polite pool.
+func NewOpenAlexProvider(client HTTPClient, baseURL string, mailto string) *OpenAlexProvider {
+	return &OpenAlexProvider{Client: client, BaseURL: strings.TrimSuffix(baseURL, "/"), Mailto: mailto}
+}
+
+func (p *OpenAlexProvider) Name() string {
+	return "openalex"
+}
+
+func (p *OpenAlexProvider) SearchDOI(ctx context.Context, query Query) (LiteratureResult, error) {
+	result := LiteratureResult{Papers: []Paper{}}
+	doi := normaliseDOI(query.QueryString)
+	if doi == "" {
+		return result, nil
+	}
+
+	urlPath := fmt.Sprintf("%s/works/doi:%s", p.BaseURL, url.PathEscape(doi))
+	if p.Mailto != "" {
+		urlPath = fmt.Sprintf("%s?%s", urlPath, url.Values{"mailto": {p.Mailto}}.Encode())
+	}
+	body, found, err := getLiterature(ctx, p.Client, "OpenAlex", urlPath)
+	if err != nil || !found {
+		return result, err
+	}
+
+	var work openAlexWork
+	if err := json.Unmarshal(body, &work); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to unmarshal OpenAlex response with: %s", err.Error()))
+		return result, err
+	}
+	result.HitCount = 1
+	result.Papers = append(result.Papers, paperFromOpenAlex(work))
+	return result, nil
+}
+
+func (p *OpenAlexProvider) SearchFields(ctx context.Context, query FieldQuery) (LiteratureResult, error) {
+	result := LiteratureResult{Papers: []Paper{}}
+
+	body, _, err := getLiterature(ctx, p.Client, "OpenAlex", p.searchURL(query))
+	if err != nil {
+		return result, err
+	}
+
+	var search openAlexSearchResponse
+	if err := json.Unmarshal(body, &search); err != nil {
+		slog.Warn(fmt.Sprintf("Failed to unmarshal OpenAlex response with: %s", err.Error()))
+		return result, err
+	}
+	result.HitCount = search.Meta.Count
+	result.NextCursorMark = search.Meta.NextCursor
+	for _, work := range search.Results {
+		result.Papers = append(result.Papers, paperFromOpenAlex(work))
+	}
+	return result, nil
+}
+
+// searchURL builds the url of an OpenAlex works search. Searches of only the
+// title or only the abstract use the matching search filter, while any other
+// combination of fields searches titles, abstracts and full text.
+func (p *OpenAlexProvider) searchURL(query FieldQuery) string {
+	cursorMark := query.CursorMark
+	if cursorMark == "" {
+		cursorMark = initialCursorMark
+	}
+
+	filters := []string{}
+	params := url.Values{}
+	// Commas separate OpenAlex filters and cannot be escaped within a value
+	searchText := strings.ReplaceAll(query.QueryString, ",", " ")
+	switch {
+	case len(query.Field) == 1 && query.Field[0] == "TITLE":
+		filters = append(filters, fmt.Sprintf("title.search:%s", searchText))
+	case len(query.Field) == 1 && query.Field[0] == "ABSTRACT":
+		filters = append(filters, fmt.Sprintf("abstract.search:%s", searchText))
+	default:
+		params.Set("search", query.QueryString)
+	}
+	filters = append(filters, openAlexFilters(query.Filters)...)
+
+	if len(filters) > 0 {
+		params.Set("filter", strings.Join(filters, ","))
+	}
+	params.Set("per-page", strconv.Itoa(min(pmcPageSize(query.PageSize), maxOpenAlexPageSize)))
+	params.Set("cursor", cursorMark)
+	if p.Mailto != "" {
+		params.Set("mailto", p.Mailto)
+	}
+	return fmt.Sprintf("%s/works?%s", p.BaseURL, params.Encode())
+}
+
+// openAlexFilters converts the "paper" filters of a query into OpenAlex
+// filters, with the publication types combined with OR.
+func openAlexFilters(filters map[string]map[string]interface{}) []string {
+	clauses := []string{}
+	if from, to, ok := paperYearRange(filters); ok {
+		clauses = append(clauses, fmt.Sprintf("publication_year:%s-%s", from, to))
+	}
+	types := []string{}
+	for _, pubType := range paperTypeFilters(filters) {
+		types = append(types, openAlexTypes[pubType]...)
+	}
+	if len(types) > 0 {
+		clauses = append(clauses, fmt.Sprintf("type:%s", strings.Join(types, "|")))
+	}
+	return clauses
+}
+
+// openAlexTypes maps the gateway publication types to OpenAlex work types.
+var openAlexTypes = map[string][]string{
+	"Research articles":   {"article"},
+	"Review articles":     {"review"},
+	"Preprints":           {"preprint"},
+	"Books and documents": {"book", "book-chapter", "report"},
+}
+
+// paperFromOpenAlex converts an OpenAlex work into the normalised Paper format.
+func paperFromOpenAlex(work openAlexWork) Paper {
+	paper := Paper{
+		Provider:         "openalex",
+		ID:               strings.TrimPrefix(work.ID, "https://openalex.org/"),
+		DOI:              normaliseDOI(work.DOI),
+		PMID:             strings.TrimPrefix(work.IDs.PMID, "https://pubmed.ncbi.nlm.nih.gov/"),
+		Title:            work.Title,
+		Authors:          []string{},
+		Abstract:         openAlexAbstract(work.AbstractInvertedIndex),
+		PublicationTypes: []string{},
+		URL:              work.DOI,
+		OpenAccess:       work.OpenAccess.IsOA,
+	}
+	if work.PublicationYear > 0 {
+		paper.PubYear = strconv.Itoa(work.PublicationYear)
+	}
+	if work.PrimaryLocation != nil {
+		if work.PrimaryLocation.Source != nil {
+			paper.Journal = work.PrimaryLocation.Source.DisplayName
+		}
+		if paper.URL == "" {
+			paper.URL = work.PrimaryLocation.LandingPageURL
+		}
+	}
+	for _, authorship := range work.Authorships {
+		if authorship.Author.DisplayName != "" {
+			paper.Authors = append(paper.Authors, authorship.Author.DisplayName)
+		}
+	}
+	for pubType, openAlexTypes := range openAlexTypes {
+		for _, openAlexType := range openAlexTypes {
+			if work.Type == openAlexType {
+				paper.PublicationTypes = append(paper.PublicationTypes, pubType)
+			}
+		}
+	}
+	return paper
+}
+
+// openAlexAbstract rebuilds an abstract from the inverted index of word
+// positions OpenAlex returns in place of the text.
+func openAlexAbstract(invertedIndex map[string][]int) string {
+	type position struct {
+		index int
+		word  string
+	}
+	positions := []position{}
+	for word, indexes := range invertedIndex {
+		for _, index := range indexes {
+			positions = append(positions, position{index: index, word: word})
+		}
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].index < positions[j].index
+	})
+
+	words := []string{}
+	for _, p := range positions {
+		words = append(words, p.word)
+	}
+	return strings.Join(words, " ")
+}
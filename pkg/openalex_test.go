@@ -0,0 +1,116 @@
+package search
+
+import (
+	"context"
+	"hdruk/search-service/utils/mocks"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var openAlexWorkJson = `{
+	"id": "https://openalex.org/W2000000000",
+	"doi": "https://doi.org/10.123/abc",
+	"title": "A publication",
+	"display_name": "A publication",
+	"publication_year": 2020,
+	"type": "review",
+	"ids": {
+		"openalex": "https://openalex.org/W2000000000",
+		"doi": "https://doi.org/10.123/abc",
+		"pmid": "https://pubmed.ncbi.nlm.nih.gov/000000"
+	},
+	"primary_location": {
+		"landing_page_url": "https://journal.example.org/abc",
+		"source": {"display_name": "Journal of Health"}
+	},
+	"authorships": [
+		{"author": {"display_name": "Alice Monday"}},
+		{"author": {"display_name": "Bob Tuesday"}}
+	],
+	"open_access": {"is_oa": true, "oa_status": "gold"},
+	"abstract_inverted_index": {
+		"A": [0],
+		"description": [2],
+		"longer": [1],
+		"of": [3],
+		"the": [4],
+		"paper": [5]
+	}
+}`
+
+var openAlexSearchJson = `{
+	"meta": {
+		"count": 3,
+		"db_response_time_ms": 20,
+		"page": null,
+		"per_page": 1,
+		"next_cursor": "IlsxMDAuMF0i"
+	},
+	"results": [
+		{
+			"id": "https://openalex.org/W2000000001",
+			"doi": null,
+			"title": "A preprint",
+			"publication_year": 2021,
+			"type": "preprint",
+			"primary_location": null
+		}
+	]
+}`
+
+func TestOpenAlexSearchDOI(t *testing.T) {
+	var requested string
+	mockLiteratureResponse(200, openAlexWorkJson, &requested)
+	provider := NewOpenAlexProvider(&mocks.MockClient{}, "https://openalex.test", "")
+
+	result, err := provider.SearchDOI(context.Background(), Query{QueryString: "10.123/abc"})
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, "https://openalex.test/works/doi:10.123%2Fabc", requested)
+	paper := result.Papers[0]
+	assert.EqualValues(t, "openalex", paper.Provider)
+	assert.EqualValues(t, "W2000000000", paper.ID)
+	assert.EqualValues(t, "10.123/abc", paper.DOI)
+	assert.EqualValues(t, "000000", paper.PMID)
+	assert.EqualValues(t, "Journal of Health", paper.Journal)
+	assert.EqualValues(t, "2020", paper.PubYear)
+	assert.EqualValues(t, "A longer description of the paper", paper.Abstract)
+	assert.EqualValues(t, []string{"Review articles"}, paper.PublicationTypes)
+	assert.EqualValues(t, []string{"Alice Monday", "Bob Tuesday"}, paper.Authors)
+	assert.True(t, paper.OpenAccess)
+}
+
+func TestOpenAlexSearchFields(t *testing.T) {
+	var requested string
+	mockLiteratureResponse(200, openAlexSearchJson, &requested)
+	provider := NewOpenAlexProvider(&mocks.MockClient{}, "https://openalex.test", "team@example.org")
+
+	result, err := provider.SearchFields(context.Background(), FieldQuery{
+		QueryString: "asthma, children",
+		Field:       []string{"ABSTRACT"},
+		PageSize:    500,
+		Filters: map[string]map[string]interface{}{
+			"paper": {
+				"publicationDate": []interface{}{"2020"},
+				"publicationType": []interface{}{"Review articles", "Preprints"},
+			},
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Contains(t, requested, "filter=abstract.search%3Aasthma++children%2Cpublication_year%3A2020-%2Ctype%3Areview%7Cpreprint")
+	assert.Contains(t, requested, "per-page=200")
+	assert.Contains(t, requested, "mailto=team%40example.org")
+	assert.EqualValues(t, 3, result.HitCount)
+	assert.EqualValues(t, "IlsxMDAuMF0i", result.NextCursorMark)
+	assert.EqualValues(t, "", result.Papers[0].DOI)
+	assert.EqualValues(t, []string{"Preprints"}, result.Papers[0].PublicationTypes)
+}
+
+func TestOpenAlexAbstract(t *testing.T) {
+	assert.EqualValues(t, "", openAlexAbstract(nil))
+	assert.EqualValues(t, "to be or not to be", openAlexAbstract(map[string][]int{
+		"to": {0, 4}, "be": {1, 5}, "or": {2}, "not": {3},
+	}))
+}
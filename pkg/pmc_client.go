@@ -0,0 +1,278 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by ResilientClient while the circuit breaker is
+// open, i.e. while the upstream service is considered to be down.
+var ErrCircuitOpen = errors.New("circuit breaker open, upstream service unavailable")
+
+// PMCConfig holds the configuration of the client used to query EuropePMC.
+type PMCConfig struct {
+	BaseURL          string
+	Timeout          time.Duration
+	MaxRetries       int
+	BackoffBase      time.Duration
+	BackoffMax       time.Duration
+	FailureThreshold int
+	Cooldown         time.Duration
+	CacheEnabled     bool
+	CacheTTL         time.Duration
+}
+
+// ResilientClient wraps an HTTPClient, retrying requests which fail with a
+// transport error, 429 or 5xx status using exponential backoff, and failing
+// fast with ErrCircuitOpen after FailureThreshold consecutive failed requests
+// until Cooldown has passed.
+type ResilientClient struct {
+	Client      HTTPClient
+	MaxRetries  int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	breaker     *circuitBreaker
+}
+
+var (
+	PMCClient  HTTPClient
+	pmcBaseURL string
+)
+
+func init() {
+	config := defaultPMCConfig()
+	PMCClient = NewResilientClient(&http.Client{Timeout: config.Timeout}, config)
+}
+
+// InitPMCClient configures the EuropePMC client from environment variables.
+// Must be called after godotenv.Load() in main so that env vars are available.
+func InitPMCClient() {
+	config := pmcConfigFromEnv()
+	pmcBaseURL = config.BaseURL
+	pmcCacheEnabled = config.CacheEnabled
+	pmcCacheTTL = config.CacheTTL
+	PMCClient = NewResilientClient(&http.Client{Timeout: config.Timeout}, config)
+}
+
+func defaultPMCConfig() PMCConfig {
+	return PMCConfig{
+		Timeout:          30 * time.Second,
+		MaxRetries:       2,
+		BackoffBase:      200 * time.Millisecond,
+		BackoffMax:       2 * time.Second,
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+		CacheTTL:         24 * time.Hour,
+	}
+}
+
+// pmcConfigFromEnv reads the EuropePMC client configuration, falling back to
+// the defaults for any unset or invalid values.
+func pmcConfigFromEnv() PMCConfig {
+	config := defaultPMCConfig()
+	config.BaseURL = os.Getenv("PMC_URL")
+	if d, err := time.ParseDuration(os.Getenv("PMC_TIMEOUT")); err == nil {
+		config.Timeout = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("PMC_MAX_RETRIES")); err == nil && n >= 0 {
+		config.MaxRetries = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("PMC_BACKOFF_BASE")); err == nil {
+		config.BackoffBase = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("PMC_BACKOFF_MAX")); err == nil {
+		config.BackoffMax = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("PMC_BREAKER_THRESHOLD")); err == nil && n > 0 {
+		config.FailureThreshold = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("PMC_BREAKER_COOLDOWN")); err == nil {
+		config.Cooldown = d
+	}
+	config.CacheEnabled = os.Getenv("EPMC_CACHE_ENABLED") == "true"
+	if d, err := time.ParseDuration(os.Getenv("EPMC_CACHE_TTL")); err == nil {
+		config.CacheTTL = d
+	}
+	return config
+}
+
+// NewResilientClient wraps the given client with the retry and circuit breaker
+// behaviour described by config.
+func NewResilientClient(client HTTPClient, config PMCConfig) *ResilientClient {
+	return &ResilientClient{
+		Client:      client,
+		MaxRetries:  config.MaxRetries,
+		BackoffBase: config.BackoffBase,
+		BackoffMax:  config.BackoffMax,
+		breaker: &circuitBreaker{
+			threshold: config.FailureThreshold,
+			cooldown:  config.Cooldown,
+		},
+	}
+}
+
+// Do sends the request, retrying where the failure is likely to be transient.
+// The response of the final attempt is returned, so callers must still check
+// the status code. Waiting between attempts stops if the request context is
+// cancelled.
+func (r *ResilientClient) Do(req *http.Request) (*http.Response, error) {
+	if !r.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	ctx := req.Context()
+	var response *http.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, bodyErr
+			}
+			req.Body = body
+		}
+
+		response, err = r.Client.Do(req)
+		if !retryable(response, err) || attempt >= r.MaxRetries || ctx.Err() != nil {
+			break
+		}
+
+		wait := r.backoff(attempt, response)
+		if response != nil {
+			slog.Debug(fmt.Sprintf("Retrying %s after status %d in %s", req.URL.Path, response.StatusCode, wait))
+			response.Body.Close()
+		} else {
+			slog.Debug(fmt.Sprintf("Retrying %s after error %s in %s", req.URL.Path, err.Error(), wait))
+		}
+		if sleepErr := sleepContext(ctx, wait); sleepErr != nil {
+			err = sleepErr
+			response = nil
+			break
+		}
+	}
+
+	// A request the caller cancelled, or whose deadline passed, says nothing
+	// about the health of EuropePMC, so it is counted as neither a success
+	// nor a failure, as are requests made without breaker accounting.
+	switch {
+	case ctx.Err() != nil, !breakerAccounting(ctx):
+		r.breaker.release()
+	case retryable(response, err):
+		r.breaker.failure()
+	default:
+		r.breaker.success()
+	}
+	return response, err
+}
+
+type untrackedKey struct{}
+
+// withoutBreakerAccounting returns a context whose EuropePMC requests are
+// still refused while the circuit breaker is open, but whose outcomes are not
+// counted by it. It is used for secondary requests, such as facet counts,
+// whose failure should not stop searches being served.
+func withoutBreakerAccounting(ctx context.Context) context.Context {
+	return context.WithValue(ctx, untrackedKey{}, true)
+}
+
+func breakerAccounting(ctx context.Context) bool {
+	untracked, _ := ctx.Value(untrackedKey{}).(bool)
+	return !untracked
+}
+
+// backoff returns the time to wait before the next attempt, using the
+// Retry-After header where the upstream service provides one.
+func (r *ResilientClient) backoff(attempt int, response *http.Response) time.Duration {
+	if response != nil {
+		if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return min(time.Duration(seconds)*time.Second, r.BackoffMax)
+		}
+	}
+	wait := r.BackoffBase << attempt
+	if wait > 0 {
+		wait += time.Duration(rand.Int63n(int64(wait)/2 + 1))
+	}
+	return min(wait, r.BackoffMax)
+}
+
+// retryable reports whether a request which returned the given response and
+// error should be attempted again.
+func retryable(response *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// circuitBreaker counts consecutive failed requests. Once threshold is
+// reached requests are rejected until cooldown has passed, after which a
+// single trial request is let through to test whether the upstream service
+// has recovered.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.trial = false
+}
+
+// release ends a trial request without recording its outcome, so that
+// another can be let through.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.threshold > 0 && b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			slog.Warn("EuropePMC circuit breaker opened after repeated failures")
+		}
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
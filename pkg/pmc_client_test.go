@@ -0,0 +1,179 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"hdruk/search-service/utils/mocks"
+)
+
+// stubClient returns the responses with the given status codes in turn,
+// counting the number of requests made.
+type stubClient struct {
+	statuses []int
+	calls    int
+}
+
+func (s *stubClient) Do(req *http.Request) (*http.Response, error) {
+	status := s.statuses[min(s.calls, len(s.statuses)-1)]
+	s.calls++
+	return &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(epmcRespJson)),
+	}, nil
+}
+
+func testPMCConfig() PMCConfig {
+	return PMCConfig{
+		MaxRetries:       2,
+		BackoffBase:      time.Millisecond,
+		BackoffMax:       5 * time.Millisecond,
+		FailureThreshold: 2,
+		Cooldown:         20 * time.Millisecond,
+	}
+}
+
+func TestResilientClientRetries(t *testing.T) {
+	stub := &stubClient{statuses: []int{503, 429, 200}}
+	client := NewResilientClient(stub, testPMCConfig())
+
+	req, _ := http.NewRequest("GET", "http://epmc/search", nil)
+	response, err := client.Do(req)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 200, response.StatusCode)
+	assert.EqualValues(t, 3, stub.calls)
+}
+
+func TestResilientClientDoesNotRetryClientErrors(t *testing.T) {
+	stub := &stubClient{statuses: []int{404}}
+	client := NewResilientClient(stub, testPMCConfig())
+
+	req, _ := http.NewRequest("GET", "http://epmc/search", nil)
+	response, err := client.Do(req)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 404, response.StatusCode)
+	assert.EqualValues(t, 1, stub.calls)
+}
+
+func TestResilientClientCircuitBreaker(t *testing.T) {
+	stub := &stubClient{statuses: []int{500}}
+	config := testPMCConfig()
+	config.MaxRetries = 0
+	client := NewResilientClient(stub, config)
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "http://epmc/search", nil)
+		client.Do(req)
+	}
+
+	// Circuit is open so the request is rejected without calling EuropePMC
+	req, _ := http.NewRequest("GET", "http://epmc/search", nil)
+	_, err := client.Do(req)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.EqualValues(t, 2, stub.calls)
+
+	// After the cooldown a trial request is allowed, and closes the circuit on success
+	time.Sleep(config.Cooldown)
+	stub.statuses = []int{200}
+	req, _ = http.NewRequest("GET", "http://epmc/search", nil)
+	response, err := client.Do(req)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 200, response.StatusCode)
+	assert.True(t, client.breaker.allow())
+}
+
+func TestResilientClientHonoursContext(t *testing.T) {
+	stub := &stubClient{statuses: []int{503}}
+	config := testPMCConfig()
+	config.BackoffBase = time.Second
+	config.BackoffMax = time.Second
+	client := NewResilientClient(stub, config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://epmc/search", nil)
+
+	start := time.Now()
+	_, err := client.Do(req)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.EqualValues(t, 1, stub.calls)
+}
+
+func TestResilientClientIgnoresCancelledRequests(t *testing.T) {
+	stub := &stubClient{statuses: []int{500}}
+	config := testPMCConfig()
+	config.MaxRetries = 0
+	client := NewResilientClient(stub, config)
+
+	req, _ := http.NewRequest("GET", "http://epmc/search", nil)
+	client.Do(req)
+
+	// A cancelled request neither resets the count of failures nor counts as one
+	stub.statuses = []int{200}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ = http.NewRequestWithContext(ctx, "GET", "http://epmc/search", nil)
+	client.Do(req)
+	assert.EqualValues(t, 1, client.breaker.failures)
+
+	// A cancelled trial request leaves the circuit open, but lets another through
+	stub.statuses = []int{500}
+	req, _ = http.NewRequest("GET", "http://epmc/search", nil)
+	client.Do(req)
+	time.Sleep(config.Cooldown)
+	stub.statuses = []int{200}
+	req, _ = http.NewRequestWithContext(ctx, "GET", "http://epmc/search", nil)
+	client.Do(req)
+	assert.EqualValues(t, 2, client.breaker.failures)
+	assert.True(t, client.breaker.allow())
+}
+
+func TestResilientClientWithoutBreakerAccounting(t *testing.T) {
+	stub := &stubClient{statuses: []int{500}}
+	config := testPMCConfig()
+	config.MaxRetries = 0
+	client := NewResilientClient(stub, config)
+
+	ctx := withoutBreakerAccounting(context.Background())
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequestWithContext(ctx, "GET", "http://epmc/search", nil)
+		client.Do(req)
+	}
+	assert.EqualValues(t, 0, client.breaker.failures)
+	assert.True(t, client.breaker.allow())
+}
+
+func TestFieldSearchUpstreamErrors(t *testing.T) {
+	defer func() { PMCClient = &mocks.MockClient{} }()
+
+	config := testPMCConfig()
+	config.MaxRetries = 0
+	config.FailureThreshold = 1
+	PMCClient = NewResilientClient(&stubClient{statuses: []int{500}}, config)
+
+	for _, expected := range []int{http.StatusBadGateway, http.StatusServiceUnavailable} {
+		w := httptest.NewRecorder()
+		c := GetTestGinContext(w)
+		c.Request.Method = "POST"
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.Body = io.NopCloser(bytes.NewBufferString(`{"query": "asthma", "field": ["TITLE"]}`))
+
+		FieldSearch(c)
+
+		assert.EqualValues(t, expected, w.Code)
+		assert.Contains(t, w.Body.String(), "error")
+	}
+}
@@ -0,0 +1,119 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Merge strategies accepted as the mergeStrategy of an ArrayFieldQuery.
+const (
+	mergeRoundRobin     = "roundRobin"
+	mergeReciprocalRank = "reciprocalRank"
+	mergeMostMatched    = "mostMatched"
+)
+
+// rrfRankConstant dampens the influence of the top ranked papers in
+// reciprocal rank fusion, using the value from the original paper.
+const rrfRankConstant = 60
+
+// mergedPaper is a paper found by one or more of the query strings of an
+// ArrayFieldQuery. ranks holds the 1-based rank of the paper within the
+// unseen results of each query string that matched it, keyed by query index.
+type mergedPaper struct {
+	paper PaperCore
+	ranks map[int]int
+}
+
+// mergeStrategies orders deduplicated papers, which are passed in round-robin
+// order. Sorts are stable so ties keep their round-robin order.
+var mergeStrategies = map[string]func(papers []*mergedPaper){
+	mergeRoundRobin: func(papers []*mergedPaper) {},
+	mergeReciprocalRank: func(papers []*mergedPaper) {
+		sort.SliceStable(papers, func(i, j int) bool {
+			return papers[i].reciprocalRankScore() > papers[j].reciprocalRankScore()
+		})
+	},
+	mergeMostMatched: func(papers []*mergedPaper) {
+		sort.SliceStable(papers, func(i, j int) bool {
+			return len(papers[i].ranks) > len(papers[j].ranks)
+		})
+	},
+}
+
+// validMergeStrategy returns an error if strategy is not a known merge
+// strategy. An empty strategy defaults to round-robin.
+func validMergeStrategy(strategy string) error {
+	if strategy == "" {
+		return nil
+	}
+	if _, ok := mergeStrategies[strategy]; !ok {
+		return fmt.Errorf("unknown mergeStrategy %q, expected one of %q, %q or %q",
+			strategy, mergeRoundRobin, mergeReciprocalRank, mergeMostMatched)
+	}
+	return nil
+}
+
+// mergePMCResults combines the results of several query strings into a single
+// list, in the order given by strategy. Papers found by more than one query
+// string appear once, identified by DOI, PMID or EuropePMC id, with
+// MatchedQueries listing the query strings which found them in request order.
+func mergePMCResults(results [][]PaperCore, queries []string, strategy string) []PaperCore {
+	papers := []PaperCore{}
+	if len(results) == 0 {
+		return papers
+	}
+
+	merged := []*mergedPaper{}
+	byKey := make(map[string]*mergedPaper)
+
+	for _, roundRobin := range shuffleArrays(rankPapers(results)) {
+		key := paperCacheKey(roundRobin.paper)
+		if existing, ok := byKey[key]; ok {
+			existing.ranks[roundRobin.query] = roundRobin.rank
+			continue
+		}
+		entry := &mergedPaper{paper: roundRobin.paper, ranks: map[int]int{roundRobin.query: roundRobin.rank}}
+		byKey[key] = entry
+		merged = append(merged, entry)
+	}
+
+	if order, ok := mergeStrategies[strategy]; ok {
+		order(merged)
+	}
+
+	for _, entry := range merged {
+		paper := entry.paper
+		paper.MatchedQueries = []string{}
+		for i, query := range queries {
+			if _, ok := entry.ranks[i]; ok {
+				paper.MatchedQueries = append(paper.MatchedQueries, query)
+			}
+		}
+		papers = append(papers, paper)
+	}
+	return papers
+}
+
+type rankedPaper struct {
+	query int
+	rank  int
+	paper PaperCore
+}
+
+func rankPapers(results [][]PaperCore) [][]rankedPaper {
+	ranked := make([][]rankedPaper, len(results))
+	for i, papers := range results {
+		for j, paper := range papers {
+			ranked[i] = append(ranked[i], rankedPaper{query: i, rank: j + 1, paper: paper})
+		}
+	}
+	return ranked
+}
+
+func (m *mergedPaper) reciprocalRankScore() float64 {
+	score := 0.0
+	for _, rank := range m.ranks {
+		score += 1.0 / float64(rrfRankConstant+rank)
+	}
+	return score
+}
@@ -0,0 +1,88 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mergeTestResults() [][]PaperCore {
+	return [][]PaperCore{
+		{{ID: "A"}, {ID: "B"}, {ID: "C"}},
+		{{ID: "D"}, {ID: "C"}},
+		{{ID: "E"}, {ID: "F"}, {ID: "C", DOI: ""}},
+	}
+}
+
+func paperIDs(papers []PaperCore) []string {
+	ids := []string{}
+	for _, paper := range papers {
+		ids = append(ids, paper.ID)
+	}
+	return ids
+}
+
+func TestMergePMCResultsRoundRobin(t *testing.T) {
+	queries := []string{"query A", "query B", "query C"}
+	merged := mergePMCResults(mergeTestResults(), queries, "")
+
+	assert.EqualValues(t, []string{"A", "D", "E", "B", "C", "F"}, paperIDs(merged))
+	assert.EqualValues(t, []string{"query A"}, merged[0].MatchedQueries)
+	assert.EqualValues(t, queries, merged[4].MatchedQueries)
+}
+
+func TestMergePMCResultsReciprocalRank(t *testing.T) {
+	queries := []string{"query A", "query B", "query C"}
+	merged := mergePMCResults(mergeTestResults(), queries, mergeReciprocalRank)
+
+	// C is ranked below the top of every list, but found by all three
+	assert.EqualValues(t, []string{"C", "A", "D", "E", "B", "F"}, paperIDs(merged))
+}
+
+func TestMergePMCResultsMostMatched(t *testing.T) {
+	results := [][]PaperCore{
+		{{ID: "A"}, {ID: "B"}},
+		{{ID: "C"}, {ID: "B"}, {ID: "A"}},
+	}
+	merged := mergePMCResults(results, []string{"query A", "query B"}, mergeMostMatched)
+
+	assert.EqualValues(t, []string{"A", "B", "C"}, paperIDs(merged))
+}
+
+func TestMergePMCResultsDOI(t *testing.T) {
+	results := [][]PaperCore{
+		{{ID: "PMC1", DOI: "10.123/ABC"}},
+		{{ID: "MED1", DOI: "https://doi.org/10.123/abc"}},
+	}
+	merged := mergePMCResults(results, []string{"query A", "query B"}, mergeRoundRobin)
+
+	assert.Len(t, merged, 1)
+	assert.EqualValues(t, "PMC1", merged[0].ID)
+}
+
+func TestValidMergeStrategy(t *testing.T) {
+	assert.Nil(t, validMergeStrategy(""))
+	assert.Nil(t, validMergeStrategy(mergeMostMatched))
+	assert.NotNil(t, validMergeStrategy("random"))
+}
+
+func TestAdvanceArrayPosition(t *testing.T) {
+	page := PMCCoreResponse{
+		NextCursorMark: "next",
+		ResultList: map[string][]PaperCore{
+			"result": {{ID: "1"}, {ID: "2"}, {ID: "3"}},
+		},
+	}
+	start := arrayCursorPosition{CursorMark: "*"}
+
+	// Papers returned out of order are recorded as seen
+	consumed := map[string]bool{"epmc:1": true, "epmc:3": true}
+	partial := advanceArrayPosition(start, page, consumed, 3)
+	assert.EqualValues(t, arrayCursorPosition{CursorMark: "*", Offset: 1, Seen: []string{"epmc:3"}}, partial)
+	assert.EqualValues(t, []string{"2"}, paperIDs(unconsumedPapers(page, partial, consumed)))
+
+	// Filling the gap moves on to the next page and forgets the seen papers
+	consumed["epmc:2"] = true
+	full := advanceArrayPosition(partial, page, consumed, 3)
+	assert.EqualValues(t, arrayCursorPosition{CursorMark: "next"}, full)
+}
@@ -0,0 +1,163 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errEmptyPMCQuery is returned when a search would send EuropePMC an empty
+// query, e.g. because no query string or fields were given.
+var errEmptyPMCQuery = errors.New("query and field must not be empty")
+
+// pmcQuery is a node of a EuropePMC search query. String returns the query in
+// EuropePMC's Lucene based syntax, with user supplied values escaped. The
+// result still needs URL encoding before being used as a query parameter.
+type pmcQuery interface {
+	String() string
+}
+
+// pmcTerm matches documents where Field contains Value. Values containing
+// whitespace are grouped so that every word must appear in Field. Words which
+// are boolean operators, e.g. "and", are quoted so they are searched for
+// rather than combining the words either side.
+type pmcTerm struct {
+	Field string
+	Value string
+}
+
+// pmcPhrase matches documents where Field contains Value as an exact phrase.
+type pmcPhrase struct {
+	Field string
+	Value string
+}
+
+// pmcRange matches documents where Field lies between From and To inclusive.
+// An empty bound leaves the range open-ended.
+type pmcRange struct {
+	Field string
+	From  string
+	To    string
+}
+
+// pmcGroup combines Clauses with the boolean operator Op ("AND" or "OR").
+type pmcGroup struct {
+	Op      string
+	Clauses []pmcQuery
+}
+
+// pmcNot matches documents which match Include but not Exclude.
+type pmcNot struct {
+	Include pmcQuery
+	Exclude pmcQuery
+}
+
+// pmcSpecialChars are the characters with special meaning in Lucene query
+// syntax, escaped with a backslash wherever they appear in a value.
+const pmcSpecialChars = `+-&|!(){}[]^"~*?:\/`
+
+// pmcOperators are the words EuropePMC reads as boolean operators in any case.
+var pmcOperators = map[string]bool{"AND": true, "OR": true, "NOT": true}
+
+func (t pmcTerm) String() string {
+	words := strings.Fields(t.Value)
+	for i, word := range words {
+		if pmcOperators[strings.ToUpper(word)] {
+			words[i] = fmt.Sprintf(`"%s"`, word)
+		} else {
+			words[i] = escapePMC(word)
+		}
+	}
+	if len(words) == 1 {
+		return fmt.Sprintf("%s:%s", t.Field, words[0])
+	}
+	return fmt.Sprintf("%s:(%s)", t.Field, strings.Join(words, " "))
+}
+
+func (p pmcPhrase) String() string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return fmt.Sprintf(`%s:"%s"`, p.Field, r.Replace(p.Value))
+}
+
+func (r pmcRange) String() string {
+	from, to := "*", "*"
+	if r.From != "" {
+		from = escapePMC(r.From)
+	}
+	if r.To != "" {
+		to = escapePMC(r.To)
+	}
+	return fmt.Sprintf("%s:[%s TO %s]", r.Field, from, to)
+}
+
+func (g pmcGroup) String() string {
+	clauses := []string{}
+	for _, clause := range g.Clauses {
+		if clause != nil {
+			clauses = append(clauses, clause.String())
+		}
+	}
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+	return fmt.Sprintf("(%s)", strings.Join(clauses, fmt.Sprintf(" %s ", g.Op)))
+}
+
+func (n pmcNot) String() string {
+	return fmt.Sprintf("(%s NOT %s)", n.Include.String(), n.Exclude.String())
+}
+
+// newPMCTerm returns a pmcTerm, or nil if field or value is blank so that the
+// term is left out of any group rather than matching an empty value.
+func newPMCTerm(field string, value string) pmcQuery {
+	if strings.TrimSpace(field) == "" || strings.TrimSpace(value) == "" {
+		return nil
+	}
+	return pmcTerm{Field: field, Value: value}
+}
+
+// newPMCPhrase returns a pmcPhrase, or nil if value is blank.
+func newPMCPhrase(field string, value string) pmcQuery {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	return pmcPhrase{Field: field, Value: value}
+}
+
+// pmcAnd combines the non-nil clauses with AND, returning nil if there are none.
+func pmcAnd(clauses ...pmcQuery) pmcQuery {
+	return newPMCGroup("AND", clauses)
+}
+
+// pmcOr combines the non-nil clauses with OR, returning nil if there are none.
+func pmcOr(clauses ...pmcQuery) pmcQuery {
+	return newPMCGroup("OR", clauses)
+}
+
+func newPMCGroup(op string, clauses []pmcQuery) pmcQuery {
+	nonNil := []pmcQuery{}
+	for _, clause := range clauses {
+		if clause != nil {
+			nonNil = append(nonNil, clause)
+		}
+	}
+	if len(nonNil) == 0 {
+		return nil
+	}
+	if len(nonNil) == 1 {
+		return nonNil[0]
+	}
+	return pmcGroup{Op: op, Clauses: nonNil}
+}
+
+// escapePMC escapes the Lucene special characters in value.
+func escapePMC(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if strings.ContainsRune(pmcSpecialChars, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
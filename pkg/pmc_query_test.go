@@ -0,0 +1,72 @@
+package search
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPMCQueryString(t *testing.T) {
+	assert.EqualValues(t, "TITLE:asthma", pmcTerm{Field: "TITLE", Value: "asthma"}.String())
+	assert.EqualValues(t, `TITLE:(C\+\+ \& R\: a \"guide\")`, pmcTerm{Field: "TITLE", Value: `C++ & R: a "guide"`}.String())
+	assert.EqualValues(t, `DOI:"10.1/a\"b"`, pmcPhrase{Field: "DOI", Value: `10.1/a"b`}.String())
+	assert.EqualValues(t, "PUB_YEAR:[2020 TO 2021]", pmcRange{Field: "PUB_YEAR", From: "2020", To: "2021"}.String())
+	assert.EqualValues(t, "PUB_YEAR:[2020 TO *]", pmcRange{Field: "PUB_YEAR", From: "2020"}.String())
+
+	query := pmcAnd(
+		pmcOr(pmcTerm{Field: "TITLE", Value: "a"}, pmcTerm{Field: "ABSTRACT", Value: "a"}),
+		pmcNot{Include: pmcTerm{Field: "SRC", Value: "MED"}, Exclude: pmcTerm{Field: "PUB_TYPE", Value: "REVIEW"}},
+		nil,
+	)
+	assert.EqualValues(t, "((TITLE:a OR ABSTRACT:a) AND (SRC:MED NOT PUB_TYPE:REVIEW))", query.String())
+
+	assert.Nil(t, pmcOr())
+	assert.Nil(t, pmcAnd(nil, nil))
+
+	// Boolean operators are searched for as words
+	assert.EqualValues(t, `TITLE:"AND"`, pmcTerm{Field: "TITLE", Value: "AND"}.String())
+	assert.EqualValues(t, `TITLE:(cats "or" dogs NOTE)`, pmcTerm{Field: "TITLE", Value: "cats or dogs NOTE"}.String())
+
+	// Blank terms are left out rather than matching an empty value
+	assert.Nil(t, newPMCTerm("TITLE", "  "))
+	assert.Nil(t, newPMCTerm("", "asthma"))
+	assert.Nil(t, newPMCPhrase("DOI", ""))
+	assert.EqualValues(t, "TITLE:asthma", pmcOr(newPMCTerm("TITLE", "asthma"), newPMCTerm("ABSTRACT", "")).String())
+}
+
+func TestGetFilters(t *testing.T) {
+	assert.Nil(t, getFilters(nil))
+
+	// A single date bound gives an open-ended range rather than panicking
+	filters := map[string]map[string]interface{}{
+		"paper": {
+			"publicationDate": []interface{}{"2020"},
+			"publicationType": []interface{}{"Research articles", "Unknown"},
+		},
+	}
+	filterString := getFilters(filters).String()
+	assert.Contains(t, filterString, "PUB_YEAR:[2020 TO *]")
+	assert.Contains(t, filterString, `((SRC:MED OR SRC:PMC OR SRC:AGR OR SRC:CBA) NOT PUB_TYPE:"Review")`)
+}
+
+func TestEpmcSearchURL(t *testing.T) {
+	query := pmcTerm{Field: "TITLE", Value: "cats & dogs #1"}
+
+	urlPath, err := epmcSearchURL(query, 10, "")
+	assert.Nil(t, err)
+	parsed, err := url.Parse(urlPath)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(parsed.Path, "/search"))
+
+	params := parsed.Query()
+	assert.EqualValues(t, `TITLE:(cats \& dogs #1)`, params.Get("query"))
+	assert.EqualValues(t, "10", params.Get("pageSize"))
+	assert.EqualValues(t, "*", params.Get("cursorMark"))
+	assert.EqualValues(t, "core", params.Get("resultType"))
+	assert.Empty(t, parsed.Fragment)
+
+	_, err = epmcSearchURL(pmcAnd(newPMCTerm("TITLE", " "), nil), 10, "")
+	assert.ErrorIs(t, err, errEmptyPMCQuery)
+}
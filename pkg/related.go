@@ -0,0 +1,170 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RelatedResponse holds a document along with the documents linked to it,
+// grouped by entity type, and the most similar documents of the same type.
+type RelatedResponse struct {
+	Entity   string                 `json:"entity"`
+	ID       string                 `json:"id"`
+	Document map[string]interface{} `json:"document"`
+	Related  map[string][]Hit       `json:"related"`
+	Similar  []Hit                  `json:"similar"`
+}
+
+// RelatedItems returns the document of the entity type and id given in the
+// route, e.g. GET /related/datasets/123, with the documents of other entity
+// types linked to it through the fields listed in entityLinks and a scored
+// list of similar documents of the same type.
+func RelatedItems(c *gin.Context) {
+	entityName := c.Param("entity")
+	entity, ok := searchEntities[entityName]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown entity type %s", entityName)})
+		return
+	}
+
+	id := c.Param("id")
+	document, found, err := getEntityDocument(c.Request.Context(), entity.Index, id)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("%s %s not found", entityName, id)})
+		return
+	}
+
+	response := RelatedResponse{
+		Entity:   entityName,
+		ID:       id,
+		Document: document,
+		Related:  make(map[string][]Hit),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for target, elasticQuery := range relatedElasticQueries(entityName, document) {
+		wg.Add(1)
+		go func(target string, elasticQuery gin.H) {
+			defer wg.Done()
+			results := searchIndex(c.Request.Context(), searchEntities[target].Index, elasticQuery)
+			mu.Lock()
+			defer mu.Unlock()
+			response.Related[target] = nonNilHits(results.Hits.Hits)
+		}(target, elasticQuery)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results := similarSearch(c.Request.Context(), entity, SimilarSearch{ID: id})
+		response.Similar = nonNilHits(results.Hits.Hits)
+	}()
+	wg.Wait()
+
+	c.JSON(http.StatusOK, response)
+}
+
+// relatedElasticQueries builds a query for each entity type linked to the
+// given document of entityName, keyed by the linked entity name. Links are
+// followed in both directions, so a dataset finds the DURs named in its
+// dataUseTitles as well as the DURs listing its title in datasetTitles.
+func relatedElasticQueries(entityName string, document map[string]interface{}) map[string]gin.H {
+	clauses := make(map[string][]gin.H)
+	for _, link := range entityLinks {
+		if link.From == entityName {
+			if values := sourceValues(document, link.Field); len(values) > 0 {
+				clauses[link.To] = append(clauses[link.To], gin.H{"terms": gin.H{link.ToField: values}})
+			}
+		}
+		if link.To == entityName {
+			field := strings.TrimSuffix(link.ToField, ".keyword")
+			if values := sourceValues(document, field); len(values) > 0 {
+				clauses[link.From] = append(clauses[link.From], gin.H{"terms": gin.H{link.Field: values}})
+			}
+		}
+	}
+
+	queries := make(map[string]gin.H)
+	for target, should := range clauses {
+		queries[target] = gin.H{
+			"size": searchNoRecords,
+			"query": gin.H{
+				"bool": gin.H{"should": should, "minimum_should_match": 1},
+			},
+		}
+	}
+	return queries
+}
+
+// sourceValues returns the non-empty string values of field in document,
+// which may hold a single string or a list of strings.
+func sourceValues(document map[string]interface{}, field string) []string {
+	values := []string{}
+	switch v := document[field].(type) {
+	case string:
+		if v != "" {
+			values = append(values, v)
+		}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+func nonNilHits(hits []Hit) []Hit {
+	if hits == nil {
+		return []Hit{}
+	}
+	return hits
+}
+
+// getEntityDocument returns the source of the document with the given id.
+// found is false if there is no such document.
+func getEntityDocument(ctx context.Context, index string, id string) (map[string]interface{}, bool, error) {
+	response, err := ElasticClient.Get(index, url.PathEscape(id), ElasticClient.Get.WithContext(ctx))
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to get document %s from index %s with %s", id, index, err.Error()))
+		return nil, false, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Failed to read elastic response with %s", err.Error()))
+		return nil, false, err
+	}
+	if response.IsError() {
+		slog.Warn(fmt.Sprintf("Get document returned status %d: %s", response.StatusCode, body))
+		return nil, false, fmt.Errorf("elastic responded with status %d", response.StatusCode)
+	}
+
+	var getResp struct {
+		Found  bool                   `json:"found"`
+		Source map[string]interface{} `json:"_source"`
+	}
+	if err := json.Unmarshal(body, &getResp); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to unmarshal elastic response with %s", err.Error()))
+		return nil, false, err
+	}
+	return getResp.Source, getResp.Found, nil
+}
@@ -0,0 +1,78 @@
+package search
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"hdruk/search-service/utils/mocks"
+)
+
+func TestRelatedElasticQueries(t *testing.T) {
+	document := map[string]interface{}{
+		"title":          "Dataset A",
+		"dataUseTitles":  []interface{}{"Project 1", "Project 2"},
+		"collectionName": []interface{}{},
+	}
+
+	queries := relatedElasticQueries("datasets", document)
+
+	assert.Len(t, queries, 6)
+	assert.NotContains(t, queries, "datasets")
+	durShould := queries["dur"]["query"].(gin.H)["bool"].(gin.H)["should"].([]gin.H)
+	assert.ElementsMatch(t, []gin.H{
+		{"terms": gin.H{"projectTitle.keyword": []string{"Project 1", "Project 2"}}},
+		{"terms": gin.H{"datasetTitles": []string{"Dataset A"}}},
+	}, durShould)
+	collectionShould := queries["collections"]["query"].(gin.H)["bool"].(gin.H)["should"].([]gin.H)
+	assert.Len(t, collectionShould, 1)
+	toolShould := queries["tools"]["query"].(gin.H)["bool"].(gin.H)["should"].([]gin.H)
+	assert.EqualValues(t, []gin.H{{"terms": gin.H{"datasetTitles": []string{"Dataset A"}}}}, toolShould)
+}
+
+func TestRelatedItems(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	requestedIndices := []string{}
+	mockElasticResponses(func(req *http.Request) string {
+		if req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/tool/_doc/") {
+			return `{"found": true, "_id": "5", "_source": {"name": "Tool A", "datasetTitles": ["Dataset A"]}}`
+		}
+		requestedIndices = append(requestedIndices, strings.Split(req.URL.Path, "/")[1])
+		return `{"hits": {"hits": [{"_id": "1", "_score": 1.5, "_source": {"title": "Dataset A"}}]}}`
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Params = gin.Params{{Key: "entity", Value: "tools"}, {Key: "id", Value: "5"}}
+
+	RelatedItems(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	var testResp RelatedResponse
+	json.Unmarshal(w.Body.Bytes(), &testResp)
+	assert.EqualValues(t, "Tool A", testResp.Document["name"])
+	assert.Len(t, testResp.Related["datasets"], 1)
+	assert.EqualValues(t, "1", testResp.Related["datasets"][0].Id)
+	assert.Len(t, testResp.Similar, 1)
+	assert.ElementsMatch(t, []string{"dataset", "datacustodiannetwork", "tool"}, requestedIndices)
+}
+
+func TestRelatedItemsNotFound(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	mockElasticResponses(func(req *http.Request) string {
+		return `{"found": false, "_id": "5"}`
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Params = gin.Params{{Key: "entity", Value: "tools"}, {Key: "id", Value: "5"}}
+
+	RelatedItems(c)
+
+	assert.EqualValues(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,467 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// savedSearchIndex is the elastic index holding the searches saved by users.
+const savedSearchIndex = "saved_searches"
+
+// indexedAtField holds the time each document was indexed, set by the
+// indexedAtPipeline unless the document gives one itself, e.g. the time the
+// gateway created it, so that reindexing the document keeps its time.
+const indexedAtField = "indexedAt"
+
+// indexedIDField holds the id of each document, copied by the
+// indexedAtPipeline, to order documents indexed at the same time. Elastic
+// does not sort on _id itself.
+const indexedIDField = "indexedId"
+
+// indexedAtPipeline is the ingest pipeline setting indexedAtField and
+// indexedIDField.
+const indexedAtPipeline = "indexed_at"
+
+// savedSearchPageSize is the number of saved searches read at a time by the
+// alerts job.
+const savedSearchPageSize = 500
+
+// savedSearchMaxHits is the most documents returned by one run of a saved
+// search. Any more are returned by the following run.
+const savedSearchMaxHits = 1000
+
+// savedSearchIndexLag is how long before a run documents must have been
+// indexed to be found by it. A document is stamped with indexedAtField when
+// its bulk request is ingested but is only found once the index refreshes,
+// so documents indexed more recently are left for the next run.
+const savedSearchIndexLag = time.Minute
+
+// savedSearchQueries maps the entities which can be saved, those whose index
+// records indexedAtField, to the builders of their elastic queries.
+var savedSearchQueries = map[string]func(Query) gin.H{
+	"datasets": datasetElasticConfig,
+	"dur":      dataUseElasticConfig,
+}
+
+// SavedSearch is a search saved by a user, re-run to find the documents
+// indexed since it last ran. LastRunAt is moved on each time the user runs the
+// search, and LastAlertAt each time the alerts job publishes its new results,
+// so that neither hides new results from the other. LastRunID and
+// LastAlertID are the ids of the last documents returned when a run was cut
+// short, so that the next run carries on among documents indexed at the same
+// time.
+type SavedSearch struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"userId"`
+	Name        string    `json:"name"`
+	Entity      string    `json:"entity"`
+	Query       Query     `json:"query"`
+	CreatedAt   time.Time `json:"createdAt"`
+	LastRunAt   time.Time `json:"lastRunAt"`
+	LastAlertAt time.Time `json:"lastAlertAt"`
+	LastRunID   string    `json:"lastRunId,omitempty"`
+	LastAlertID string    `json:"lastAlertId,omitempty"`
+}
+
+// SavedSearchResults holds the documents found by a saved search since it
+// last ran, newest first.
+type SavedSearchResults struct {
+	SavedSearch SavedSearch `json:"savedSearch"`
+	Since       time.Time   `json:"since"`
+	Hits        []Hit       `json:"hits"`
+}
+
+// indexedAtPipelineBody defines the ingest pipeline recording when documents
+// were indexed. A time given with the document is kept.
+func indexedAtPipelineBody() gin.H {
+	return gin.H{
+		"description": "Records when documents were indexed",
+		"processors": []gin.H{
+			{"set": gin.H{
+				"field":    indexedAtField,
+				"value":    "{{{_ingest.timestamp}}}",
+				"override": false,
+			}},
+			{"set": gin.H{
+				"field": indexedIDField,
+				"value": "{{{_id}}}",
+			}},
+		},
+	}
+}
+
+/*
+CreateSavedSearch saves a search of datasets or data uses for a user, from a
+request of the form
+
+	{
+		"userId": "123",
+		"name": "Asthma datasets",
+		"entity": "datasets",
+		"query": {"query": "asthma", "filters": {"dataset": {...}}}
+	}
+
+Only documents indexed after the search is saved are returned when it runs.
+*/
+func CreateSavedSearch(c *gin.Context) {
+	var savedSearch SavedSearch
+	if err := c.BindJSON(&savedSearch); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to interpret saved search with %s", err.Error()))
+		return
+	}
+	if err := validateSavedSearch(savedSearch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	savedSearch.ID = uuid.New().String()
+	savedSearch.CreatedAt = now
+	savedSearch.LastRunAt = now
+	savedSearch.LastAlertAt = now
+	if err := indexSavedSearch(c.Request.Context(), savedSearch); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, savedSearch)
+}
+
+// validateSavedSearch checks a saved search has a user, a name and a valid
+// query of an entity which can be saved.
+func validateSavedSearch(savedSearch SavedSearch) error {
+	if savedSearch.UserID == "" || savedSearch.Name == "" {
+		return fmt.Errorf("userId and name are required")
+	}
+	if _, ok := savedSearchQueries[savedSearch.Entity]; !ok {
+		return fmt.Errorf("entity %s cannot be saved, must be datasets or dur", savedSearch.Entity)
+	}
+	return validateQuery(savedSearch.Query, savedSearch.Entity)
+}
+
+// ListSavedSearches returns the searches saved by the user given in the
+// userId query parameter, newest first.
+func ListSavedSearches(c *gin.Context) {
+	userID := c.Query("userId")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userId is required"})
+		return
+	}
+
+	elasticQuery := gin.H{
+		"size":  savedSearchPageSize,
+		"query": gin.H{"term": gin.H{"userId": userID}},
+		"sort":  []gin.H{{"createdAt": "desc"}},
+	}
+	savedSearches := savedSearchesFromHits(searchIndex(c.Request.Context(), savedSearchIndex, elasticQuery).Hits.Hits)
+	c.JSON(http.StatusOK, gin.H{"savedSearches": savedSearches})
+}
+
+// DeleteSavedSearch deletes the saved search with the given id.
+func DeleteSavedSearch(c *gin.Context) {
+	id := c.Param("id")
+	response, err := ElasticClient.Delete(
+		savedSearchIndex,
+		url.PathEscape(id),
+		ElasticClient.Delete.WithContext(c.Request.Context()),
+		ElasticClient.Delete.WithRefresh("true"),
+	)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to delete saved search %s with %s", id, err.Error()))
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("saved search %s not found", id)})
+	case response.IsError():
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("elastic responded with status %d", response.StatusCode)})
+	default:
+		c.JSON(http.StatusOK, gin.H{"id": id, "deleted": true})
+	}
+}
+
+// RunSavedSearch re-runs the saved search with the given id, returning only
+// the documents indexed since the user last ran it, and records the run. The
+// run is not recorded if the search fails.
+func RunSavedSearch(c *gin.Context) {
+	id := c.Param("id")
+	savedSearch, found, err := getSavedSearch(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("saved search %s not found", id)})
+		return
+	}
+
+	since := savedSearch.LastRunAt
+	hits, reached, err := newSavedSearchHits(c.Request.Context(), savedSearch, savedSearchPosition{At: since, ID: savedSearch.LastRunID}, time.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	savedSearch.LastRunAt, savedSearch.LastRunID = reached.At, reached.ID
+	if err := indexSavedSearch(c.Request.Context(), savedSearch); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SavedSearchResults{SavedSearch: savedSearch, Since: since, Hits: hits})
+}
+
+// savedSearchPosition is how far a saved search has reached through the
+// documents in the order they were indexed: the indexedAtField time of the
+// last document returned, and its id when others indexed at the same time
+// may remain. An empty id stands after every document indexed at At.
+type savedSearchPosition struct {
+	At time.Time
+	ID string
+}
+
+// savedSearchHit is a hit of a saved search with the indexedAtField and
+// indexedIDField values it was sorted by.
+type savedSearchHit struct {
+	Hit
+	Sort []interface{} `json:"sort"`
+}
+
+// position returns the position of the document of the hit.
+func (h savedSearchHit) position() (savedSearchPosition, bool) {
+	if len(h.Sort) < 2 {
+		return savedSearchPosition{}, false
+	}
+	millis, ok := h.Sort[0].(float64)
+	id, idOk := h.Sort[1].(string)
+	return savedSearchPosition{At: time.UnixMilli(int64(millis)).UTC(), ID: id}, ok && idOk
+}
+
+// newSavedSearchHits runs a saved search, returning the documents indexed
+// after since and savedSearchIndexLag before until, newest first, along with
+// the position the search has reached. That is the end of the time searched,
+// unless there were more than savedSearchMaxHits documents, when the oldest
+// are returned and the position is that of the newest of them, so that the
+// rest are found by the next run.
+func newSavedSearchHits(ctx context.Context, savedSearch SavedSearch, since savedSearchPosition, until time.Time) ([]Hit, savedSearchPosition, error) {
+	until = until.Add(-savedSearchIndexLag)
+	if !until.After(since.At) {
+		return []Hit{}, since, nil
+	}
+
+	entity := searchEntities[savedSearch.Entity]
+	elasticQuery := newResultsElasticQuery(savedSearchQueries[savedSearch.Entity](savedSearch.Query), since, until)
+	if source := sourceFilter(entity.Index, savedSearch.Query); source != nil {
+		elasticQuery["_source"] = source
+	}
+
+	var response struct {
+		Hits struct {
+			Hits []savedSearchHit `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := searchIndexInto(ctx, entity.Index, elasticQuery, &response); err != nil {
+		return nil, since, err
+	}
+
+	matched := response.Hits.Hits
+	reached := savedSearchPosition{At: until}
+	if len(matched) >= savedSearchMaxHits {
+		newest, ok := matched[len(matched)-1].position()
+		if !ok {
+			return nil, since, fmt.Errorf("results of saved search %s are not sorted by %s and %s", savedSearch.ID, indexedAtField, indexedIDField)
+		}
+		reached = newest
+	}
+
+	hits := []Hit{}
+	for i := len(matched) - 1; i >= 0; i-- {
+		hits = append(hits, matched[i].Hit)
+	}
+	return hits, reached, nil
+}
+
+// newResultsElasticQuery restricts the body of a search to the documents
+// after since and indexed up to until, oldest first, up to
+// savedSearchMaxHits. Documents indexed at the same time are ordered by id,
+// so that a position with an id carries on among them with search_after.
+// Aggregations and explanations are dropped as only the hits are needed.
+func newResultsElasticQuery(elasticQuery gin.H, since savedSearchPosition, until time.Time) gin.H {
+	restricted := gin.H{}
+	for key, value := range elasticQuery {
+		restricted[key] = value
+	}
+	delete(restricted, "aggs")
+	restricted["explain"] = false
+
+	indexedAt := gin.H{"gt": since.At.Format(time.RFC3339Nano), "lte": until.Format(time.RFC3339Nano)}
+	if since.ID != "" {
+		indexedAt = gin.H{"gte": since.At.Format(time.RFC3339Nano), "lte": until.Format(time.RFC3339Nano)}
+		restricted["search_after"] = []interface{}{since.At.UnixMilli(), since.ID}
+	}
+	restricted["query"] = gin.H{
+		"bool": gin.H{
+			"must":   []interface{}{elasticQuery["query"]},
+			"filter": []gin.H{{"range": gin.H{indexedAtField: indexedAt}}},
+		},
+	}
+	restricted["size"] = savedSearchMaxHits
+	restricted["sort"] = []gin.H{{indexedAtField: "asc"}, {indexedIDField: "asc"}}
+	return restricted
+}
+
+// getSavedSearch reads the saved search with the given id.
+func getSavedSearch(ctx context.Context, id string) (SavedSearch, bool, error) {
+	var savedSearch SavedSearch
+	document, found, err := getEntityDocument(ctx, savedSearchIndex, id)
+	if err != nil || !found {
+		return savedSearch, found, err
+	}
+
+	body, err := json.Marshal(document)
+	if err != nil {
+		return savedSearch, false, err
+	}
+	if err := json.Unmarshal(body, &savedSearch); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to unmarshal saved search %s with %s", id, err.Error()))
+		return savedSearch, false, err
+	}
+	return savedSearch, true, nil
+}
+
+// savedSearchesFromHits reads the saved searches from the hits of a search of
+// the saved searches index, skipping any which cannot be read.
+func savedSearchesFromHits(hits []Hit) []SavedSearch {
+	savedSearches := []SavedSearch{}
+	for _, hit := range hits {
+		body, err := json.Marshal(hit.Source)
+		if err != nil {
+			continue
+		}
+		var savedSearch SavedSearch
+		if err := json.Unmarshal(body, &savedSearch); err != nil {
+			slog.Debug(fmt.Sprintf("Failed to unmarshal saved search %s with %s", hit.Id, err.Error()))
+			continue
+		}
+		savedSearches = append(savedSearches, savedSearch)
+	}
+	return savedSearches
+}
+
+// indexSavedSearch creates or replaces a saved search, refreshing the index so
+// that it is listed straight away.
+func indexSavedSearch(ctx context.Context, savedSearch SavedSearch) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(savedSearch); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to encode saved search with %s", err.Error()))
+		return err
+	}
+
+	response, err := ElasticClient.Index(
+		savedSearchIndex,
+		&buf,
+		ElasticClient.Index.WithContext(ctx),
+		ElasticClient.Index.WithDocumentID(savedSearch.ID),
+		ElasticClient.Index.WithRefresh("true"),
+	)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to index saved search %s with %s", savedSearch.ID, err.Error()))
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		body, _ := io.ReadAll(response.Body)
+		slog.Warn(fmt.Sprintf("Index saved search returned status %d: %s", response.StatusCode, body))
+		return fmt.Errorf("elastic responded with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// StartSavedSearchAlerts runs the saved search alerts job in the background
+// every SAVED_SEARCH_ALERT_INTERVAL, e.g. 24h. Alerts are disabled when the
+// interval is not set. Must be called after DefineElasticClient and
+// InitAuditLogger in main.
+func StartSavedSearchAlerts() {
+	interval, err := time.ParseDuration(os.Getenv("SAVED_SEARCH_ALERT_INTERVAL"))
+	if err != nil || interval <= 0 {
+		slog.Info("Saved search alerts disabled, SAVED_SEARCH_ALERT_INTERVAL not set")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			runSavedSearchAlerts(context.Background())
+		}
+	}()
+}
+
+// runSavedSearchAlerts runs every saved search, publishing an event for each
+// with documents indexed since its last alert, and records the alert. A
+// search which fails is tried again on the next run.
+func runSavedSearchAlerts(ctx context.Context) {
+	after := ""
+	for {
+		elasticQuery := gin.H{
+			"size":  savedSearchPageSize,
+			"query": gin.H{"match_all": gin.H{}},
+			"sort":  []gin.H{{"id": "asc"}},
+		}
+		if after != "" {
+			elasticQuery["search_after"] = []string{after}
+		}
+		savedSearches := savedSearchesFromHits(searchIndex(ctx, savedSearchIndex, elasticQuery).Hits.Hits)
+		if len(savedSearches) == 0 {
+			return
+		}
+
+		for _, savedSearch := range savedSearches {
+			hits, reached, err := newSavedSearchHits(ctx, savedSearch, savedSearchPosition{At: savedSearch.LastAlertAt, ID: savedSearch.LastAlertID}, time.Now().UTC())
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Failed to run saved search %s for alerts with %s", savedSearch.ID, err.Error()))
+				continue
+			}
+			if len(hits) > 0 {
+				publishSavedSearchAlert(savedSearch, hits)
+			}
+			savedSearch.LastAlertAt, savedSearch.LastAlertID = reached.At, reached.ID
+			if err := indexSavedSearch(ctx, savedSearch); err != nil {
+				slog.Warn(fmt.Sprintf("Failed to record alert for saved search %s", savedSearch.ID))
+			}
+		}
+		after = savedSearches[len(savedSearches)-1].ID
+	}
+}
+
+// publishSavedSearchAlert publishes a "new results" event for a saved search
+// to the PUBSUB_ALERT_TOPIC_NAME topic, for the gateway to notify the user.
+func publishSavedSearchAlert(savedSearch SavedSearch, hits []Hit) {
+	ids := []string{}
+	for _, hit := range hits {
+		ids = append(ids, hit.Id)
+	}
+	pubSubPublish(os.Getenv("PUBSUB_ALERT_TOPIC_NAME"), gin.H{
+		"action_type":     "new results",
+		"action_name":     savedSearch.Entity,
+		"action_service":  os.Getenv("PUBSUB_SERVICE_NAME"),
+		"description":     fmt.Sprintf("%d new results for saved search %s", len(ids), savedSearch.Name),
+		"created_at":      time.Now().UnixMicro(),
+		"saved_search_id": savedSearch.ID,
+		"user_id":         savedSearch.UserID,
+		"result_ids":      ids,
+	})
+}
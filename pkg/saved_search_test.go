@@ -0,0 +1,205 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"hdruk/search-service/utils/mocks"
+)
+
+func TestNewResultsElasticQuery(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	elasticQuery := datasetElasticConfig(Query{
+		QueryString: "asthma",
+		Aggregations: []map[string]interface{}{
+			{"type": "dataset", "keys": "publisherName"},
+		},
+	})
+
+	restricted := newResultsElasticQuery(elasticQuery, savedSearchPosition{At: since}, until)
+
+	assert.NotContains(t, restricted, "aggs")
+	assert.Contains(t, elasticQuery, "aggs")
+	assert.EqualValues(t, false, restricted["explain"])
+	assert.EqualValues(t, elasticQuery["post_filter"], restricted["post_filter"])
+	assert.EqualValues(t, []gin.H{{indexedAtField: "asc"}, {indexedIDField: "asc"}}, restricted["sort"])
+	assert.NotContains(t, restricted, "search_after")
+	assert.EqualValues(t, savedSearchMaxHits, restricted["size"])
+
+	boolQuery := restricted["query"].(gin.H)["bool"].(gin.H)
+	assert.EqualValues(t, []interface{}{elasticQuery["query"]}, boolQuery["must"])
+	assert.EqualValues(t, []gin.H{{
+		"range": gin.H{indexedAtField: gin.H{
+			"gt":  "2024-01-01T00:00:00Z",
+			"lte": "2024-02-01T00:00:00Z",
+		}},
+	}}, boolQuery["filter"])
+
+	restricted = newResultsElasticQuery(elasticQuery, savedSearchPosition{At: since, ID: "7"}, until)
+
+	assert.EqualValues(t, []interface{}{since.UnixMilli(), "7"}, restricted["search_after"])
+	boolQuery = restricted["query"].(gin.H)["bool"].(gin.H)
+	assert.EqualValues(t, []gin.H{{
+		"range": gin.H{indexedAtField: gin.H{
+			"gte": "2024-01-01T00:00:00Z",
+			"lte": "2024-02-01T00:00:00Z",
+		}},
+	}}, boolQuery["filter"])
+}
+
+func TestValidateSavedSearch(t *testing.T) {
+	savedSearch := SavedSearch{UserID: "1", Name: "Asthma", Entity: "datasets", Query: Query{QueryString: "asthma"}}
+	assert.Nil(t, validateSavedSearch(savedSearch))
+
+	savedSearch.Entity = "tools"
+	assert.NotNil(t, validateSavedSearch(savedSearch))
+
+	savedSearch.Entity = "dur"
+	savedSearch.UserID = ""
+	assert.NotNil(t, validateSavedSearch(savedSearch))
+}
+
+func TestRunSavedSearch(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	lastRunAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var searchBody, indexedBody string
+	mockElasticResponses(func(req *http.Request) string {
+		switch {
+		case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/saved_searches/_doc/"):
+			return `{"found": true, "_id": "abc", "_source": {"id": "abc", "userId": "1", "name": "Asthma",
+				"entity": "dur", "query": {"query": "asthma"}, "lastRunAt": "2024-01-01T00:00:00Z"}}`
+		case strings.HasPrefix(req.URL.Path, "/datauseregister/_search"):
+			body, _ := io.ReadAll(req.Body)
+			searchBody = string(body)
+			return `{"hits": {"hits": [{"_id": "7", "_score": 1, "_source": {"projectTitle": "Project 7"}}]}}`
+		default:
+			body, _ := io.ReadAll(req.Body)
+			indexedBody = string(body)
+			return `{"result": "updated"}`
+		}
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "abc"}}
+
+	RunSavedSearch(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	var testResp SavedSearchResults
+	json.Unmarshal(w.Body.Bytes(), &testResp)
+	assert.Len(t, testResp.Hits, 1)
+	assert.EqualValues(t, "7", testResp.Hits[0].Id)
+	assert.True(t, testResp.Since.Equal(lastRunAt))
+	assert.True(t, testResp.SavedSearch.LastRunAt.After(lastRunAt))
+	assert.Contains(t, searchBody, `"gt":"2024-01-01T00:00:00Z"`)
+	assert.Contains(t, indexedBody, `"id":"abc"`)
+}
+
+func TestRunSavedSearchFailed(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	indexed := false
+	mockElasticResponses(func(req *http.Request) string {
+		switch {
+		case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/saved_searches/_doc/"):
+			return `{"found": true, "_id": "abc", "_source": {"id": "abc", "userId": "1", "name": "Asthma",
+				"entity": "dur", "query": {"query": "asthma"}, "lastRunAt": "2024-01-01T00:00:00Z"}}`
+		case strings.HasPrefix(req.URL.Path, "/datauseregister/_search"):
+			return `{"error": {"root_cause": [{"type": "search_phase_execution_exception", "reason": "all shards failed"}]}, "status": 503}`
+		default:
+			indexed = true
+			return `{"result": "updated"}`
+		}
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "abc"}}
+
+	RunSavedSearch(c)
+
+	assert.EqualValues(t, http.StatusBadGateway, w.Code)
+	assert.False(t, indexed)
+}
+
+func TestNewSavedSearchHitsTruncated(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	indexedAt := since.Add(time.Second)
+	bodies := []string{}
+	mockElasticResponses(func(req *http.Request) string {
+		body, _ := io.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) > 1 {
+			return `{"hits": {"hits": [{"_id": "last", "sort": [` + fmt.Sprint(indexedAt.UnixMilli()) + `, "last"]}]}}`
+		}
+		// Every document was indexed by the same bulk request, at the same time
+		hits := []string{}
+		for i := 0; i < savedSearchMaxHits; i++ {
+			hits = append(hits, fmt.Sprintf(`{"_id": "d%04d", "sort": [%d, "d%04d"]}`, i, indexedAt.UnixMilli(), i))
+		}
+		return `{"hits": {"hits": [` + strings.Join(hits, ",") + `]}}`
+	})
+	now := time.Now().UTC()
+
+	hits, reached, err := newSavedSearchHits(context.Background(), SavedSearch{Entity: "dur"}, savedSearchPosition{At: since}, now)
+
+	assert.Nil(t, err)
+	assert.Len(t, hits, savedSearchMaxHits)
+	assert.EqualValues(t, fmt.Sprintf("d%04d", savedSearchMaxHits-1), hits[0].Id)
+	assert.EqualValues(t, "d0000", hits[len(hits)-1].Id)
+	assert.True(t, reached.At.Equal(indexedAt), reached)
+	assert.EqualValues(t, fmt.Sprintf("d%04d", savedSearchMaxHits-1), reached.ID)
+
+	// The next run carries on among the documents indexed at the same time
+	hits, reached, err = newSavedSearchHits(context.Background(), SavedSearch{Entity: "dur"}, reached, now)
+
+	assert.Nil(t, err)
+	assert.Len(t, hits, 1)
+	assert.Contains(t, bodies[1], fmt.Sprintf(`"search_after":[%d,"d%04d"]`, indexedAt.UnixMilli(), savedSearchMaxHits-1))
+	assert.True(t, reached.At.Equal(now.Add(-savedSearchIndexLag)), reached)
+	assert.Empty(t, reached.ID)
+}
+
+func TestNewSavedSearchHitsRecent(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	searched := false
+	mockElasticResponses(func(req *http.Request) string {
+		searched = true
+		return `{"hits": {"hits": []}}`
+	})
+	since := savedSearchPosition{At: time.Now().UTC()}
+
+	hits, reached, err := newSavedSearchHits(context.Background(), SavedSearch{Entity: "dur"}, since, since.At.Add(time.Second))
+
+	assert.Nil(t, err)
+	assert.Empty(t, hits)
+	assert.EqualValues(t, since, reached)
+	assert.False(t, searched)
+}
+
+func TestRunSavedSearchNotFound(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	mockElasticResponses(func(req *http.Request) string {
+		return `{"found": false, "_id": "abc"}`
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "abc"}}
+
+	RunSavedSearch(c)
+
+	assert.EqualValues(t, http.StatusNotFound, w.Code)
+}
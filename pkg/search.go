@@ -9,7 +9,6 @@ import (
 	"io"
 	"log"
 	"log/slog"
-	"math"
 	"net/http"
 	"os"
 	"reflect"
@@ -35,14 +34,10 @@ var (
 	searchNoRecords            int
 	searchNoRecordsAggregation int
 	searchNoRecordsSimilar     int
+	searchSuggestionThreshold  int
 	explanationEnabled         bool
-	populationRangesCache      []gin.H
 )
 
-func init() {
-	populationRangesCache = buildPopulationRanges()
-}
-
 func DefineElasticClient() {
 	ElasticClient = elastic.DefaultClient()
 	BigQueryClient = bigqueryclient.DefaultBigQueryClient()
@@ -52,6 +47,7 @@ func DefineElasticClient() {
 	searchNoRecords, _ = strconv.Atoi(os.Getenv("SEARCH_NO_RECORDS"))
 	searchNoRecordsAggregation, _ = strconv.Atoi(os.Getenv("SEARCH_NO_RECORDS_AGGREGATION"))
 	searchNoRecordsSimilar, _ = strconv.Atoi(os.Getenv("SEARCH_NO_RECORDS_SIMILAR_SEARCH"))
+	searchSuggestionThreshold, _ = strconv.Atoi(os.Getenv("SEARCH_SUGGESTION_THRESHOLD"))
 	_, explanationEnabled = os.LookupEnv("SEARCH_EXPLANATION_EXTRACTOR")
 }
 
@@ -85,11 +81,19 @@ type Query struct {
 	Filters      map[string]map[string]interface{} `json:"filters"`
 	Aggregations []map[string]interface{}          `json:"aggs"`
 	IDs          []string                          `json:"ids"`
+	Providers    []string                          `json:"providers"`
+	Fields       []string                          `json:"fields"`
+	Exclude      []string                          `json:"exclude"`
+	View         string                            `json:"view"`
+	Highlight    HighlightOptions                  `json:"highlight"`
+	Mode         string                            `json:"mode"`
+	Blend        BlendOptions                      `json:"blend"`
 }
 
-type SimilarSearch struct {
-	ID string `json:"id"`
-}
+const (
+	viewFull    = "full"
+	viewSummary = "summary"
+)
 
 // SearchResponse represents the expected structure of results returned by ElasticSearch
 type SearchResponse struct {
@@ -98,6 +102,7 @@ type SearchResponse struct {
 	Shards       map[string]interface{} `json:"_shards"`
 	Hits         HitsField              `json:"hits"`
 	Aggregations map[string]interface{} `json:"aggregations"`
+	Suggestions  []SpellingSuggestion   `json:"suggestions,omitempty"`
 }
 
 type HitsField struct {
@@ -107,11 +112,11 @@ type HitsField struct {
 }
 
 type Hit struct {
-	Explanation map[string]interface{} `json:"_explanation"`
+	Explanation map[string]interface{} `json:"_explanation,omitempty"`
 	Id          string                 `json:"_id"`
 	Score       float64                `json:"_score"`
 	Source      map[string]interface{} `json:"_source"`
-	Highlight   map[string][]string    `json:"highlight"`
+	Highlight   map[string][]string    `json:"highlight,omitempty"`
 }
 
 type SearchErrorResponse struct {
@@ -222,8 +227,13 @@ func EnsureTableExists() error {
 
 // executeSearch is the shared implementation for all entity index searches.
 // It encodes the query, calls Elastic, parses the response, and applies
-// explanation stripping and aggregation flattening.
+// explanation stripping and aggregation flattening. Dataset searches returning
+// few hits are followed up with a request for spelling suggestions.
 func executeSearch(ctx context.Context, index string, elasticQuery gin.H, query Query, entityType string, searchUuid string) SearchResponse {
+	if source := sourceFilter(index, query); source != nil {
+		elasticQuery["_source"] = source
+	}
+
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(elasticQuery); err != nil {
 		slog.Debug(fmt.Sprintf("Failed to encode elastic query with %s", err.Error()))
@@ -264,78 +274,256 @@ func executeSearch(ctx context.Context, index string, elasticQuery gin.H, query
 		slog.Debug(fmt.Sprintf("Null result elastic query: %v", elasticQuery))
 	}
 
+	return processSearchResponse(ctx, index, elasticResp, query, entityType, searchUuid)
+}
+
+// processSearchResponse prepares the response of a search of index for the
+// caller, stripping explanations, flattening aggregations and adding spelling
+// suggestions.
+func processSearchResponse(ctx context.Context, index string, elasticResp SearchResponse, query Query, entityType string, searchUuid string) SearchResponse {
 	stripExplanation(elasticResp, query, entityType, searchUuid)
 	elasticResp.Aggregations = flattenAggs(elasticResp)
+	if spellingSuggestionsEnabled(elasticResp, query, entityType) {
+		elasticResp.Suggestions = spellingSuggestions(ctx, index, query.QueryString)
+	}
 	return elasticResp
 }
 
-// SearchGeneric performs searches across all entity indices concurrently.
-// A 10-second timeout is applied; if any index is unresponsive the request
-// returns 504 rather than hanging indefinitely.
-// Results are returned grouped by entity type.
+// validateQuery checks the options of a search of entityName which cannot be
+// checked when binding the request. An empty entityName validates a search
+// of every entity.
+func validateQuery(query Query, entityName string) error {
+	if query.View != "" && query.View != viewFull && query.View != viewSummary {
+		return fmt.Errorf("view must be one of %s or %s", viewSummary, viewFull)
+	}
+	if err := validateFilters(query.Filters); err != nil {
+		return err
+	}
+	if err := validateDateAggregations(query.Aggregations); err != nil {
+		return err
+	}
+	if err := validateGeoAggregations(query.Aggregations); err != nil {
+		return err
+	}
+	if err := validateBlend(query); err != nil {
+		return err
+	}
+
+	entities := []searchEntity{}
+	for name, entity := range searchEntities {
+		if entityName == "" || name == entityName {
+			entities = append(entities, entity)
+		}
+	}
+	return validateHighlight(query.Highlight, entities...)
+}
+
+// sourceFilter returns the _source option limiting the fields returned for
+// each hit of a search of index, or nil if the full documents are wanted.
+// Fields given in the query take precedence over the summary fields of the
+// entity, and the excluded fields are removed from either.
+func sourceFilter(index string, query Query) gin.H {
+	includes := query.Fields
+	if len(includes) == 0 && query.View == viewSummary {
+		if entity, ok := entityByIndex(index); ok {
+			includes = entity.SummaryFields
+		}
+	}
+	if len(includes) == 0 && len(query.Exclude) == 0 {
+		return nil
+	}
+
+	source := gin.H{}
+	if len(includes) > 0 {
+		source["includes"] = includes
+	}
+	if len(query.Exclude) > 0 {
+		source["excludes"] = query.Exclude
+	}
+	return source
+}
+
+// compiledQuery converts the body of a search into a single query matching
+// its hits, for uses other than returning a page of results. The search's
+// filters, applied after the query as a post_filter so that facets are
+// counted before filtering, become part of the query, and the random scoring
+// of searches without a query string is dropped.
+func compiledQuery(elasticQuery gin.H, query Query) gin.H {
+	mainQuery := elasticQuery["query"]
+	if query.QueryString == "" {
+		mainQuery = gin.H{"match_all": gin.H{}}
+		if len(query.IDs) > 0 {
+			mainQuery = gin.H{"terms": gin.H{"_id": query.IDs}}
+		}
+	}
+	boolQuery := gin.H{"must": []interface{}{mainQuery}}
+	if postFilter, ok := elasticQuery["post_filter"]; ok {
+		boolQuery["filter"] = []interface{}{postFilter}
+	}
+	return gin.H{"bool": boolQuery}
+}
+
+// searchIndex runs elasticQuery against index and decodes the response,
+// without any of the post-processing applied to entity searches.
+func searchIndex(ctx context.Context, index string, elasticQuery gin.H) SearchResponse {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(elasticQuery); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to encode elastic query with %s", err.Error()))
+	}
+
+	response, err := ElasticClient.Search(
+		ElasticClient.Search.WithContext(ctx),
+		ElasticClient.Search.WithIndex(index),
+		ElasticClient.Search.WithBody(&buf),
+	)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to execute elastic query on index %s: %s", index, err.Error()))
+		return SearchResponse{}
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Failed to read elastic response with %s", err.Error()))
+	}
+
+	var elasticResp SearchResponse
+	if err := json.Unmarshal(body, &elasticResp); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to unmarshal elastic response with %s", err.Error()))
+	}
+
+	if elasticResp.Hits.Hits == nil {
+		slog.Warn("Hits from elastic are null, query may be malformed")
+		slog.Debug(fmt.Sprintf("Null result elastic query: %v", elasticQuery))
+	}
+
+	return elasticResp
+}
+
+// searchIndexInto runs elasticQuery against index like searchIndex, for
+// callers which must know whether the search failed. The response is decoded
+// into result, and an error is returned if elastic could not be reached or
+// the search failed.
+func searchIndexInto(ctx context.Context, index string, elasticQuery gin.H, result interface{}) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(elasticQuery); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to encode elastic query with %s", err.Error()))
+		return err
+	}
+
+	body, err := readElasticResponse(ElasticClient.Search(
+		ElasticClient.Search.WithContext(ctx),
+		ElasticClient.Search.WithIndex(index),
+		ElasticClient.Search.WithBody(&buf),
+	))
+	if err != nil {
+		return err
+	}
+	if err := searchError(body); err != nil {
+		slog.Warn(fmt.Sprintf("Search of index %s failed: %s", index, err.Error()))
+		return fmt.Errorf("search of index %s failed: %w", index, err)
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to unmarshal elastic response with %s", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// genericSearchResult is the result of the search of one entity by
+// SearchGeneric, with the error of the search if it failed.
+type genericSearchResult struct {
+	SearchResponse
+	Error string `json:"error,omitempty"`
+}
+
+// genericSearch describes the search of an entity run by SearchGeneric, with
+// the key of its results in the response and its entity type in analytics.
+type genericSearch struct {
+	Entity     string
+	ResultKey  string
+	EntityType string
+}
+
+// genericSearches are the searches run by SearchGeneric, in order.
+var genericSearches = []genericSearch{
+	{Entity: "datasets", ResultKey: "dataset", EntityType: "dataset"},
+	{Entity: "tools", ResultKey: "tool", EntityType: "tool"},
+	{Entity: "collections", ResultKey: "collection", EntityType: "collection"},
+	{Entity: "dur", ResultKey: "dataUseRegister", EntityType: "dur"},
+	{Entity: "publications", ResultKey: "publication", EntityType: "publication"},
+	{Entity: "data_providers", ResultKey: "dataProvider", EntityType: "dataProvider"},
+	{Entity: "data_custodian_networks", ResultKey: "datacustodiannetwork", EntityType: "datacustodiannetwork"},
+}
+
+// SearchGeneric performs searches across all entity indices in a single multi
+// search request. A 10-second timeout is applied; if elastic is unresponsive
+// the request returns 504 rather than hanging indefinitely. A failed search of
+// one index is logged and returns no hits without failing the others.
+// Results are returned grouped by entity type, or with "mode": "blended" as a
+// single list ranked across entity types.
 func SearchGeneric(c *gin.Context) {
 	var query Query
 	if err := c.BindJSON(&query); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := validateQuery(query, ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	searchUuid := uuid.New().String()
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 	defer cancel()
 
-	// Buffered channels so goroutines can send and exit even if we return early.
-	datasetResults := make(chan SearchResponse, 1)
-	toolResults := make(chan SearchResponse, 1)
-	collectionResults := make(chan SearchResponse, 1)
-	dataUseResults := make(chan SearchResponse, 1)
-	publicationResults := make(chan SearchResponse, 1)
-	dataProviderResults := make(chan SearchResponse, 1)
-	dataCustodianNetworkResults := make(chan SearchResponse, 1)
-
-	go func() {
-		datasetResults <- executeSearch(ctx, "dataset", datasetElasticConfig(query), query, "dataset", searchUuid)
-	}()
-	go func() {
-		toolResults <- executeSearch(ctx, "tool", toolsElasticConfig(query), query, "tool", searchUuid)
-	}()
-	go func() {
-		collectionResults <- executeSearch(ctx, "collection", collectionsElasticConfig(query), query, "collection", searchUuid)
-	}()
-	go func() {
-		dataUseResults <- executeSearch(ctx, "datauseregister", dataUseElasticConfig(query), query, "dur", searchUuid)
-	}()
-	go func() {
-		publicationResults <- executeSearch(ctx, "publication", publicationElasticConfig(query), query, "publication", searchUuid)
-	}()
-	go func() {
-		dataProviderResults <- executeSearch(ctx, "dataprovider", dataProviderElasticConfig(query), query, "dataProvider", searchUuid)
-	}()
-	go func() {
-		dataCustodianNetworkResults <- executeSearch(ctx, "datacustodiannetwork", dataCustodianNetworkElasticConfig(query), query, "datacustodiannetwork", searchUuid)
-	}()
+	if query.Mode == modeBlended {
+		results, err := blendedSearch(ctx, query)
+		if err != nil {
+			if ctx.Err() != nil {
+				slog.Warn("SearchGeneric timed out waiting for results")
+				c.JSON(http.StatusGatewayTimeout, gin.H{"error": "search timed out"})
+				return
+			}
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, results)
+		return
+	}
 
-	results := make(map[string]interface{})
-	for i := 0; i < 7; i++ {
-		select {
-		case <-ctx.Done():
+	requests := make([]multiSearchRequest, len(genericSearches))
+	for i, search := range genericSearches {
+		index := searchEntities[search.Entity].Index
+		elasticQuery := entityElasticConfigs[search.Entity](query)
+		if source := sourceFilter(index, query); source != nil {
+			elasticQuery["_source"] = source
+		}
+		requests[i] = multiSearchRequest{Index: index, Body: elasticQuery}
+	}
+
+	responses, err := multiSearch(ctx, requests)
+	if err != nil {
+		if ctx.Err() != nil {
 			slog.Warn("SearchGeneric timed out waiting for results")
 			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "search timed out"})
 			return
-		case datasets := <-datasetResults:
-			results["dataset"] = datasets
-		case tools := <-toolResults:
-			results["tool"] = tools
-		case collections := <-collectionResults:
-			results["collection"] = collections
-		case data_uses := <-dataUseResults:
-			results["dataUseRegister"] = data_uses
-		case publications := <-publicationResults:
-			results["publication"] = publications
-		case dataProviders := <-dataProviderResults:
-			results["dataProvider"] = dataProviders
-		case dataCustodianNetworks := <-dataCustodianNetworkResults:
-			results["datacustodiannetwork"] = dataCustodianNetworks
+		}
+		slog.Error(fmt.Sprintf("Failed to execute generic search: %s", err.Error()))
+		responses = make([]multiSearchResult, len(requests))
+		for i := range responses {
+			responses[i].Err = err
+		}
+	}
+
+	results := make(map[string]interface{})
+	for i, search := range genericSearches {
+		if responses[i].Err != nil {
+			results[search.ResultKey] = genericSearchResult{Error: responses[i].Err.Error()}
+			continue
+		}
+		results[search.ResultKey] = genericSearchResult{
+			SearchResponse: processSearchResponse(ctx, requests[i].Index, responses[i].Response, query, search.EntityType, searchUuid),
 		}
 	}
 
@@ -348,6 +536,10 @@ func DatasetSearch(c *gin.Context) {
 		slog.Debug(fmt.Sprintf("Failed to interpret search query with %s", err.Error()))
 		return
 	}
+	if err := validateQuery(query, "datasets"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	searchUuid := uuid.New().String()
 	results := executeSearch(c.Request.Context(), "dataset", datasetElasticConfig(query), query, "dataset", searchUuid)
 	go BQUpload(query, results, "dataset", searchUuid)
@@ -441,70 +633,13 @@ func datasetElasticConfig(query Query) gin.H {
 		}
 	}
 
-	mustFilters := []gin.H{}
-	mustFiltersByKey := map[string]gin.H{}
-	for key, terms := range query.Filters["dataset"] {
-		var filter gin.H
-		if key == "dateRange" {
-			filter = gin.H{
-				"bool": gin.H{
-					"must": []gin.H{
-						{"range": gin.H{"startDate": gin.H{"lte": terms.([]interface{})[1]}}},
-						{"range": gin.H{"endDate": gin.H{"gte": terms.([]interface{})[0]}}},
-					},
-				},
-			}
-		} else if key == "populationSize" {
-			includeNull := terms.(map[string]interface{})["includeUnreported"].(bool)
-			from := terms.(map[string]interface{})["from"]
-			to := terms.(map[string]interface{})["to"]
-			if includeNull {
-				filter = gin.H{
-					"bool": gin.H{
-						"should": []gin.H{
-							{"range": gin.H{key: gin.H{"gte": from, "lte": to}}},
-							{"term": gin.H{"populationSize": -1}},
-						},
-					},
-				}
-			} else {
-				filter = gin.H{
-					"bool": gin.H{
-						"must": []gin.H{
-							{"range": gin.H{key: gin.H{"gte": from, "lte": to}}},
-						},
-					},
-				}
-			}
-		} else {
-			filters := []gin.H{}
-			for _, t := range terms.([]interface{}) {
-				filters = append(filters, gin.H{"term": gin.H{key: t}})
-			}
-			filter = gin.H{"bool": gin.H{"should": filters}}
-		}
-		mustFilters = append(mustFilters, filter)
-		mustFiltersByKey[key] = filter
-	}
+	mustFilters, mustFiltersByKey := buildFilters(query.Filters["dataset"])
 
 	response := gin.H{
-		"size":    searchNoRecords,
-		"query":   mainQuery,
-		"explain": explanationEnabled,
-		"highlight": gin.H{
-			"fields": gin.H{
-				"description": gin.H{
-					"boundary_scanner": "sentence",
-					"fragment_size":    0,
-					"no_match_size":    0,
-				},
-				"abstract": gin.H{
-					"boundary_scanner": "sentence",
-					"fragment_size":    0,
-					"no_match_size":    0,
-				},
-			},
-		},
+		"size":        searchNoRecords,
+		"query":       mainQuery,
+		"explain":     explanationEnabled,
+		"highlight":   highlightConfig(searchEntities["datasets"], query.Highlight),
 		"post_filter": gin.H{"bool": gin.H{"must": mustFilters}},
 		"aggs":        buildAggregations(query, mustFiltersByKey),
 	}
@@ -520,6 +655,10 @@ func ToolSearch(c *gin.Context) {
 		slog.Debug(fmt.Sprintf("Failed to interpret search query with %s", err.Error()))
 		return
 	}
+	if err := validateQuery(query, "tools"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	searchUuid := uuid.New().String()
 	results := executeSearch(c.Request.Context(), "tool", toolsElasticConfig(query), query, "tool", searchUuid)
 	go BQUpload(query, results, "tool", searchUuid)
@@ -608,36 +747,13 @@ func toolsElasticConfig(query Query) gin.H {
 		}
 	}
 
-	mustFilters := []gin.H{}
-	mustFiltersByKey := map[string]gin.H{}
-	for key, terms := range query.Filters["tool"] {
-		filters := []gin.H{}
-		for _, t := range terms.([]interface{}) {
-			filters = append(filters, gin.H{"term": gin.H{key: t}})
-		}
-		filter := gin.H{"bool": gin.H{"should": filters}}
-		mustFilters = append(mustFilters, filter)
-		mustFiltersByKey[key] = filter
-	}
+	mustFilters, mustFiltersByKey := buildFilters(query.Filters["tool"])
 
 	response := gin.H{
-		"size":    searchNoRecords,
-		"query":   mainQuery,
-		"explain": explanationEnabled,
-		"highlight": gin.H{
-			"fields": gin.H{
-				"name": gin.H{
-					"boundary_scanner": "sentence",
-					"fragment_size":    0,
-					"no_match_size":    0,
-				},
-				"description": gin.H{
-					"boundary_scanner": "sentence",
-					"fragment_size":    0,
-					"no_match_size":    0,
-				},
-			},
-		},
+		"size":        searchNoRecords,
+		"query":       mainQuery,
+		"explain":     explanationEnabled,
+		"highlight":   highlightConfig(searchEntities["tools"], query.Highlight),
 		"post_filter": gin.H{"bool": gin.H{"must": mustFilters}},
 		"aggs":        buildAggregations(query, mustFiltersByKey),
 	}
@@ -653,6 +769,10 @@ func CollectionSearch(c *gin.Context) {
 		slog.Debug(fmt.Sprintf("Failed to interpret search query with %s", err.Error()))
 		return
 	}
+	if err := validateQuery(query, "collections"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	searchUuid := uuid.New().String()
 	results := executeSearch(c.Request.Context(), "collection", collectionsElasticConfig(query), query, "collection", searchUuid)
 	go BQUpload(query, results, "collection", searchUuid)
@@ -741,41 +861,13 @@ func collectionsElasticConfig(query Query) gin.H {
 		}
 	}
 
-	mustFilters := []gin.H{}
-	mustFiltersByKey := map[string]gin.H{}
-	for key, terms := range query.Filters["collection"] {
-		filters := []gin.H{}
-		for _, t := range terms.([]interface{}) {
-			filters = append(filters, gin.H{"term": gin.H{key: t}})
-		}
-		filter := gin.H{"bool": gin.H{"should": filters}}
-		mustFilters = append(mustFilters, filter)
-		mustFiltersByKey[key] = filter
-	}
+	mustFilters, mustFiltersByKey := buildFilters(query.Filters["collection"])
 
 	response := gin.H{
-		"size":    searchNoRecords,
-		"query":   mainQuery,
-		"explain": explanationEnabled,
-		"highlight": gin.H{
-			"fields": gin.H{
-				"description": gin.H{
-					"boundary_scanner": "sentence",
-					"fragment_size":    0,
-					"no_match_size":    0,
-				},
-				"name": gin.H{
-					"boundary_scanner": "sentence",
-					"fragment_size":    0,
-					"no_match_size":    0,
-				},
-				"keywords": gin.H{
-					"boundary_scanner": "sentence",
-					"fragment_size":    0,
-					"no_match_size":    0,
-				},
-			},
-		},
+		"size":        searchNoRecords,
+		"query":       mainQuery,
+		"explain":     explanationEnabled,
+		"highlight":   highlightConfig(searchEntities["collections"], query.Highlight),
 		"post_filter": gin.H{"bool": gin.H{"must": mustFilters}},
 		"aggs":        buildAggregations(query, mustFiltersByKey),
 	}
@@ -791,6 +883,10 @@ func DataUseSearch(c *gin.Context) {
 		slog.Debug(fmt.Sprintf("Failed to interpret search query with %s", err.Error()))
 		return
 	}
+	if err := validateQuery(query, "dur"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	searchUuid := uuid.New().String()
 	results := executeSearch(c.Request.Context(), "datauseregister", dataUseElasticConfig(query), query, "dur", searchUuid)
 	go BQUpload(query, results, "datauseregister", searchUuid)
@@ -881,31 +977,13 @@ func dataUseElasticConfig(query Query) gin.H {
 		}
 	}
 
-	mustFilters := []gin.H{}
-	mustFiltersByKey := map[string]gin.H{}
-	for key, terms := range query.Filters["dataUseRegister"] {
-		filters := []gin.H{}
-		for _, t := range terms.([]interface{}) {
-			filters = append(filters, gin.H{"term": gin.H{key: t}})
-		}
-		filter := gin.H{"bool": gin.H{"should": filters}}
-		mustFilters = append(mustFilters, filter)
-		mustFiltersByKey[key] = filter
-	}
+	mustFilters, mustFiltersByKey := buildFilters(query.Filters["dataUseRegister"])
 
 	response := gin.H{
-		"size":    searchNoRecords,
-		"query":   mainQuery,
-		"explain": explanationEnabled,
-		"highlight": gin.H{
-			"fields": gin.H{
-				"laySummary": gin.H{
-					"boundary_scanner": "sentence",
-					"fragment_size":    0,
-					"no_match_size":    0,
-				},
-			},
-		},
+		"size":        searchNoRecords,
+		"query":       mainQuery,
+		"explain":     explanationEnabled,
+		"highlight":   highlightConfig(searchEntities["dur"], query.Highlight),
 		"post_filter": gin.H{"bool": gin.H{"must": mustFilters}},
 		"aggs":        buildAggregations(query, mustFiltersByKey),
 	}
@@ -921,6 +999,10 @@ func PublicationSearch(c *gin.Context) {
 		slog.Debug(fmt.Sprintf("Failed to interpret search query with %s", err.Error()))
 		return
 	}
+	if err := validateQuery(query, "publications"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	searchUuid := uuid.New().String()
 	results := executeSearch(c.Request.Context(), "publication", publicationElasticConfig(query), query, "publication", searchUuid)
 	go BQUpload(query, results, "publication", searchUuid)
@@ -1011,48 +1093,13 @@ func publicationElasticConfig(query Query) gin.H {
 		}
 	}
 
-	mustFilters := []gin.H{}
-	mustFiltersByKey := map[string]gin.H{}
-	for key, terms := range query.Filters["paper"] {
-		var filter gin.H
-		if key == "publicationDate" {
-			filter = gin.H{
-				"bool": gin.H{
-					"must": []gin.H{
-						{"range": gin.H{"publicationDate": gin.H{"gte": terms.([]interface{})[0]}}},
-						{"range": gin.H{"publicationDate": gin.H{"lte": terms.([]interface{})[1]}}},
-					},
-				},
-			}
-		} else {
-			filters := []gin.H{}
-			for _, t := range terms.([]interface{}) {
-				filters = append(filters, gin.H{"term": gin.H{key: t}})
-			}
-			filter = gin.H{"bool": gin.H{"should": filters}}
-		}
-		mustFilters = append(mustFilters, filter)
-		mustFiltersByKey[key] = filter
-	}
+	mustFilters, mustFiltersByKey := buildFilters(query.Filters["paper"])
 
 	response := gin.H{
-		"size":    searchNoRecords,
-		"query":   mainQuery,
-		"explain": explanationEnabled,
-		"highlight": gin.H{
-			"fields": gin.H{
-				"title": gin.H{
-					"boundary_scanner": "sentence",
-					"fragment_size":    0,
-					"no_match_size":    0,
-				},
-				"abstract": gin.H{
-					"boundary_scanner": "sentence",
-					"fragment_size":    0,
-					"no_match_size":    0,
-				},
-			},
-		},
+		"size":        searchNoRecords,
+		"query":       mainQuery,
+		"explain":     explanationEnabled,
+		"highlight":   highlightConfig(searchEntities["publications"], query.Highlight),
 		"post_filter": gin.H{"bool": gin.H{"must": mustFilters}},
 		"aggs":        buildAggregations(query, mustFiltersByKey),
 	}
@@ -1068,6 +1115,10 @@ func DataProviderSearch(c *gin.Context) {
 		slog.Debug(fmt.Sprintf("Failed to interpret search query with %s", err.Error()))
 		return
 	}
+	if err := validateQuery(query, "data_providers"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	searchUuid := uuid.New().String()
 	results := executeSearch(c.Request.Context(), "dataprovider", dataProviderElasticConfig(query), query, "dataProvider", searchUuid)
 	go BQUpload(query, results, "dataprovider", searchUuid)
@@ -1157,22 +1208,13 @@ func dataProviderElasticConfig(query Query) gin.H {
 		}
 	}
 
-	mustFilters := []gin.H{}
-	mustFiltersByKey := map[string]gin.H{}
-	for key, terms := range query.Filters["dataProvider"] {
-		filters := []gin.H{}
-		for _, t := range terms.([]interface{}) {
-			filters = append(filters, gin.H{"term": gin.H{key: t}})
-		}
-		filter := gin.H{"bool": gin.H{"should": filters}}
-		mustFilters = append(mustFilters, filter)
-		mustFiltersByKey[key] = filter
-	}
+	mustFilters, mustFiltersByKey := buildFilters(query.Filters["dataProvider"])
 
 	response := gin.H{
 		"size":        searchNoRecords,
 		"query":       mainQuery,
 		"explain":     explanationEnabled,
+		"highlight":   highlightConfig(searchEntities["data_providers"], query.Highlight),
 		"post_filter": gin.H{"bool": gin.H{"must": mustFilters}},
 		"aggs":        buildAggregations(query, mustFiltersByKey),
 	}
@@ -1188,6 +1230,10 @@ func DataCustodianNetworkSearch(c *gin.Context) {
 		slog.Debug(fmt.Sprintf("Failed to interpret search query with %s", err.Error()))
 		return
 	}
+	if err := validateQuery(query, "data_custodian_networks"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	searchUuid := uuid.New().String()
 	results := executeSearch(c.Request.Context(), "datacustodiannetwork", dataCustodianNetworkElasticConfig(query), query, "datacustodiannetwork", searchUuid)
 	go BQUpload(query, results, "datacustodiannetwork", searchUuid)
@@ -1245,45 +1291,162 @@ func dataCustodianNetworkElasticConfig(query Query) gin.H {
 		}
 	}
 
+	mustFilters, mustFiltersByKey := buildFilters(query.Filters["datacustodiannetwork"])
+
+	return gin.H{
+		"size":        searchNoRecords,
+		"query":       mainQuery,
+		"explain":     explanationEnabled,
+		"highlight":   highlightConfig(searchEntities["data_custodian_networks"], query.Highlight),
+		"post_filter": gin.H{"bool": gin.H{"must": mustFilters}},
+		"aggs":        buildAggregations(query, mustFiltersByKey),
+	}
+}
+
+// buildFilters converts the filters for one entity type in a Query into
+// elastic filter clauses. The clauses are returned both as a list and keyed by
+// field, so that buildAggregations can leave out the filter for each facet.
+func buildFilters(entityFilters map[string]interface{}) ([]gin.H, map[string]gin.H) {
 	mustFilters := []gin.H{}
 	mustFiltersByKey := map[string]gin.H{}
-	for key, terms := range query.Filters["datacustodiannetwork"] {
-		filters := []gin.H{}
-		for _, t := range terms.([]interface{}) {
-			filters = append(filters, gin.H{"term": gin.H{key: t}})
+	for key, terms := range entityFilters {
+		var filter gin.H
+		if facet, ok := dateFacets[key]; ok {
+			from, to, err := dateBounds(terms)
+			if err != nil {
+				slog.Debug(fmt.Sprintf("Ignoring %s filter: %s", key, err.Error()))
+				continue
+			}
+			filter = dateFilter(facet, from, to)
+		} else if facet, ok := numericFacets[key]; ok {
+			filter = numericFilter(key, facet, terms)
+		} else if facet, ok := geoFacets[key]; ok {
+			geo, err := geoFilter(facet, terms)
+			if err != nil {
+				slog.Debug(fmt.Sprintf("Ignoring %s filter: %s", key, err.Error()))
+				continue
+			}
+			filter = geo
+		} else {
+			filter = termsFilter(key, terms)
 		}
-		filter := gin.H{"bool": gin.H{"should": filters}}
 		mustFilters = append(mustFilters, filter)
 		mustFiltersByKey[key] = filter
 	}
+	return mustFilters, mustFiltersByKey
+}
 
-	return gin.H{
-		"size":    searchNoRecords,
-		"query":   mainQuery,
-		"explain": explanationEnabled,
-		"highlight": gin.H{
-			"fields": gin.H{
-				"name": gin.H{
-					"boundary_scanner": "sentence",
-					"fragment_size":    0,
-					"no_match_size":    0,
-				},
-				"summary": gin.H{
-					"boundary_scanner": "sentence",
-					"fragment_size":    0,
-					"no_match_size":    0,
-				},
-			},
-		},
-		"post_filter": gin.H{"bool": gin.H{"must": mustFilters}},
-		"aggs":        buildAggregations(query, mustFiltersByKey),
+// Modes combining the values of a terms filter.
+const (
+	filterAny  = "any"
+	filterAll  = "all"
+	filterNone = "none"
+)
+
+// termsFilter builds the filter clause for key from either a list of values,
+// any of which must match, or an object of the form
+//
+//	{"mode": "any" | "all" | "none", "values": [...], "prefix": "...", "exists": true, "missing": true}
+//
+// where mode (default "any") combines the values and the prefix, and every
+// part given must hold.
+func termsFilter(key string, terms interface{}) gin.H {
+	options, ok := terms.(map[string]interface{})
+	if !ok {
+		options = map[string]interface{}{"values": terms}
+	}
+
+	clauses := []gin.H{}
+	values, _ := options["values"].([]interface{})
+	for _, t := range values {
+		clauses = append(clauses, gin.H{"term": gin.H{key: t}})
+	}
+	if prefix, ok := options["prefix"].(string); ok && prefix != "" {
+		clauses = append(clauses, gin.H{"prefix": gin.H{key: prefix}})
+	}
+
+	parts := []gin.H{}
+	if len(clauses) > 0 {
+		switch filterMode(terms) {
+		case filterAll:
+			parts = append(parts, gin.H{"bool": gin.H{"must": clauses}})
+		case filterNone:
+			parts = append(parts, gin.H{"bool": gin.H{"must_not": clauses}})
+		default:
+			parts = append(parts, gin.H{"bool": gin.H{"should": clauses}})
+		}
+	}
+	if exists, _ := options["exists"].(bool); exists {
+		parts = append(parts, gin.H{"exists": gin.H{"field": key}})
+	}
+	if missing, _ := options["missing"].(bool); missing {
+		parts = append(parts, gin.H{"bool": gin.H{"must_not": []gin.H{{"exists": gin.H{"field": key}}}}})
+	}
+
+	switch len(parts) {
+	case 0:
+		return gin.H{"bool": gin.H{"should": clauses}}
+	case 1:
+		return parts[0]
+	default:
+		return gin.H{"bool": gin.H{"must": parts}}
 	}
 }
 
+// filterMode returns the mode of a terms filter, which is "any" unless the
+// filter is an object giving another mode.
+func filterMode(terms interface{}) string {
+	if options, ok := terms.(map[string]interface{}); ok {
+		if mode, ok := options["mode"].(string); ok && mode != "" {
+			return mode
+		}
+	}
+	return filterAny
+}
+
+// validateFilters checks the bounds of each date and numeric filter, each
+// geographic filter and the mode of each terms filter in a Query.
+func validateFilters(filters map[string]map[string]interface{}) error {
+	for filterType, entityFilters := range filters {
+		for key, terms := range entityFilters {
+			if _, ok := dateFacets[key]; ok {
+				if _, _, err := dateBounds(terms); err != nil {
+					return fmt.Errorf("%s filter %s: %s", filterType, key, err.Error())
+				}
+				continue
+			}
+			if facet, ok := geoFacets[key]; ok {
+				if _, err := geoFilter(facet, terms); err != nil {
+					return fmt.Errorf("%s filter %s: %s", filterType, key, err.Error())
+				}
+				continue
+			}
+			if _, ok := numericFacets[key]; ok {
+				if err := validateNumericFilter(terms); err != nil {
+					return fmt.Errorf("%s filter %s: %s", filterType, key, err.Error())
+				}
+				continue
+			}
+			switch terms.(type) {
+			case []interface{}, map[string]interface{}:
+			default:
+				return fmt.Errorf("%s filter %s must be a list of values or an object", filterType, key)
+			}
+			mode := filterMode(terms)
+			if mode != filterAny && mode != filterAll && mode != filterNone {
+				return fmt.Errorf("mode of %s filter %s must be one of %s, %s or %s", filterType, key, filterAny, filterAll, filterNone)
+			}
+		}
+	}
+	return nil
+}
+
 // buildAggregations constructs the "aggs" part of an elastic search query.
 // mustFiltersByKey maps each filter's field key to its gin.H filter clause.
 // For each aggregation, all filters except the one for that field are applied,
-// enabling faceted counts that reflect the current selection state.
+// enabling faceted counts that reflect the current selection state. A filter
+// in "all" mode is kept for its own field too, since each further value
+// selected narrows the results rather than widening them.
 func buildAggregations(query Query, mustFiltersByKey map[string]gin.H) gin.H {
 	agg1 := gin.H{}
 	for _, agg := range query.Aggregations {
@@ -1293,25 +1456,29 @@ func buildAggregations(query Query, mustFiltersByKey map[string]gin.H) gin.H {
 			continue
 		}
 		aggInner := gin.H{}
-		if k == "dateRange" {
-			aggInner["startDate"] = gin.H{"min": gin.H{"field": "startDate"}}
-			aggInner["endDate"] = gin.H{"max": gin.H{"field": "endDate"}}
-		} else if k == "publicationDate" {
-			aggInner["startDate"] = gin.H{"min": gin.H{"field": "publicationDate"}}
-			aggInner["endDate"] = gin.H{"max": gin.H{"field": "publicationDate"}}
-		} else if k == "populationSize" {
-			aggInner[k] = gin.H{
-				"range": gin.H{"field": k, "ranges": populationRangesCache},
+		if facet, ok := dateFacets[k]; ok {
+			interval, _ := agg["interval"].(string)
+			aggInner = dateAggregations(k, facet, interval)
+		} else if facet, ok := numericFacets[k]; ok {
+			for name, numericAgg := range numericFacetAggs(k, facet) {
+				aggInner[name] = numericAgg
 			}
+		} else if facet, ok := hierarchicalFacets[k]; ok {
+			aggInner[k] = hierarchyAggregation(k, facet.Depth, searchNoRecordsAggregation)
+		} else if facet, ok := geoFacets[k]; ok {
+			aggInner[k] = geoAggregation(facet, geoPrecision(agg))
 		} else {
-			aggInner[k] = gin.H{"terms": gin.H{"field": k, "size": searchNoRecordsAggregation}}
+			order, _ := agg["order"].(string)
+			aggInner[k] = termsAggregation(k, searchNoRecordsAggregation, order)
 		}
 
 		// Include all active filters except the one for this aggregation key,
 		// so that facet counts reflect the full unfiltered set for each facet.
+		filterType, _ := agg["type"].(string)
+		conjunctive := filterMode(query.Filters[filterType][k]) == filterAll
 		filters := []gin.H{}
 		for filterKey, fil := range mustFiltersByKey {
-			if filterKey != k {
+			if filterKey != k || conjunctive {
 				filters = append(filters, fil)
 			}
 		}
@@ -1323,15 +1490,26 @@ func buildAggregations(query Query, mustFiltersByKey map[string]gin.H) gin.H {
 	return agg1
 }
 
-func buildPopulationRanges() []gin.H {
-	ranges := []gin.H{{"from": -1.0, "to": 1.0, "key": "Unreported"}}
-	for i := 0; i < 9; i++ {
-		ranges = append(ranges, gin.H{
-			"from": math.Pow(10, float64(i)),
-			"to":   math.Pow(10, float64(i+1)),
-		})
-	}
-	return ranges
+// Orders of the buckets of a terms aggregation. Buckets are ordered by count
+// unless requested otherwise.
+const (
+	orderByCount      = "count"
+	orderAlphabetical = "alphabetical"
+)
+
+// termsAggregation defines a terms aggregation of the values of field, with
+// the buckets ordered by count or alphabetically. Elastic reports the number
+// of documents with values beyond size in sum_other_doc_count.
+func termsAggregation(field string, size int, order string) gin.H {
+	terms := gin.H{"field": field, "size": size}
+	switch order {
+	case "", orderByCount:
+	case orderAlphabetical:
+		terms["order"] = gin.H{"_key": "asc"}
+	default:
+		slog.Debug(fmt.Sprintf("Aggregation order %s not recognised, ordering %s by count", order, field))
+	}
+	return gin.H{"terms": terms}
 }
 
 func flattenAggs(elasticResp SearchResponse) map[string]any {
@@ -1342,9 +1520,17 @@ func flattenAggs(elasticResp SearchResponse) map[string]any {
 			slog.Debug(fmt.Sprintf("Unexpected aggregation type for key %s", k))
 			continue
 		}
-		if k == "dateRange" || k == "publicationDate" {
+		if _, ok := dateFacets[k]; ok {
 			newAggs["startDate"] = aggMap["startDate"]
 			newAggs["endDate"] = aggMap["endDate"]
+			if histogram, ok := aggMap[k]; ok {
+				newAggs[k] = histogram
+			}
+		} else if facet, ok := numericFacets[k]; ok {
+			newAggs[k] = numericFacetResult(k, facet, aggMap)
+		} else if _, ok := hierarchicalFacets[k]; ok {
+			hierarchyAgg, _ := aggMap[k].(map[string]any)
+			newAggs[k] = gin.H{"buckets": hierarchyTree(hierarchyAgg, "")}
 		} else {
 			newAggs[k] = aggMap[k]
 		}
@@ -1373,10 +1559,13 @@ func copyResponseHits(r SearchResponse) SearchResponse {
 	}
 }
 
+// explanationClient sends search explanations to the extraction service.
+var explanationClient HTTPClient = &http.Client{Timeout: 30 * time.Second}
+
 func extractExplanation(elasticResp SearchResponse, query Query, searchUuid string) {
 	bodyContent := gin.H{
 		"data":              elasticResp,
-		"query":             fmt.Sprintf("%s", query),
+		"query":             fmt.Sprintf("%v", query),
 		"destination_table": os.Getenv("SEARCH_EXPLANATION_TABLE"),
 		"search_uuid":       searchUuid,
 	}
@@ -1396,7 +1585,7 @@ func extractExplanation(elasticResp SearchResponse, query Query, searchUuid stri
 	req.Header.Add("Content-Type", "application/json")
 	req.SetBasicAuth(os.Getenv("SEARCH_EXPLANATION_USER"), os.Getenv("SEARCH_EXPLANATION_PASSWORD"))
 
-	response, err := Client.Do(req)
+	response, err := explanationClient.Do(req)
 	if err != nil {
 		slog.Info(fmt.Sprintf("Failed to send search explanation request: %s", err.Error()))
 		return
@@ -1413,63 +1602,6 @@ func extractExplanation(elasticResp SearchResponse, query Query, searchUuid stri
 	))
 }
 
-// SearchSimilarDatasets returns the top datasets similar to the document with the provided id.
-func SearchSimilarDatasets(c *gin.Context) {
-	var querySimilar SimilarSearch
-	if err := c.BindJSON(&querySimilar); err != nil {
-		slog.Debug(fmt.Sprintf("Failed to interpret search query with %s", err.Error()))
-		return
-	}
-	results := similarSearch(c.Request.Context(), querySimilar.ID, "dataset")
-	c.JSON(http.StatusOK, results)
-}
-
-func similarSearch(ctx context.Context, id string, index string) SearchResponse {
-	var buf bytes.Buffer
-	elasticQuery := gin.H{
-		"size": searchNoRecordsSimilar,
-		"query": gin.H{
-			"more_like_this": gin.H{
-				"like": []gin.H{
-					{"_index": index, "_id": id},
-				},
-			},
-		},
-	}
-
-	if err := json.NewEncoder(&buf).Encode(elasticQuery); err != nil {
-		slog.Debug(fmt.Sprintf("Failed to encode elastic query with %s", err.Error()))
-	}
-
-	response, err := ElasticClient.Search(
-		ElasticClient.Search.WithContext(ctx),
-		ElasticClient.Search.WithIndex(index),
-		ElasticClient.Search.WithBody(&buf),
-	)
-	if err != nil {
-		slog.Error(fmt.Sprintf("Failed to execute similar search with %s", err.Error()))
-		return SearchResponse{}
-	}
-	defer response.Body.Close()
-
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		slog.Debug(fmt.Sprintf("Failed to read elastic response with %s", err.Error()))
-	}
-
-	var elasticResp SearchResponse
-	if err := json.Unmarshal(body, &elasticResp); err != nil {
-		slog.Debug(fmt.Sprintf("Failed to unmarshal elastic response with %s", err.Error()))
-	}
-
-	if elasticResp.Hits.Hits == nil {
-		slog.Warn("Hits from elastic are null, query may be malformed")
-		slog.Debug(fmt.Sprintf("Null result elastic query: %v", elasticQuery))
-	}
-
-	return elasticResp
-}
-
 func uploadSearchAnalytics(query Query, results SearchResponse, entityType string, searchUuid string) {
 	ctx := context.Background()
 	analyticsDataset := BigQueryClient.Dataset(os.Getenv("BQ_DATASET_NAME"))
@@ -17,7 +17,7 @@ import (
 
 func init() {
 	ElasticClient = mocks.MockElasticClient()
-	Client = &mocks.MockClient{}
+	explanationClient = &mocks.MockClient{}
 
 	mocks.PostDoFunc = func(req *http.Request) (*http.Response, error) {
 		r := io.NopCloser(bytes.NewReader([]byte(``)))
@@ -115,6 +115,51 @@ func TestDatasetSearch(t *testing.T) {
 	assert.EqualValues(t, 3, int(testResp["took"].(float64)))
 }
 
+func TestDatasetSearchSummaryView(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+	var requested map[string]interface{}
+	mockElasticResponses(func(req *http.Request) string {
+		json.NewDecoder(req.Body).Decode(&requested)
+		return `{"took": 1, "hits": {"hits": [{"_id": "1", "_score": 1, "_explanation": {"value": 1}, "_source": {"title": "A"}}]}}`
+	})
+
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Body = io.NopCloser(bytes.NewBufferString(`{"query": "asthma", "view": "summary", "exclude": ["abstract"]}`))
+
+	DatasetSearch(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, map[string]interface{}{
+		"includes": []interface{}{"title", "shortTitle", "abstract", "publisherName", "dataType", "geographicLocation", "populationSize", "startDate", "endDate"},
+		"excludes": []interface{}{"abstract"},
+	}, requested["_source"])
+	assert.NotContains(t, w.Body.String(), "_explanation")
+	assert.NotContains(t, w.Body.String(), "highlight")
+}
+
+func TestDatasetSearchInvalidView(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Body = io.NopCloser(bytes.NewBufferString(`{"query": "asthma", "view": "card"}`))
+
+	DatasetSearch(c)
+
+	assert.EqualValues(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSourceFilter(t *testing.T) {
+	assert.Nil(t, sourceFilter("tool", Query{}))
+	assert.Nil(t, sourceFilter("tool", Query{View: viewFull}))
+	assert.EqualValues(t, gin.H{"includes": searchEntities["tools"].SummaryFields}, sourceFilter("tool", Query{View: viewSummary}))
+	assert.EqualValues(t, gin.H{"includes": []string{"name"}}, sourceFilter("tool", Query{View: viewSummary, Fields: []string{"name"}}))
+	assert.EqualValues(t, gin.H{"excludes": []string{"description"}}, sourceFilter("tool", Query{Exclude: []string{"description"}}))
+}
+
 func TestToolSearch(t *testing.T) {
 	w := httptest.NewRecorder()
 	c := GetTestGinContext(w)
@@ -251,8 +296,9 @@ func TestSimilarDatasetSearch(t *testing.T) {
 	w := httptest.NewRecorder()
 	c := GetTestGinContext(w)
 	MockPostToSimilarSearch(c)
+	c.Params = gin.Params{{Key: "entity", Value: "datasets"}}
 
-	SearchSimilarDatasets(c)
+	SearchSimilar(c)
 
 	assert.EqualValues(t, http.StatusOK, w.Code)
 
@@ -576,3 +622,68 @@ func TestDataProviderElasticConfig(t *testing.T) {
 	aggsClause := durConfig["aggs"].(gin.H)
 	assert.Contains(t, aggsClause, "datasetTitles")
 }
+
+func TestTermsFilter(t *testing.T) {
+	assert.EqualValues(t, gin.H{"bool": gin.H{"should": []gin.H{
+		{"term": gin.H{"publisherName": "A"}},
+		{"term": gin.H{"publisherName": "B"}},
+	}}}, termsFilter("publisherName", []interface{}{"A", "B"}))
+
+	assert.EqualValues(t, gin.H{"bool": gin.H{"must_not": []gin.H{
+		{"term": gin.H{"publisherName": "C"}},
+		{"prefix": gin.H{"publisherName": "NHS"}},
+	}}}, termsFilter("publisherName", map[string]interface{}{
+		"mode":   "none",
+		"values": []interface{}{"C"},
+		"prefix": "NHS",
+	}))
+
+	assert.EqualValues(t, gin.H{"bool": gin.H{"must": []gin.H{
+		{"bool": gin.H{"must": []gin.H{
+			{"term": gin.H{"keywords": "asthma"}},
+			{"term": gin.H{"keywords": "children"}},
+		}}},
+		{"exists": gin.H{"field": "keywords"}},
+	}}}, termsFilter("keywords", map[string]interface{}{
+		"mode":   "all",
+		"values": []interface{}{"asthma", "children"},
+		"exists": true,
+	}))
+
+	assert.EqualValues(t, gin.H{"bool": gin.H{"must_not": []gin.H{
+		{"exists": gin.H{"field": "doi"}},
+	}}}, termsFilter("doi", map[string]interface{}{"missing": true}))
+}
+
+func TestBuildAggregationsConjunctiveFilter(t *testing.T) {
+	query := Query{
+		Filters: map[string]map[string]interface{}{
+			"dataset": {
+				"keywords":      map[string]interface{}{"mode": "all", "values": []interface{}{"asthma"}},
+				"publisherName": []interface{}{"A"},
+			},
+		},
+		Aggregations: []map[string]interface{}{
+			{"type": "dataset", "keys": "keywords"},
+			{"type": "dataset", "keys": "publisherName"},
+		},
+	}
+	_, mustFiltersByKey := buildFilters(query.Filters["dataset"])
+
+	aggs := buildAggregations(query, mustFiltersByKey)
+
+	keywordFilters := aggs["keywords"].(gin.H)["filter"].(gin.H)["bool"].(gin.H)["must"].([]gin.H)
+	assert.Len(t, keywordFilters, 2)
+	publisherFilters := aggs["publisherName"].(gin.H)["filter"].(gin.H)["bool"].(gin.H)["must"].([]gin.H)
+	assert.EqualValues(t, []gin.H{mustFiltersByKey["keywords"]}, publisherFilters)
+}
+
+func TestValidateQueryFilterMode(t *testing.T) {
+	query := Query{Filters: map[string]map[string]interface{}{
+		"tool": {"license": map[string]interface{}{"mode": "some", "values": []interface{}{"MIT"}}},
+	}}
+	assert.NotNil(t, validateQuery(query, "tools"))
+
+	query.Filters["tool"]["license"] = map[string]interface{}{"mode": "none", "values": []interface{}{"MIT"}}
+	assert.Nil(t, validateQuery(query, "tools"))
+}
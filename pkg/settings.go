@@ -14,14 +14,28 @@ import (
 )
 
 // DefineDatasetMappings initialises the datasets index and defines the custom
-// mappings for specific fields which need to be used as filters.
+// mappings for specific fields which need to be used as filters. The ingest
+// pipelines populating the hierarchical facets, geographic coverage and
+// indexing time are created first and run on every dataset indexed.
 // Mappings can only be defined BEFORE any data is indexed, updating mappings
 // requires reindexing.
 func DefineDatasetMappings(c *gin.Context) {
+	err := putIngestPipelines(c.Request.Context(), indexedAtPipeline, geographicCoveragePipeline, datasetFacetPathsPipeline)
+	if err != nil {
+		pubSubAudit(
+			"update mappings",
+			"datasets",
+			fmt.Sprintf("dataset ingest pipelines failed to update with error: %s", err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
 	var buf bytes.Buffer
 	elasticMappings := gin.H{
 		"settings": gin.H{
 			"index": gin.H{
+				"default_pipeline": datasetFacetPathsPipeline,
 				"analysis": gin.H{
 					"analyzer": gin.H{
 						//index analyzer
@@ -47,6 +61,14 @@ func DefineDatasetMappings(c *gin.Context) {
 								"punctuation_removal",
 							},
 						},
+						//spelling suggestion analyzer
+						"trigram_analyzer": gin.H{
+							"tokenizer": "standard",
+							"filter": []string{
+								"lowercase",
+								"shingle",
+							},
+						},
 					},
 					"filter": gin.H{
 						"english_stemmer": gin.H{
@@ -58,6 +80,11 @@ func DefineDatasetMappings(c *gin.Context) {
 							"synonyms_set": "hdr_synonyms_set",
 							"updateable":   true,
 						},
+						"shingle": gin.H{
+							"type":             "shingle",
+							"min_shingle_size": 2,
+							"max_shingle_size": 3,
+						},
 					},
 					"char_filter": gin.H{
 						"punctuation_removal": gin.H{
@@ -76,6 +103,8 @@ func DefineDatasetMappings(c *gin.Context) {
 					"analyzer": "medterms_index_analyzer",
 					"fields": gin.H{
 						"keyword": gin.H{"type": "keyword"},
+						"suggest": gin.H{"type": "search_as_you_type"},
+						"trigram": gin.H{"type": "text", "analyzer": "trigram_analyzer"},
 					},
 				},
 				"shortTitle": gin.H{
@@ -104,6 +133,7 @@ func DefineDatasetMappings(c *gin.Context) {
 					"analyzer": "medterms_index_analyzer",
 					"fields": gin.H{
 						"keyword": gin.H{"type": "keyword"},
+						"trigram": gin.H{"type": "text", "analyzer": "trigram_analyzer"},
 					},
 				},
 				"named_entities": gin.H{
@@ -111,24 +141,32 @@ func DefineDatasetMappings(c *gin.Context) {
 					"analyzer": "medterms_index_analyzer",
 					"fields": gin.H{
 						"keyword": gin.H{"type": "keyword"},
+						"trigram": gin.H{"type": "text", "analyzer": "trigram_analyzer"},
 					},
 				},
-				"publisherName":      gin.H{"type": "keyword"},
-				"dataProvider":       gin.H{"type": "keyword"},
-				"dataProviderColl":   gin.H{"type": "keyword"},
-				"dataUseTitles":      gin.H{"type": "keyword"},
-				"collectionName":     gin.H{"type": "keyword"},
-				"geographicLocation": gin.H{"type": "keyword"},
-				"accessService":      gin.H{"type": "keyword"},
-				"sampleAvailability": gin.H{"type": "keyword"},
-				"dataType":           gin.H{"type": "keyword"},
-				"dataSubType":        gin.H{"type": "keyword"},
-				"formatAndStandards": gin.H{"type": "keyword"},
+				"publisherName":          gin.H{"type": "keyword"},
+				"dataProvider":           gin.H{"type": "keyword"},
+				"dataProviderColl":       gin.H{"type": "keyword"},
+				"dataUseTitles":          gin.H{"type": "keyword"},
+				"collectionName":         gin.H{"type": "keyword"},
+				"geographicLocation":     gin.H{"type": "keyword"},
+				"accessService":          gin.H{"type": "keyword"},
+				"sampleAvailability":     gin.H{"type": "keyword"},
+				"dataType":               gin.H{"type": "keyword"},
+				"dataSubType":            gin.H{"type": "keyword"},
+				"formatAndStandards":     gin.H{"type": "keyword"},
+				"dataTypePath":           gin.H{"type": "keyword"},
+				"geographicLocationPath": gin.H{"type": "keyword"},
+				"geographicCoverage":     gin.H{"type": "geo_shape"},
+				"geographicCentre":       gin.H{"type": "geo_point"},
+				indexedAtField:           gin.H{"type": "date"},
+				indexedIDField:           gin.H{"type": "keyword"},
 				"datasetAliases": gin.H{
 					"type":     "text",
 					"analyzer": "medterms_index_analyzer",
 					"fields": gin.H{
 						"keyword": gin.H{"type": "keyword"},
+						"suggest": gin.H{"type": "search_as_you_type"},
 					},
 				},
 			},
@@ -300,6 +338,13 @@ func DefineToolMappings(c *gin.Context) {
 				"programmingLanguages": gin.H{"type": "keyword"},
 				"typeCategory":         gin.H{"type": "keyword"},
 				"keywords":             gin.H{"type": "keyword"},
+				"name": gin.H{
+					"type": "text",
+					"fields": gin.H{
+						"keyword": gin.H{"type": "keyword"},
+						"suggest": gin.H{"type": "search_as_you_type"},
+					},
+				},
 			},
 		},
 	}
@@ -470,6 +515,13 @@ func DefineCollectionMappings(c *gin.Context) {
 				"dataProvider":     gin.H{"type": "keyword"},
 				"dataProviderColl": gin.H{"type": "keyword"},
 				"datasetTitles":    gin.H{"type": "keyword"},
+				"name": gin.H{
+					"type": "text",
+					"fields": gin.H{
+						"keyword": gin.H{"type": "keyword"},
+						"suggest": gin.H{"type": "search_as_you_type"},
+					},
+				},
 			},
 		},
 	}
@@ -520,10 +572,25 @@ func DefineCollectionMappings(c *gin.Context) {
 // Mappings can only be defined BEFORE any data is indexed, updating mappings
 // requires reindexing.
 func DefineDataUseMappings(c *gin.Context) {
+	if err := putIngestPipelines(c.Request.Context(), indexedAtPipeline); err != nil {
+		pubSubAudit(
+			"update mappings",
+			"data uses",
+			fmt.Sprintf("indexed at pipeline failed to update with error: %s", err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
 	var buf bytes.Buffer
 	elasticMappings := gin.H{
+		"settings": gin.H{
+			"index": gin.H{"default_pipeline": indexedAtPipeline},
+		},
 		"mappings": gin.H{
 			"properties": gin.H{
+				indexedAtField:     gin.H{"type": "date"},
+				indexedIDField:     gin.H{"type": "keyword"},
 				"publisherName":    gin.H{"type": "keyword"},
 				"dataProvider":     gin.H{"type": "keyword"},
 				"dataProviderColl": gin.H{"type": "keyword"},
@@ -531,6 +598,13 @@ func DefineDataUseMappings(c *gin.Context) {
 				"organisationName": gin.H{"type": "keyword"},
 				"datasetTitles":    gin.H{"type": "keyword"},
 				"collectionNames":  gin.H{"type": "keyword"},
+				"projectTitle": gin.H{
+					"type": "text",
+					"fields": gin.H{
+						"keyword": gin.H{"type": "keyword"},
+						"suggest": gin.H{"type": "search_as_you_type"},
+					},
+				},
 			},
 		},
 	}
@@ -577,12 +651,26 @@ func DefineDataUseMappings(c *gin.Context) {
 }
 
 // DefinePublicationMappings initialises the publication index and defines the custom
-// mappings for specific fields which need to be used as filters.
+// mappings for specific fields which need to be used as filters. DOIs are case
+// insensitive, so the doi keyword is lowercased by a normalizer; existing
+// publication indices must be recreated and reindexed to pick it up.
 // Mappings can only be defined BEFORE any data is indexed, updating mappings
 // requires reindexing.
 func DefinePublicationMappings(c *gin.Context) {
 	var buf bytes.Buffer
 	elasticMappings := gin.H{
+		"settings": gin.H{
+			"index": gin.H{
+				"analysis": gin.H{
+					"normalizer": gin.H{
+						"lowercase_normalizer": gin.H{
+							"type":   "custom",
+							"filter": []string{"lowercase"},
+						},
+					},
+				},
+			},
+		},
 		"mappings": gin.H{
 			"properties": gin.H{
 				"publicationType":  gin.H{"type": "keyword"},
@@ -590,6 +678,7 @@ func DefinePublicationMappings(c *gin.Context) {
 				"datasetLinkTypes": gin.H{"type": "keyword"},
 				"publicationDate":  gin.H{"type": "date"},
 				"keywords":         gin.H{"type": "keyword"},
+				"doi":              gin.H{"type": "keyword", "normalizer": "lowercase_normalizer"},
 			},
 		},
 	}
@@ -636,18 +725,42 @@ func DefinePublicationMappings(c *gin.Context) {
 }
 
 // DefineDataProviderMappings initialises the dataprovider index and defines the custom
-// mappings for specific fields which need to be used as filters.
+// mappings for specific fields which need to be used as filters. The ingest
+// pipeline populating the geographic coverage is created first and run on
+// every data provider indexed.
 // Mappings can only be defined BEFORE any data is indexed, updating mappings
 // requires reindexing.
 func DefineDataProviderMappings(c *gin.Context) {
+	if err := putIngestPipelines(c.Request.Context(), geographicCoveragePipeline); err != nil {
+		pubSubAudit(
+			"update mappings",
+			"data providers",
+			fmt.Sprintf("geographic coverage pipeline failed to update with error: %s", err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
 	var buf bytes.Buffer
 	elasticMappings := gin.H{
+		"settings": gin.H{
+			"index": gin.H{"default_pipeline": geographicCoveragePipeline},
+		},
 		"mappings": gin.H{
 			"properties": gin.H{
+				"geographicCoverage": gin.H{"type": "geo_shape"},
+				"geographicCentre":   gin.H{"type": "geo_point"},
 				"geographicLocation": gin.H{"type": "keyword"},
 				"datasetTitles":      gin.H{"type": "keyword"},
 				"dataType":           gin.H{"type": "keyword"},
 				"dataProviderColl":   gin.H{"type": "keyword"},
+				"name": gin.H{
+					"type": "text",
+					"fields": gin.H{
+						"keyword": gin.H{"type": "keyword"},
+						"suggest": gin.H{"type": "search_as_you_type"},
+					},
+				},
 			},
 		},
 	}
@@ -863,6 +976,130 @@ func DefineDataCustodianNetworkMappings(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// DefineEPMCCacheMappings initialises the epmc_cache index, which holds papers
+// and pages of results fetched from EuropePMC. The cached papers and results
+// are stored but not indexed, only the keys used to look them up are.
+func DefineEPMCCacheMappings(c *gin.Context) {
+	var buf bytes.Buffer
+	elasticMappings := gin.H{
+		"mappings": gin.H{
+			"properties": gin.H{
+				"kind":     gin.H{"type": "keyword"},
+				"cachedAt": gin.H{"type": "date"},
+				"doi":      gin.H{"type": "keyword"},
+				"pmid":     gin.H{"type": "keyword"},
+				"epmcId":   gin.H{"type": "keyword"},
+				"url":      gin.H{"type": "keyword", "index": false},
+				"paper":    gin.H{"type": "object", "enabled": false},
+				"response": gin.H{"type": "object", "enabled": false},
+			},
+		},
+	}
+	if err := json.NewEncoder(&buf).Encode(elasticMappings); err != nil {
+		slog.Debug(fmt.Sprintf(
+			"Failed to encode elastic query %s with %s",
+			elasticMappings,
+			err.Error()),
+		)
+	}
+
+	request := esapi.IndicesCreateRequest{
+		Index: epmcCacheIndex,
+		Body:  &buf,
+	}
+	response, err := request.Do(context.TODO(), ElasticClient)
+	if err != nil {
+		pubSubAudit(
+			"update mappings",
+			"epmc cache",
+			fmt.Sprintf("epmc cache mappings failed to update with error: %s", err.Error()),
+		)
+		slog.Debug(fmt.Sprintf(
+			"Failed to execute elastic query with %s",
+			err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		slog.Debug(fmt.Sprintf(
+			"Failed to read elastic response with %s",
+			err.Error()),
+		)
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(body, &resp)
+
+	pubSubAudit("update mappings", "epmc cache", "epmc cache mappings sucessfully updated")
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// DefineSavedSearchMappings initialises the saved_searches index, which holds
+// the searches saved by users. The saved queries are stored but not indexed,
+// only the fields used to look them up and sort them are.
+func DefineSavedSearchMappings(c *gin.Context) {
+	var buf bytes.Buffer
+	elasticMappings := gin.H{
+		"mappings": gin.H{
+			"properties": gin.H{
+				"id":          gin.H{"type": "keyword"},
+				"userId":      gin.H{"type": "keyword"},
+				"name":        gin.H{"type": "keyword"},
+				"entity":      gin.H{"type": "keyword"},
+				"query":       gin.H{"type": "object", "enabled": false},
+				"createdAt":   gin.H{"type": "date"},
+				"lastRunAt":   gin.H{"type": "date"},
+				"lastAlertAt": gin.H{"type": "date"},
+				"lastRunId":   gin.H{"type": "keyword", "index": false},
+				"lastAlertId": gin.H{"type": "keyword", "index": false},
+			},
+		},
+	}
+	if err := json.NewEncoder(&buf).Encode(elasticMappings); err != nil {
+		slog.Debug(fmt.Sprintf(
+			"Failed to encode elastic query %s with %s",
+			elasticMappings,
+			err.Error()),
+		)
+	}
+
+	request := esapi.IndicesCreateRequest{
+		Index: savedSearchIndex,
+		Body:  &buf,
+	}
+	response, err := request.Do(context.TODO(), ElasticClient)
+	if err != nil {
+		pubSubAudit(
+			"update mappings",
+			"saved searches",
+			fmt.Sprintf("saved search mappings failed to update with error: %s", err.Error()),
+		)
+		slog.Debug(fmt.Sprintf(
+			"Failed to execute elastic query with %s",
+			err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		slog.Debug(fmt.Sprintf(
+			"Failed to read elastic response with %s",
+			err.Error()),
+		)
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(body, &resp)
+
+	pubSubAudit("update mappings", "saved searches", "saved search mappings sucessfully updated")
+
+	c.JSON(http.StatusOK, resp)
+}
+
 // closeIndexByName closes the elastic index matching the provided name.
 func closeIndexByName(indexName string) {
 	closeIndexRequest := esapi.IndicesCloseRequest{
@@ -886,3 +1123,49 @@ func openIndexByName(indexName string) {
 	}
 	defer openResponse.Body.Close()
 }
+
+// ingestPipelines maps the names of the ingest pipelines run on documents as
+// they are indexed to their definitions.
+var ingestPipelines = map[string]func() gin.H{
+	indexedAtPipeline:          indexedAtPipelineBody,
+	geographicCoveragePipeline: geographicCoveragePipelineBody,
+	datasetFacetPathsPipeline:  datasetFacetPathsPipelineBody,
+}
+
+// putIngestPipelines creates or replaces the named ingest pipelines in order,
+// so that pipelines run by those after them are created first.
+func putIngestPipelines(ctx context.Context, names ...string) error {
+	for _, name := range names {
+		if err := putIngestPipeline(ctx, name, ingestPipelines[name]()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putIngestPipeline creates or replaces the ingest pipeline name.
+func putIngestPipeline(ctx context.Context, name string, pipeline gin.H) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(pipeline); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to encode ingest pipeline with %s", err.Error()))
+		return err
+	}
+
+	response, err := ElasticClient.Ingest.PutPipeline(
+		name,
+		&buf,
+		ElasticClient.Ingest.PutPipeline.WithContext(ctx),
+	)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to put ingest pipeline %s with %s", name, err.Error()))
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		body, _ := io.ReadAll(response.Body)
+		slog.Warn(fmt.Sprintf("Put ingest pipeline returned status %d: %s", response.StatusCode, body))
+		return fmt.Errorf("elastic responded with status %d", response.StatusCode)
+	}
+	return nil
+}
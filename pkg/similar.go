@@ -0,0 +1,108 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SimilarSearch represents a request for the documents most similar to the
+// document with the given ID. The other fields are optional:
+//   - Fields restricts the fields compared, defaulting to the entity's
+//     descriptive fields.
+//   - MinTermFreq and MaxQueryTerms tune the more_like_this query.
+//   - Filters restricts the results, in the same format as a Query.
+//   - Source restricts the fields returned for each hit.
+type SimilarSearch struct {
+	ID            string                            `json:"id"`
+	Fields        []string                          `json:"fields"`
+	MinTermFreq   *int                              `json:"minTermFreq"`
+	MaxQueryTerms *int                              `json:"maxQueryTerms"`
+	Filters       map[string]map[string]interface{} `json:"filters"`
+	Source        []string                          `json:"source"`
+}
+
+// SearchSimilar returns the top documents of the entity type given in the
+// route which are similar to the document with the provided id, e.g.
+// POST /similar/tools.
+func SearchSimilar(c *gin.Context) {
+	entity, ok := searchEntities[c.Param("entity")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown entity type %s", c.Param("entity"))})
+		return
+	}
+
+	var querySimilar SimilarSearch
+	if err := c.BindJSON(&querySimilar); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to interpret search query with %s", err.Error()))
+		return
+	}
+	if err := validateSimilarSearch(querySimilar); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := similarSearch(c.Request.Context(), entity, querySimilar)
+	c.JSON(http.StatusOK, results)
+}
+
+func validateSimilarSearch(querySimilar SimilarSearch) error {
+	if querySimilar.ID == "" {
+		return errors.New("id is required")
+	}
+	if querySimilar.MinTermFreq != nil && *querySimilar.MinTermFreq < 1 {
+		return errors.New("minTermFreq must be at least 1")
+	}
+	if querySimilar.MaxQueryTerms != nil && *querySimilar.MaxQueryTerms < 1 {
+		return errors.New("maxQueryTerms must be at least 1")
+	}
+	return validateFilters(querySimilar.Filters)
+}
+
+func similarSearch(ctx context.Context, entity searchEntity, querySimilar SimilarSearch) SearchResponse {
+	return searchIndex(ctx, entity.Index, similarElasticConfig(entity, querySimilar))
+}
+
+// similarElasticConfig defines the body of a more_like_this query for
+// documents similar to querySimilar.ID. The source document itself is always
+// excluded from the results.
+func similarElasticConfig(entity searchEntity, querySimilar SimilarSearch) gin.H {
+	fields := querySimilar.Fields
+	if len(fields) == 0 {
+		fields = entity.SimilarFields
+	}
+
+	moreLikeThis := gin.H{
+		"like": []gin.H{
+			{"_index": entity.Index, "_id": querySimilar.ID},
+		},
+		"fields":  fields,
+		"include": false,
+	}
+	if querySimilar.MinTermFreq != nil {
+		moreLikeThis["min_term_freq"] = *querySimilar.MinTermFreq
+	}
+	if querySimilar.MaxQueryTerms != nil {
+		moreLikeThis["max_query_terms"] = *querySimilar.MaxQueryTerms
+	}
+
+	mustFilters, _ := buildFilters(querySimilar.Filters[entity.FilterType])
+	elasticQuery := gin.H{
+		"size": searchNoRecordsSimilar,
+		"query": gin.H{
+			"bool": gin.H{
+				"must":     []gin.H{{"more_like_this": moreLikeThis}},
+				"filter":   mustFilters,
+				"must_not": []gin.H{{"ids": gin.H{"values": []string{querySimilar.ID}}}},
+			},
+		},
+	}
+	if len(querySimilar.Source) > 0 {
+		elasticQuery["_source"] = querySimilar.Source
+	}
+	return elasticQuery
+}
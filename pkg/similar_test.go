@@ -0,0 +1,98 @@
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimilarElasticConfigDefaults(t *testing.T) {
+	elasticQuery := similarElasticConfig(searchEntities["tools"], SimilarSearch{ID: "7"})
+
+	boolQuery := elasticQuery["query"].(gin.H)["bool"].(gin.H)
+	moreLikeThis := boolQuery["must"].([]gin.H)[0]["more_like_this"].(gin.H)
+	assert.EqualValues(t, []gin.H{{"_index": "tool", "_id": "7"}}, moreLikeThis["like"])
+	assert.EqualValues(t, searchEntities["tools"].SimilarFields, moreLikeThis["fields"])
+	assert.EqualValues(t, false, moreLikeThis["include"])
+	assert.NotContains(t, moreLikeThis, "min_term_freq")
+	assert.EqualValues(t, []gin.H{{"ids": gin.H{"values": []string{"7"}}}}, boolQuery["must_not"])
+	assert.Empty(t, boolQuery["filter"])
+	assert.NotContains(t, elasticQuery, "_source")
+}
+
+func TestSimilarElasticConfigTuned(t *testing.T) {
+	minTermFreq, maxQueryTerms := 1, 40
+	elasticQuery := similarElasticConfig(searchEntities["dur"], SimilarSearch{
+		ID:            "7",
+		Fields:        []string{"laySummary"},
+		MinTermFreq:   &minTermFreq,
+		MaxQueryTerms: &maxQueryTerms,
+		Filters: map[string]map[string]interface{}{
+			"dataUseRegister": {"organisationName": []interface{}{"Org A"}},
+			"dataset":         {"publisherName": []interface{}{"Ignored"}},
+		},
+		Source: []string{"projectTitle"},
+	})
+
+	boolQuery := elasticQuery["query"].(gin.H)["bool"].(gin.H)
+	moreLikeThis := boolQuery["must"].([]gin.H)[0]["more_like_this"].(gin.H)
+	assert.EqualValues(t, []gin.H{{"_index": "datauseregister", "_id": "7"}}, moreLikeThis["like"])
+	assert.EqualValues(t, []string{"laySummary"}, moreLikeThis["fields"])
+	assert.EqualValues(t, 1, moreLikeThis["min_term_freq"])
+	assert.EqualValues(t, 40, moreLikeThis["max_query_terms"])
+	assert.EqualValues(t, []gin.H{
+		{"bool": gin.H{"should": []gin.H{{"term": gin.H{"organisationName": "Org A"}}}}},
+	}, boolQuery["filter"])
+	assert.EqualValues(t, []string{"projectTitle"}, elasticQuery["_source"])
+}
+
+func TestSearchSimilarUnknownEntity(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	MockPostToSimilarSearch(c)
+	c.Params = gin.Params{{Key: "entity", Value: "widgets"}}
+
+	SearchSimilar(c)
+
+	assert.EqualValues(t, http.StatusNotFound, w.Code)
+}
+
+func TestSearchSimilarInvalid(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	bodyBytes, _ := json.Marshal(gin.H{"id": "1", "maxQueryTerms": 0})
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	c.Params = gin.Params{{Key: "entity", Value: "publications"}}
+
+	SearchSimilar(c)
+
+	assert.EqualValues(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSearchSimilarInvalidFilters(t *testing.T) {
+	for _, filters := range []gin.H{
+		{"dataset": gin.H{"populationSize": []interface{}{1, 2}}},
+		{"dataset": gin.H{"dateRange": []interface{}{"2020", "2021", "2022"}}},
+		{"dataset": gin.H{"publisherName": "A"}},
+	} {
+		w := httptest.NewRecorder()
+		c := GetTestGinContext(w)
+		c.Request.Method = "POST"
+		c.Request.Header.Set("Content-Type", "application/json")
+		bodyBytes, _ := json.Marshal(gin.H{"id": "1", "filters": filters})
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		c.Params = gin.Params{{Key: "entity", Value: "datasets"}}
+
+		SearchSimilar(c)
+
+		assert.EqualValues(t, http.StatusBadRequest, w.Code)
+	}
+}
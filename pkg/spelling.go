@@ -0,0 +1,142 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SpellingSuggestion is an alternative query string offered to the user as
+// "Did you mean ..." when a search returns few results.
+type SpellingSuggestion struct {
+	Text        string  `json:"text"`
+	Highlighted string  `json:"highlighted"`
+	Score       float64 `json:"score"`
+}
+
+// spellingSuggestResponse represents the suggest block returned by elastic
+// for a phrase suggester request.
+type spellingSuggestResponse struct {
+	Suggest map[string][]struct {
+		Text    string `json:"text"`
+		Options []struct {
+			Text         string  `json:"text"`
+			Highlighted  string  `json:"highlighted"`
+			Score        float64 `json:"score"`
+			CollateMatch *bool   `json:"collate_match"`
+		} `json:"options"`
+	} `json:"suggest"`
+}
+
+// spellingSuggestionsEnabled reports whether a search with the given results
+// should be followed up with a spelling suggestion request.
+// Suggestions are only offered for dataset searches with a query string, and
+// only when the number of hits is below SEARCH_SUGGESTION_THRESHOLD.
+func spellingSuggestionsEnabled(elasticResp SearchResponse, query Query, entityType string) bool {
+	if entityType != "dataset" || query.QueryString == "" {
+		return false
+	}
+	total, _ := elasticResp.Hits.Total["value"].(float64)
+	return int(total) < searchSuggestionThreshold
+}
+
+// spellingSuggestions runs a phrase suggester against the given index and
+// returns the corrections which match at least one document.
+func spellingSuggestions(ctx context.Context, index string, queryString string) []SpellingSuggestion {
+	suggestions := []SpellingSuggestion{}
+
+	var buf bytes.Buffer
+	elasticQuery := spellingElasticConfig(queryString)
+	if err := json.NewEncoder(&buf).Encode(elasticQuery); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to encode spelling suggestion query with %s", err.Error()))
+	}
+
+	response, err := ElasticClient.Search(
+		ElasticClient.Search.WithContext(ctx),
+		ElasticClient.Search.WithIndex(index),
+		ElasticClient.Search.WithBody(&buf),
+	)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to execute spelling suggestion query on index %s: %s", index, err.Error()))
+		return suggestions
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Failed to read elastic response with %s", err.Error()))
+		return suggestions
+	}
+
+	var suggestResp spellingSuggestResponse
+	if err := json.Unmarshal(body, &suggestResp); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to unmarshal spelling suggestion response with %s", err.Error()))
+		return suggestions
+	}
+
+	for _, entry := range suggestResp.Suggest["did_you_mean"] {
+		for _, option := range entry.Options {
+			if option.CollateMatch != nil && !*option.CollateMatch {
+				continue
+			}
+			suggestions = append(suggestions, SpellingSuggestion{
+				Text:        option.Text,
+				Highlighted: option.Highlighted,
+				Score:       option.Score,
+			})
+		}
+	}
+	return suggestions
+}
+
+// spellingElasticConfig defines the body of a suggest-only query to the
+// datasets index. Candidates are generated from the trigram sub-fields of the
+// title, keywords and named_entities fields, and collated against the
+// searchable fields using the medical synonym analyzer so that only
+// corrections that would return results are kept.
+func spellingElasticConfig(queryString string) gin.H {
+	generatorFields := []string{"title.trigram", "keywords.trigram", "named_entities.trigram"}
+	directGenerators := []gin.H{}
+	for _, field := range generatorFields {
+		directGenerators = append(directGenerators, gin.H{
+			"field":        field,
+			"suggest_mode": "always",
+		})
+	}
+
+	return gin.H{
+		"size": 0,
+		"suggest": gin.H{
+			"text": queryString,
+			"did_you_mean": gin.H{
+				"phrase": gin.H{
+					"field":            "title.trigram",
+					"size":             3,
+					"gram_size":        3,
+					"confidence":       1,
+					"max_errors":       2,
+					"direct_generator": directGenerators,
+					"highlight":        gin.H{"pre_tag": "<em>", "post_tag": "</em>"},
+					"collate": gin.H{
+						"query": gin.H{
+							"source": gin.H{
+								"multi_match": gin.H{
+									"query":    "{{suggestion}}",
+									"fields":   []string{"title", "keywords", "named_entities"},
+									"analyzer": "medterms_search_analyzer",
+									"operator": "and",
+								},
+							},
+						},
+						"prune": true,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/stretchr/testify/assert"
+
+	"hdruk/search-service/utils/mocks"
+)
+
+var suggestRespJson = `{
+	"took": 2,
+	"hits": {"hits": []},
+	"suggest": {
+		"did_you_mean": [
+			{
+				"text": "astma",
+				"offset": 0,
+				"length": 5,
+				"options": [
+					{"text": "asthma", "highlighted": "<em>asthma</em>", "score": 0.3, "collate_match": true},
+					{"text": "astra", "highlighted": "<em>astra</em>", "score": 0.1, "collate_match": false}
+				]
+			}
+		]
+	}
+}`
+
+func TestSpellingSuggestionsEnabled(t *testing.T) {
+	searchSuggestionThreshold = 5
+	defer func() { searchSuggestionThreshold = 0 }()
+
+	query := Query{QueryString: "astma"}
+	fewHits := SearchResponse{Hits: HitsField{Total: map[string]interface{}{"value": 2.0}}}
+	manyHits := SearchResponse{Hits: HitsField{Total: map[string]interface{}{"value": 20.0}}}
+
+	assert.True(t, spellingSuggestionsEnabled(fewHits, query, "dataset"))
+	assert.False(t, spellingSuggestionsEnabled(manyHits, query, "dataset"))
+	assert.False(t, spellingSuggestionsEnabled(fewHits, query, "tool"))
+	assert.False(t, spellingSuggestionsEnabled(fewHits, Query{}, "dataset"))
+}
+
+func TestSpellingSuggestions(t *testing.T) {
+	defer func() { ElasticClient = mocks.MockElasticClient() }()
+
+	mocktrans := mocks.MockTransport{}
+	mocktrans.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(suggestRespJson)),
+			Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		}, nil
+	}
+	ElasticClient, _ = elasticsearch.NewClient(elasticsearch.Config{Transport: &mocktrans})
+
+	suggestions := spellingSuggestions(context.Background(), "dataset", "astma")
+
+	assert.Len(t, suggestions, 1)
+	assert.EqualValues(t, "asthma", suggestions[0].Text)
+	assert.EqualValues(t, "<em>asthma</em>", suggestions[0].Highlighted)
+}
+
+func TestSpellingElasticConfig(t *testing.T) {
+	spellingConfig := spellingElasticConfig("astma")
+
+	assert.EqualValues(t, 0, spellingConfig["size"])
+
+	queryJson, _ := json.Marshal(spellingConfig)
+	queryStr := string(queryJson)
+	assert.Contains(t, queryStr, "\"text\":\"astma\"")
+	assert.Contains(t, queryStr, "title.trigram")
+	assert.Contains(t, queryStr, "keywords.trigram")
+	assert.Contains(t, queryStr, "named_entities.trigram")
+	assert.Contains(t, queryStr, "medterms_search_analyzer")
+}
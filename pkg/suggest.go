@@ -0,0 +1,223 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSuggestSize = 5
+	maxSuggestSize     = 10
+)
+
+// SuggestQuery represents an autocomplete request from the gateway search bar.
+// Types optionally restricts the suggestions to a subset of entity types, using
+// the same names as the keys of the SearchGeneric response.
+type SuggestQuery struct {
+	QueryString string   `json:"query"`
+	Size        int      `json:"size"`
+	Types       []string `json:"types"`
+}
+
+// Suggestion is a single prefix completion for an entity.
+type Suggestion struct {
+	Id    string `json:"id"`
+	Text  string `json:"text"`
+	Field string `json:"field"`
+}
+
+// suggestSource describes where suggestions for an entity type come from.
+// Each field must have a `suggest` sub-field of type search_as_you_type,
+// defined in the relevant Define*Mappings handler.
+type suggestSource struct {
+	index  string
+	fields []string
+}
+
+var suggestSources = map[string]suggestSource{
+	"dataset":         {index: "dataset", fields: []string{"title", "datasetAliases"}},
+	"tool":            {index: "tool", fields: []string{"name"}},
+	"collection":      {index: "collection", fields: []string{"name"}},
+	"dataProvider":    {index: "dataprovider", fields: []string{"name"}},
+	"dataUseRegister": {index: "datauseregister", fields: []string{"projectTitle"}},
+}
+
+/*
+Suggest returns prefix completions for the given partial query string, grouped
+by entity type. It is intended to be called on each keystroke so only a small
+number of results are returned and only the suggested fields are fetched.
+
+The expected structure of a SuggestQuery is:
+
+```
+
+	{
+		"query": "asth",
+		"size": 5,
+		"types": ["dataset", "tool"]
+	}
+
+```
+where size and types are optional.
+*/
+func Suggest(c *gin.Context) {
+	var query SuggestQuery
+	if err := c.BindJSON(&query); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to interpret suggest query with %s", err.Error()))
+		return
+	}
+
+	if query.Size <= 0 {
+		query.Size = defaultSuggestSize
+	} else if query.Size > maxSuggestSize {
+		query.Size = maxSuggestSize
+	}
+
+	entityTypes := query.Types
+	if len(entityTypes) == 0 {
+		for entityType := range suggestSources {
+			entityTypes = append(entityTypes, entityType)
+		}
+	}
+
+	results := make(map[string][]Suggestion)
+	if strings.TrimSpace(query.QueryString) == "" {
+		c.JSON(http.StatusOK, results)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, entityType := range entityTypes {
+		source, ok := suggestSources[entityType]
+		if !ok {
+			slog.Debug(fmt.Sprintf("No suggestions available for entity type %s", entityType))
+			continue
+		}
+		wg.Add(1)
+		go func(entityType string, source suggestSource) {
+			defer wg.Done()
+			suggestions := executeSuggest(ctx, source, query)
+			mu.Lock()
+			results[entityType] = suggestions
+			mu.Unlock()
+		}(entityType, source)
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, results)
+}
+
+// executeSuggest runs a single suggestion query against the index of the given
+// source and converts the hits into suggestions.
+func executeSuggest(ctx context.Context, source suggestSource, query SuggestQuery) []Suggestion {
+	suggestions := []Suggestion{}
+
+	var buf bytes.Buffer
+	elasticQuery := suggestElasticConfig(source, query)
+	if err := json.NewEncoder(&buf).Encode(elasticQuery); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to encode suggest query with %s", err.Error()))
+	}
+
+	response, err := ElasticClient.Search(
+		ElasticClient.Search.WithContext(ctx),
+		ElasticClient.Search.WithIndex(source.index),
+		ElasticClient.Search.WithBody(&buf),
+	)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Failed to execute suggest query on index %s: %s", source.index, err.Error()))
+		return suggestions
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Failed to read elastic response with %s", err.Error()))
+		return suggestions
+	}
+
+	var elasticResp SearchResponse
+	if err := json.Unmarshal(body, &elasticResp); err != nil {
+		slog.Debug(fmt.Sprintf("Failed to unmarshal elastic response with %s", err.Error()))
+		return suggestions
+	}
+
+	for _, hit := range elasticResp.Hits.Hits {
+		text, field := suggestionText(hit.Source, source.fields, query.QueryString)
+		if text == "" {
+			continue
+		}
+		suggestions = append(suggestions, Suggestion{Id: hit.Id, Text: text, Field: field})
+	}
+	return suggestions
+}
+
+// suggestElasticConfig defines the body of a bool_prefix query over the
+// search_as_you_type sub-fields of the given source.
+func suggestElasticConfig(source suggestSource, query SuggestQuery) gin.H {
+	suggestFields := []string{}
+	for _, field := range source.fields {
+		suggestFields = append(
+			suggestFields,
+			fmt.Sprintf("%s.suggest", field),
+			fmt.Sprintf("%s.suggest._2gram", field),
+			fmt.Sprintf("%s.suggest._3gram", field),
+		)
+	}
+
+	return gin.H{
+		"size":             query.Size,
+		"track_total_hits": false,
+		"_source":          source.fields,
+		"query": gin.H{
+			"multi_match": gin.H{
+				"query":  query.QueryString,
+				"type":   "bool_prefix",
+				"fields": suggestFields,
+			},
+		},
+	}
+}
+
+// suggestionText picks the value to display for a hit, preferring the first
+// field value containing the query string. Array fields such as datasetAliases
+// are searched element by element.
+func suggestionText(source map[string]interface{}, fields []string, queryString string) (string, string) {
+	needle := strings.ToLower(strings.TrimSpace(queryString))
+	fallback, fallbackField := "", ""
+	for _, field := range fields {
+		var values []string
+		switch v := source[field].(type) {
+		case string:
+			values = []string{v}
+		case []interface{}:
+			for _, item := range v {
+				if s, ok := item.(string); ok {
+					values = append(values, s)
+				}
+			}
+		}
+		for _, value := range values {
+			if strings.Contains(strings.ToLower(value), needle) {
+				return value, field
+			}
+			if fallback == "" {
+				fallback, fallbackField = value, field
+			}
+		}
+	}
+	return fallback, fallbackField
+}
@@ -0,0 +1,78 @@
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func MockPostToSuggest(c *gin.Context, bodyContent gin.H) {
+	c.Request.Method = "POST"
+	c.Request.Header.Set("Content-Type", "application/json")
+	bodyBytes, err := json.Marshal(bodyContent)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+}
+
+func TestSuggest(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := GetTestGinContext(w)
+	MockPostToSuggest(c, gin.H{"query": "asth", "types": []string{"dataset", "tool", "unknown"}})
+
+	Suggest(c)
+
+	assert.EqualValues(t, http.StatusOK, w.Code)
+
+	bodyBytes, err := io.ReadAll(w.Body)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	var testResp map[string]interface{}
+	json.Unmarshal(bodyBytes, &testResp)
+
+	assert.Contains(t, testResp, "dataset")
+	assert.Contains(t, testResp, "tool")
+	assert.NotContains(t, testResp, "unknown")
+	assert.NotContains(t, testResp, "collection")
+}
+
+func TestSuggestElasticConfig(t *testing.T) {
+	query := SuggestQuery{QueryString: "asth", Size: 5}
+
+	suggestConfig := suggestElasticConfig(suggestSources["dataset"], query)
+
+	assert.EqualValues(t, 5, suggestConfig["size"])
+	assert.EqualValues(t, []string{"title", "datasetAliases"}, suggestConfig["_source"])
+
+	queryJson, _ := json.Marshal(suggestConfig)
+	queryStr := string(queryJson)
+	assert.Contains(t, queryStr, "bool_prefix")
+	assert.Contains(t, queryStr, "title.suggest._2gram")
+	assert.Contains(t, queryStr, "datasetAliases.suggest._3gram")
+}
+
+func TestSuggestionText(t *testing.T) {
+	source := map[string]interface{}{
+		"title":          "Cohort of patients",
+		"datasetAliases": []interface{}{"COPD study", "Asthma cohort"},
+	}
+	fields := []string{"title", "datasetAliases"}
+
+	text, field := suggestionText(source, fields, "asth")
+	assert.EqualValues(t, "Asthma cohort", text)
+	assert.EqualValues(t, "datasetAliases", field)
+
+	text, field = suggestionText(source, fields, "xyz")
+	assert.EqualValues(t, "Cohort of patients", text)
+	assert.EqualValues(t, "title", field)
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
 )
@@ -42,13 +43,16 @@ func (mt *MockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 }
 
 func MockElasticClient() *elasticsearch.Client {
+	return MockElasticClientWithLatency(0)
+}
+
+// MockElasticClientWithLatency returns a mock client which waits for latency
+// before answering each request, standing in for the connection and queueing
+// overhead of a real cluster in benchmarks. A multi search is answered with
+// the search response once for each search in its body.
+func MockElasticClientWithLatency(latency time.Duration) *elasticsearch.Client {
 	mocktrans := MockTransport{}
-	var responseBody string
-	mocktrans.RoundTripFn = func(req *http.Request) (*http.Response, error) {
-		if req.Method == "PUT" {
-			responseBody = `{"acknowledged": true}`
-		} else {
-			responseBody = `{
+	searchBody := `{
 				"took": 3,
 				"timed_out": false,
 				"_shards": {},
@@ -57,6 +61,17 @@ func MockElasticClient() *elasticsearch.Client {
 				},
 				"aggregations": {}
 			}`
+	mocktrans.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+		time.Sleep(latency)
+		var responseBody string
+		if req.Method == "PUT" {
+			responseBody = `{"acknowledged": true}`
+		} else if strings.HasSuffix(req.URL.Path, "/_msearch") {
+			body, _ := io.ReadAll(req.Body)
+			searches := strings.Count(string(body), "\n") / 2
+			responseBody = `{"responses": [` + strings.TrimSuffix(strings.Repeat(searchBody+",", searches), ",") + `]}`
+		} else {
+			responseBody = searchBody
 		}
 		resp := &http.Response{
 			StatusCode: http.StatusOK,